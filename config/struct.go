@@ -5,6 +5,7 @@ type Config struct {
 	App         App         `yaml:"app" mapstructure:"app" validate:"required"`
 	TelegramBot TelegramBot `yaml:"telegramBot" mapstructure:"telegramBot" validate:"required"`
 	MediaSaver  MediaSaver  `yaml:"mediaSaver" mapstructure:"mediaSaver" validate:"required"`
+	FFmpeg      FFmpeg      `yaml:"ffmpeg" mapstructure:"ffmpeg" validate:"required"`
 	Log         Log         `yaml:"log" mapstructure:"log" validate:"required"`
 	Postgres    Postgres    `yaml:"postgres" mapstructure:"postgres" validate:"required"`
 	Redis       Redis       `yaml:"redis" mapstructure:"redis" validate:"required"`
@@ -16,9 +17,91 @@ type App struct {
 }
 
 type TelegramBot struct {
-	Token    string           `yaml:"token" mapstructure:"token" validate:"required"`
-	LogDebug bool             `yaml:"logDebug" mapstructure:"logDebug"`
-	Proxy    TelegramBotProxy `yaml:"proxy" mapstructure:"proxy"`
+	Token         string                   `yaml:"token" mapstructure:"token" validate:"required"`
+	LogDebug      bool                     `yaml:"logDebug" mapstructure:"logDebug"`
+	Admins        []int64                  `yaml:"admins" mapstructure:"admins"` // Telegram user ids allowed to use the admin commands
+	Proxy         TelegramBotProxy         `yaml:"proxy" mapstructure:"proxy"`
+	APIServer     TelegramBotAPIServer     `yaml:"apiServer" mapstructure:"apiServer"`
+	Inline        TelegramBotInline        `yaml:"inline" mapstructure:"inline"`
+	Group         TelegramBotGroup         `yaml:"group" mapstructure:"group"`
+	Webhook       TelegramBotWebhook       `yaml:"webhook" mapstructure:"webhook"`
+	Payments      TelegramBotPayments      `yaml:"payments" mapstructure:"payments"`
+	Subscriptions TelegramBotSubscriptions `yaml:"subscriptions" mapstructure:"subscriptions"`
+	Referrals     TelegramBotReferrals     `yaml:"referrals" mapstructure:"referrals"`
+	RateLimit     TelegramBotRateLimit     `yaml:"rateLimit" mapstructure:"rateLimit"`
+}
+
+// TelegramBotRateLimit are short-term limits of downloads per account and per chat against floods of links,
+// on top of the plan quota. They are kept in Redis so they hold across replicas.
+type TelegramBotRateLimit struct {
+	Enabled        bool `yaml:"enabled" mapstructure:"enabled"`
+	PerMinute      int  `yaml:"perMinute" mapstructure:"perMinute" validate:"gte=0"`           // Downloads an account starts in any 60 seconds, plans add their burst allowance. 0 is unlimited
+	Concurrent     int  `yaml:"concurrent" mapstructure:"concurrent" validate:"gte=0"`         // Downloads of an account running at once, 0 is unlimited
+	ChatPerMinute  int  `yaml:"chatPerMinute" mapstructure:"chatPerMinute" validate:"gte=0"`   // Same for a group or channel, whoever sent the links
+	ChatConcurrent int  `yaml:"chatConcurrent" mapstructure:"chatConcurrent" validate:"gte=0"` // Same for a group or channel, whoever sent the links
+}
+
+// TelegramBotReferrals is the bonus the referrer and the referee both get once the referee made a download
+type TelegramBotReferrals struct {
+	BonusDownloads int    `yaml:"bonusDownloads" mapstructure:"bonusDownloads" validate:"gte=0"` // Extra downloads on top of the plan limit, kept until used
+	BonusDays      int    `yaml:"bonusDays" mapstructure:"bonusDays" validate:"gte=0"`           // Days of BonusPlan, only for accounts on it or on the free plan
+	BonusPlan      string `yaml:"bonusPlan" mapstructure:"bonusPlan" validate:"required_unless=BonusDays 0"`
+}
+
+// TelegramBotSubscriptions is how often subscriptions are expired, when users are reminded of their end
+// and the trial new accounts get
+type TelegramBotSubscriptions struct {
+	CheckInterval int    `yaml:"checkInterval" mapstructure:"checkInterval" validate:"gt=0"` // Seconds between checks
+	ReminderDays  int    `yaml:"reminderDays" mapstructure:"reminderDays" validate:"gte=0"`  // Days before the end of a paid plan or trial, 0 disables reminders
+	TrialPlan     string `yaml:"trialPlan" mapstructure:"trialPlan" validate:"required_unless=TrialDays 0"`
+	TrialDays     int    `yaml:"trialDays" mapstructure:"trialDays" validate:"gte=0"` // 0 disables trials
+}
+
+// TelegramBotPayments selects the provider plans are sold with, providers other than Telegram Stars report payments in webhooks
+type TelegramBotPayments struct {
+	Provider   string                  `yaml:"provider" mapstructure:"provider" validate:"oneof=stars fake"`
+	ListenAddr string                  `yaml:"listenAddr" mapstructure:"listenAddr"` // Serves payment webhooks in polling mode, the webhook server serves them in webhook mode
+	Fake       TelegramBotPaymentsFake `yaml:"fake" mapstructure:"fake"`
+}
+
+// TelegramBotPaymentsFake is an in-memory provider for local development and staging, it never moves money
+type TelegramBotPaymentsFake struct {
+	Url      string `yaml:"url" mapstructure:"url" validate:"omitempty,url"` // Public URL of /payments/fake, checkout links point to it
+	Secret   string `yaml:"secret" mapstructure:"secret"`                    // Signs the webhooks
+	Currency string `yaml:"currency" mapstructure:"currency" validate:"omitempty,len=3"`
+}
+
+// TelegramBotWebhook receives updates on an embedded HTTP(S) server instead of long polling
+type TelegramBotWebhook struct {
+	Enabled          bool   `yaml:"enabled" mapstructure:"enabled"`
+	Url              string `yaml:"url" mapstructure:"url" validate:"required_if=Enabled true,omitempty,url"`           // Public URL Telegram posts updates to, its path is served
+	ListenAddr       string `yaml:"listenAddr" mapstructure:"listenAddr" validate:"required_if=Enabled true"`           // e.g. ":8443"
+	SecretToken      string `yaml:"secretToken" mapstructure:"secretToken" validate:"required_if=Enabled true,max=256"` // Sent by Telegram in X-Telegram-Bot-Api-Secret-Token
+	CertFile         string `yaml:"certFile" mapstructure:"certFile"`                                                   // Optional, serves TLS and is uploaded to Telegram for self-signed certificates
+	KeyFile          string `yaml:"keyFile" mapstructure:"keyFile" validate:"required_with=CertFile"`
+	DeleteOnShutdown bool   `yaml:"deleteOnShutdown" mapstructure:"deleteOnShutdown"`
+}
+
+// TelegramBotGroup is the behaviour of the bot in groups and channels, admins can change it per chat with /chat
+type TelegramBotGroup struct {
+	TriggerMode string `yaml:"triggerMode" mapstructure:"triggerMode" validate:"oneof=auto mention command"` // Default for new chats
+	Limit       int64  `yaml:"limit" mapstructure:"limit" validate:"gte=0"`                                  // Downloads per chat per period, 0 is unlimited
+	DaysToReset int    `yaml:"daysToReset" mapstructure:"daysToReset" validate:"gte=0"`                      // Length of a period, 0 never resets
+}
+
+// TelegramBotInline enables answering "@bot <url>" inline queries in any chat
+type TelegramBotInline struct {
+	Enabled     bool  `yaml:"enabled" mapstructure:"enabled"`
+	CacheChatID int64 `yaml:"cacheChatID" mapstructure:"cacheChatID" validate:"required_if=Enabled true"` // Chat media is uploaded to, to get file ids
+}
+
+// TelegramBotAPIServer points the bot at a self-hosted telegram-bot-api server instead of api.telegram.org
+type TelegramBotAPIServer struct {
+	Enabled     bool   `yaml:"enabled" mapstructure:"enabled"`
+	Url         string `yaml:"url" mapstructure:"url" validate:"required_if=Enabled true,omitempty,url"`
+	LocalMode   bool   `yaml:"localMode" mapstructure:"localMode"`                                     // Server runs with --local, files can be sent by file:// path
+	SpoolDir    string `yaml:"spoolDir" mapstructure:"spoolDir" validate:"required_if=LocalMode true"` // Must be readable by the server at the same path
+	MaxFileSize int64  `yaml:"maxFileSize" mapstructure:"maxFileSize" validate:"gte=0,lte=2000"`       // MB
 }
 
 type TelegramBotProxy struct {
@@ -37,6 +120,14 @@ type MediaSaver struct {
 	RetryCount        int      `yaml:"retryCount" mapstructure:"retryCount" validate:"gte=0"`
 	Timeout           int      `yaml:"timeout" mapstructure:"timeout" validate:"gt=0"`
 	MaxGroupMediaSize int64    `yaml:"maxGroupMediaSize" mapstructure:"maxGroupMediaSize" validate:"gt=0"`
+	AudioFormat       string   `yaml:"audioFormat" mapstructure:"audioFormat" validate:"oneof=m4a mp3 ogg"`
+	MaxConcurrent     int      `yaml:"maxConcurrent" mapstructure:"maxConcurrent" validate:"gte=0"`
+}
+
+type FFmpeg struct {
+	Path      string `yaml:"path" mapstructure:"path"`
+	ProbePath string `yaml:"probePath" mapstructure:"probePath"`
+	Timeout   int    `yaml:"timeout" mapstructure:"timeout" validate:"gt=0"`
 }
 
 type Log struct {
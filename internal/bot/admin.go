@@ -0,0 +1,470 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/utils/download"
+
+	"github.com/eko/gocache/lib/v4/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// Bans are cached, the ban check runs on every update
+	bannedCacheTTL = 10 * time.Minute
+	// Platforms listed in /stats
+	maxStatsPlatforms = 5
+)
+
+var errAccountNotFound = errors.New("account not found")
+
+// isAdmin reports whether a Telegram user may use the admin commands
+func isAdmin(telegramID int64) bool {
+	return slices.Contains(config.GetConfig().TelegramBot.Admins, telegramID)
+}
+
+// getUpdateUser returns the user who sent an update, nil for updates without one, e.g. channel posts
+func getUpdateUser(update *models.Update) *models.User {
+	switch {
+	case update.Message != nil:
+		return update.Message.From
+	case update.CallbackQuery != nil:
+		return &update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	default:
+		return nil
+	}
+}
+
+// banMiddleware drops every update of banned users before it reaches a handler
+func (b *DefaultBot) banMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, botClient *bot.Bot, update *models.Update) {
+		if from := getUpdateUser(update); from != nil && !isAdmin(from.ID) && b.isBanned(ctx, from.ID) {
+			return
+		}
+
+		next(ctx, botClient, update)
+	}
+}
+
+// isBanned reports whether a Telegram user is banned, users the ban can't be checked for are let through
+func (b *DefaultBot) isBanned(ctx context.Context, telegramID int64) bool {
+	key := getBannedKey(telegramID)
+
+	var banned bool
+	if _, err := b.cacheManager.Get(ctx, key, &banned); err == nil {
+		return banned
+	}
+
+	account, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		TelegramID: pgtype.Int8{Int64: telegramID, Valid: true},
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Sugar().Errorf("Failed to check ban of user %d: %v", telegramID, err)
+		return false
+	}
+	banned = account.BannedAt.Valid
+
+	if err = b.cacheManager.Set(ctx, key, banned, store.WithExpiration(bannedCacheTTL)); err != nil {
+		logger.Log.Sugar().Errorf("Failed to cache ban of user %d: %v", telegramID, err)
+	}
+
+	return banned
+}
+
+func getBannedKey(telegramID int64) string {
+	return fmt.Sprintf("banned:%d", telegramID)
+}
+
+// findAccount looks up an account by Telegram id or @username
+func (b *DefaultBot) findAccount(ctx context.Context, query string) (sqlc.AccountTelegram, error) {
+	var account sqlc.AccountTelegram
+	var err error
+	if username, ok := strings.CutPrefix(query, "@"); ok {
+		account, err = b.storage.GetAccountTelegramByUsername(ctx, username)
+	} else {
+		telegramID, parseErr := strconv.ParseInt(query, 10, 64)
+		if parseErr != nil {
+			return sqlc.AccountTelegram{}, errAccountNotFound
+		}
+		account, err = b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+			TelegramID: pgtype.Int8{Int64: telegramID, Valid: true},
+		})
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return sqlc.AccountTelegram{}, errAccountNotFound
+	}
+	return account, err
+}
+
+// replyAccountError explains why the account of an admin command could not be loaded
+func (b *DefaultBot) replyAccountError(ctx context.Context, loc *i18n.Localizer, update *models.Update, query string, err error) {
+	if errors.Is(err, errAccountNotFound) {
+		b.reply(ctx, update, loc.T("admin.user_not_found", query))
+		return
+	}
+
+	logger.Log.Sugar().Errorf("Failed to find account %s: %v", query, err)
+	b.reply(ctx, update, loc.T("admin.failed", err))
+}
+
+// replyAdminUsage shows the arguments of an admin command after it was called with wrong ones
+func (b *DefaultBot) replyAdminUsage(ctx context.Context, loc *i18n.Localizer, update *models.Update, name string) {
+	for _, command := range b.commands() {
+		if command.Name == name {
+			b.reply(ctx, update, loc.T("admin.usage", name, loc.T(command.Usage)))
+			return
+		}
+	}
+}
+
+func getAccountName(account sqlc.AccountTelegram) string {
+	name := strings.TrimSpace(account.FirstName + " " + account.LastName)
+	if account.Username.Valid {
+		name += " (@" + account.Username.String + ")"
+	}
+	return name
+}
+
+func (b *DefaultBot) handleStats(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	days := 1
+	if args != "" {
+		var err error
+		if days, err = strconv.Atoi(args); err != nil || days <= 0 {
+			b.replyAdminUsage(ctx, loc, update, "stats")
+			return
+		}
+	}
+	since := pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, -days), Valid: true}
+
+	users, err := b.storage.CountAccountTelegrams(ctx, sqlc.CountAccountTelegramsParams{})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to count accounts: %v", err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	newUsers, err := b.storage.CountAccountTelegrams(ctx, sqlc.CountAccountTelegramsParams{CreatedAtFrom: since})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to count new accounts: %v", err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	requests, err := b.storage.GetMediaRequestStats(ctx, since)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get request stats: %v", err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	platforms, err := b.storage.ListMediaRequestPlatformStats(ctx, sqlc.ListMediaRequestPlatformStatsParams{
+		CreatedAtFrom: since,
+		Limit:         maxStatsPlatforms,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get platform stats: %v", err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	plans, err := b.storage.CountActiveSubscriptionsByPlan(ctx)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to count subscriptions: %v", err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.stats",
+		loc.N("duration.days", int64(days)),
+		users, newUsers,
+		requests.Total, requests.Succeeded, getPercent(requests.Succeeded, requests.Total), requests.Failed,
+		float64(requests.AvgDurationMs)/1000, download.ByteCountBinary(requests.Size),
+	))
+
+	if len(platforms) > 0 {
+		sb.WriteString(loc.T("admin.stats.platforms"))
+		for _, platform := range platforms {
+			title := SaverType(platform.SaverType).Title()
+			if title == "" {
+				// Links no saver accepted
+				title = loc.T("admin.stats.unsupported")
+			}
+			fmt.Fprintf(&sb, "• %s: %d (%.1f%%)\n", title, platform.Total, getPercent(platform.Succeeded, platform.Total))
+		}
+	}
+
+	if len(plans) > 0 {
+		sb.WriteString(loc.T("admin.stats.plans"))
+		for _, plan := range plans {
+			fmt.Fprintf(&sb, "• %s: %d\n", getPlanTitle(plan.PlanID), plan.Count)
+		}
+	}
+
+	b.reply(ctx, update, sb.String())
+}
+
+// getPercent returns the share of part in total in percent, 0 if total is 0
+func getPercent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+func (b *DefaultBot) handleUser(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	if args == "" {
+		b.replyAdminUsage(ctx, loc, update, "user")
+		return
+	}
+
+	account, err := b.findAccount(ctx, args)
+	if err != nil {
+		b.replyAccountError(ctx, loc, update, args, err)
+		return
+	}
+
+	subscription, err := b.GetActiveSubscription(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get subscription of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	usages, err := b.storage.ListAccountUsages(ctx, sqlc.ListAccountUsagesParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+		Limit:     100,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to list usages of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	requests, err := b.storage.CountMediaRequests(ctx, sqlc.CountMediaRequestsParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to count requests of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	banned := loc.T("admin.no")
+	if account.BannedAt.Valid {
+		banned = loc.T("admin.banned_since", account.BannedAt.Time.Format(time.DateTime))
+	}
+
+	ends := loc.T("admin.never")
+	if subscription.EndDate.Valid {
+		ends = subscription.EndDate.Time.Format(time.DateOnly)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("admin.user",
+		getAccountName(account), account.ID, account.TelegramID, account.LanguageCode,
+		account.CreatedAt.Time.Format(time.DateOnly), banned,
+		getPlanTitle(subscription.PlanID), loc.T("subscription.status."+strings.ToLower(string(subscription.Status))), ends,
+		requests,
+	))
+	for _, usage := range usages {
+		periodUsage, err := getPeriodUsage(ctx, b.storage.Queries, usage)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to sum usage of account %d: %v", account.ID, err)
+			b.reply(ctx, update, loc.T("admin.failed", err))
+			return
+		}
+
+		sb.WriteString(loc.T("admin.user.usage", loc.T(getFeatureTitle(usage.Feature)),
+			periodUsage.Requests, periodUsage.Items, download.ByteCountBinary(periodUsage.Bytes)))
+	}
+
+	b.reply(ctx, update, sb.String())
+}
+
+// handleGrant replaces the active subscription of an account with a plan for a number of days, 0 days never ends
+func (b *DefaultBot) handleGrant(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 {
+		b.replyAdminUsage(ctx, loc, update, "grant")
+		return
+	}
+
+	days, err := strconv.Atoi(fields[2])
+	if err != nil || days < 0 {
+		b.replyAdminUsage(ctx, loc, update, "grant")
+		return
+	}
+
+	planID, err := b.findPlan(ctx, fields[1])
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to find plan %s: %v", fields[1], err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+	if planID == "" {
+		plans, _ := b.storage.ListPlans(ctx, sqlc.ListPlansParams{Limit: 100})
+		b.reply(ctx, update, loc.T("admin.unknown_plan", fields[1], strings.Join(plans, ", ")))
+		return
+	}
+
+	account, err := b.findAccount(ctx, fields[0])
+	if err != nil {
+		b.replyAccountError(ctx, loc, update, fields[0], err)
+		return
+	}
+
+	subscription, err := b.grantSubscription(ctx, account.ID, planID, days)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to grant plan %s to account %d: %v", planID, account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	logger.Log.Sugar().Infof("Admin %d granted plan %s to account %d for %d days", update.Message.From.ID, planID, account.ID, days)
+
+	userLoc := b.getAccountLocalizer(ctx, account)
+	if subscription.EndDate.Valid {
+		end := subscription.EndDate.Time.Format(time.DateOnly)
+		b.reply(ctx, update, loc.T("admin.granted", getPlanTitle(planID), getAccountName(account), end))
+		b.notify(ctx, account.TelegramID, userLoc.T("admin.grant_notice", getPlanTitle(planID), end))
+	} else {
+		b.reply(ctx, update, loc.T("admin.granted_forever", getPlanTitle(planID), getAccountName(account)))
+		b.notify(ctx, account.TelegramID, userLoc.T("admin.grant_notice_forever", getPlanTitle(planID)))
+	}
+}
+
+// findPlan returns the id of a plan given by id or title, e.g. "PlanPro" or "pro", empty if there is none
+func (b *DefaultBot) findPlan(ctx context.Context, name string) (string, error) {
+	plans, err := b.storage.ListPlans(ctx, sqlc.ListPlansParams{Limit: 100})
+	if err != nil {
+		return "", err
+	}
+
+	for _, plan := range plans {
+		if strings.EqualFold(plan, name) || strings.EqualFold(getPlanTitle(plan), name) {
+			return plan, nil
+		}
+	}
+
+	return "", nil
+}
+
+// grantSubscription cancels the active subscriptions of an account and starts one on the plan
+func (b *DefaultBot) grantSubscription(ctx context.Context, accountID int64, planID string, days int) (sqlc.SubscriptionSubscription, error) {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	if _, err = lockSubscriptions(ctx, txStorage, accountID); err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+
+	var end pgtype.Timestamptz
+	if days > 0 {
+		end = pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, days), Valid: true}
+	}
+
+	subscription, err := startSubscription(ctx, txStorage, accountID, planID, end)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+
+	return subscription, txStorage.Commit(ctx)
+}
+
+func (b *DefaultBot) handleBan(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	b.setBanned(ctx, loc, update, "ban", args, true)
+}
+
+func (b *DefaultBot) handleUnban(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	b.setBanned(ctx, loc, update, "unban", args, false)
+}
+
+func (b *DefaultBot) setBanned(ctx context.Context, loc *i18n.Localizer, update *models.Update, command, query string, banned bool) {
+	if query == "" {
+		b.replyAdminUsage(ctx, loc, update, command)
+		return
+	}
+
+	account, err := b.findAccount(ctx, query)
+	if err != nil {
+		b.replyAccountError(ctx, loc, update, query, err)
+		return
+	}
+
+	if banned && isAdmin(account.TelegramID) {
+		b.reply(ctx, update, loc.T("admin.cant_ban_admin"))
+		return
+	}
+
+	if _, err = b.storage.SetAccountTelegramBanned(ctx, sqlc.SetAccountTelegramBannedParams{ID: account.ID, Banned: banned}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to set ban of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	// Takes effect right away instead of after the cached state expires
+	if err = b.cacheManager.Set(ctx, getBannedKey(account.TelegramID), banned, store.WithExpiration(bannedCacheTTL)); err != nil {
+		logger.Log.Sugar().Errorf("Failed to cache ban of user %d: %v", account.TelegramID, err)
+	}
+
+	logger.Log.Sugar().Infof("Admin %d set ban of account %d to %t", update.Message.From.ID, account.ID, banned)
+
+	if banned {
+		b.reply(ctx, update, loc.T("admin.banned", getAccountName(account)))
+	} else {
+		b.reply(ctx, update, loc.T("admin.unbanned", getAccountName(account)))
+	}
+}
+
+func (b *DefaultBot) handleResetUsage(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	if args == "" {
+		b.replyAdminUsage(ctx, loc, update, "resetusage")
+		return
+	}
+
+	account, err := b.findAccount(ctx, args)
+	if err != nil {
+		b.replyAccountError(ctx, loc, update, args, err)
+		return
+	}
+
+	count, err := b.storage.ResetAccountUsages(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to reset usage of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	logger.Log.Sugar().Infof("Admin %d reset usage of account %d", update.Message.From.ID, account.ID)
+	b.reply(ctx, update, loc.T("admin.usage_reset", count, getAccountName(account)))
+}
+
+// notify sends a message to a user outside of a conversation, e.g. after an admin changed their plan
+func (b *DefaultBot) notify(ctx context.Context, telegramID int64, text string) {
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: telegramID,
+		Text:   text,
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to notify user %d: %v", telegramID, err)
+	}
+}
@@ -7,15 +7,22 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
 	"github.com/codeonbeans/botfetchr/internal/client/browserpool"
+	"github.com/codeonbeans/botfetchr/internal/client/ffmpeg"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
 	"github.com/codeonbeans/botfetchr/internal/client/mediasaver/instagram"
 	"github.com/codeonbeans/botfetchr/internal/client/mediasaver/vk"
 	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
 	"github.com/codeonbeans/botfetchr/internal/storage"
 
 	"github.com/corpix/uarand"
@@ -23,12 +30,15 @@ import (
 	"github.com/go-rod/rod"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/net/proxy"
 )
 
 type MediaSaver interface {
 	GetUA() string
 	GetFilename(ogUrl, directUrl string) string
+	GetMetadata() mediasaverbase.Metadata
+	GetVariants() []mediasaverbase.Variant
 	GetVideoURLs(ctx context.Context, browser *rod.Browser, url string) ([]string, error)
 	IsValidURL(url string) bool
 
@@ -37,30 +47,39 @@ type MediaSaver interface {
 	SetTimeout(timeout time.Duration)
 }
 
-type MediaSaverFactory func() (MediaSaver, error)
+type MediaSaverFactory func(settings sqlc.AccountSetting) (MediaSaver, error)
 
 var mediaSaverFactory = map[SaverType]MediaSaverFactory{
-	SaverTypeInstagram: func() (MediaSaver, error) {
+	SaverTypeInstagram: func(settings sqlc.AccountSetting) (MediaSaver, error) {
 		client := instagram.NewClient()
-		configMediaSaver(client)
+		configMediaSaver(client, settings)
 		return client, nil
 	},
-	SaverTypeVK: func() (MediaSaver, error) {
+	SaverTypeVK: func(settings sqlc.AccountSetting) (MediaSaver, error) {
 		client := vk.NewClient()
-		configMediaSaver(client)
+		configMediaSaver(client, settings)
 		return client, nil
 	},
 }
 
 type DefaultBot struct {
 	*bot.Bot
-	subscriptionMux sync.Mutex
-	storage         *storage.Storage
-	cacheManager    *marshaler.Marshaler
-	browserPool     browserpool.Client
+	storage        *storage.Storage
+	cacheManager   *marshaler.Marshaler
+	redisClient    redis.UniversalClient // Variant claims and rate limits, the cache manager has no atomic updates
+	browserPool    browserpool.Client
+	ffmpeg         *ffmpeg.Client
+	httpClient     *http.Client
+	downloadQueue  *downloadQueue
+	inlineRequests sync.Map    // Urls being resolved for inline queries, by the id of the account that queried them
+	broadcasting   atomic.Bool // Set while a broadcast is sent, one runs at a time
+	// Provider plans are sold with, and every provider payments can come from by name
+	paymentProvider  PaymentProvider
+	paymentProviders map[string]PaymentProvider
+	me               *models.User
 }
 
-func New(store *storage.Storage, cacheManager *marshaler.Marshaler) (*DefaultBot, error) {
+func New(store *storage.Storage, cacheManager *marshaler.Marshaler, redisClient redis.UniversalClient) (*DefaultBot, error) {
 
 	logger.Log.Sugar().Info("Initializing bot...")
 
@@ -107,23 +126,24 @@ func New(store *storage.Storage, cacheManager *marshaler.Marshaler) (*DefaultBot
 		defaultBot = &DefaultBot{}
 	)
 
+	// Assign http client, also used for requests go-telegram/bot can't build
+	defaultBot.httpClient = httpClient
 	// Assign storage
 	defaultBot.storage = store
 	// Assign cache manager
 	defaultBot.cacheManager = cacheManager
+	// Assign redis client
+	defaultBot.redisClient = redisClient
+	// Assign ffmpeg client
+	defaultBot.ffmpeg = ffmpeg.NewClient(config.GetConfig().FFmpeg.Path, config.GetConfig().FFmpeg.ProbePath)
+	// Assign download queue
+	defaultBot.downloadQueue = newDownloadQueue(config.GetConfig().MediaSaver.MaxConcurrent)
 
 	opts := []bot.Option{
 		bot.WithDefaultHandler(func(ctx context.Context, bot *bot.Bot, update *models.Update) {
-			// add recover panic here
-			defer func() {
-				if r := recover(); r != nil {
-					logger.Log.Sugar().Errorf("Recovered from panic: %v", r)
-					debug.PrintStack()
-				}
-			}()
-
 			defaultBot.Handler(ctx, update)
 		}),
+		bot.WithMiddlewares(recoverMiddleware, defaultBot.banMiddleware),
 		bot.WithHTTPClient(time.Minute, httpClient),
 		bot.WithDebugHandler(logger.Log.Sugar().Debugf),
 	}
@@ -132,12 +152,32 @@ func New(store *storage.Storage, cacheManager *marshaler.Marshaler) (*DefaultBot
 		opts = append(opts, bot.WithDebug())
 	}
 
+	// Use self-hosted Bot API server if enabled
+	if config.GetConfig().TelegramBot.APIServer.Enabled {
+		logger.Log.Sugar().Infof("Using Telegram Bot API server at %s", config.GetConfig().TelegramBot.APIServer.Url)
+		opts = append(opts, bot.WithServerURL(config.GetConfig().TelegramBot.APIServer.Url))
+	}
+
 	// Assign bot client
 	defaultBot.Bot, err = bot.New(config.GetConfig().TelegramBot.Token, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot client: %w", err)
 	}
 
+	// The bot username is needed to recognize mentions and commands addressed to it in groups
+	defaultBot.me, err = defaultBot.GetMe(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bot info: %w", err)
+	}
+
+	// Assign payment providers
+	if err = defaultBot.setupPayments(); err != nil {
+		return nil, fmt.Errorf("failed to set up payments: %w", err)
+	}
+
+	// Register command handlers, other messages go to the default handler
+	defaultBot.registerCommands()
+
 	// Assign browser pool
 	defaultBot.browserPool, err = browserpool.NewClient(browserpool.Config{
 		Headless:      config.GetConfig().BrowserPool.Headless,
@@ -152,9 +192,9 @@ func New(store *storage.Storage, cacheManager *marshaler.Marshaler) (*DefaultBot
 	return defaultBot, nil
 }
 
-func (b *DefaultBot) GetMediaSaver(url string) (MediaSaver, error) {
+func (b *DefaultBot) GetMediaSaver(url string, settings sqlc.AccountSetting) (MediaSaver, error) {
 	for saverType, factory := range mediaSaverFactory {
-		client, err := factory()
+		client, err := factory(settings)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create client for type %s: %w", saverType, err)
 		}
@@ -164,12 +204,56 @@ func (b *DefaultBot) GetMediaSaver(url string) (MediaSaver, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no valid video saver found for URL: %s", url)
+	return nil, model.Errorf(model.ErrorKindUnsupportedURL, "no valid video saver found for URL: %s", url)
+}
+
+// getSaverType returns the type of the saver accepting a url, SaverTypeUnknown if none does
+func getSaverType(url string) SaverType {
+	for saverType, factory := range mediaSaverFactory {
+		client, err := factory(getDefaultSettings())
+		if err == nil && client.IsValidURL(url) {
+			return saverType
+		}
+	}
+
+	return SaverTypeUnknown
 }
 
-func (b *DefaultBot) Start(ctx context.Context) {
+// Start receives updates with long polling, or on the webhook server if enabled, until the context is done
+func (b *DefaultBot) Start(ctx context.Context) error {
 	logger.Log.Sugar().Info("Starting Telegram bot...")
+
+	if err := b.setMyCommands(ctx); err != nil {
+		logger.Log.Sugar().Errorf("Failed to set command menu: %v", err)
+	}
+
+	go b.runSubscriptionScheduler(ctx)
+
+	if config.GetConfig().TelegramBot.Webhook.Enabled {
+		return b.startWebhook(ctx)
+	}
+
+	// Payment webhooks are served on the webhook server in webhook mode
+	if addr := config.GetConfig().TelegramBot.Payments.ListenAddr; addr != "" {
+		go b.servePayments(ctx, addr)
+	}
+
 	b.Bot.Start(ctx)
+	return nil
+}
+
+// recoverMiddleware keeps the bot running if a handler panics
+func recoverMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Log.Sugar().Errorf("Recovered from panic: %v", r)
+				debug.PrintStack()
+			}
+		}()
+
+		next(ctx, b, update)
+	}
 }
 
 func getUA() string {
@@ -191,12 +275,44 @@ func getUA() string {
 	return uas[randomIndex.Int64()]
 }
 
-func configMediaSaver(mediaSaver MediaSaver) {
+// getMaxMediaSize returns the maximum size in bytes of a single media file and of a media group
+//
+// The public Bot API caps uploads at 50MB, a self-hosted server accepts up to 2000MB for plans with large files
+func getMaxMediaSize(features PlanFeatures) int64 {
+	if config.GetConfig().TelegramBot.APIServer.Enabled && config.GetConfig().TelegramBot.APIServer.MaxFileSize > 0 &&
+		features.Has(model.FeatureLargeFiles) {
+		return config.GetConfig().TelegramBot.APIServer.MaxFileSize * 1024 * 1024
+	}
+
+	return config.GetConfig().MediaSaver.MaxGroupMediaSize * 1024 * 1024
+}
+
+// isLocalAPIServer reports whether media can be sent to the Bot API server by file:// path
+func isLocalAPIServer() bool {
+	return config.GetConfig().TelegramBot.APIServer.Enabled && config.GetConfig().TelegramBot.APIServer.LocalMode
+}
+
+// getSpoolDir returns the directory where downloads are written to disk before sending
+func getSpoolDir() string {
+	if isLocalAPIServer() {
+		return config.GetConfig().TelegramBot.APIServer.SpoolDir
+	}
+
+	return filepath.Join(os.TempDir(), config.GetConfig().App.Name)
+}
+
+// configMediaSaver applies the global media saver config, overridden by the account settings
+func configMediaSaver(mediaSaver MediaSaver, settings sqlc.AccountSetting) {
 	if mediaSaver == nil {
 		return
 	}
 
+	quality := config.GetConfig().MediaSaver.Quality
+	if settings.Quality.Valid {
+		quality = settings.Quality.String
+	}
+
 	mediaSaver.SetUserAgent(getUA())
-	mediaSaver.SetQuality(config.GetConfig().MediaSaver.Quality)
+	mediaSaver.SetQuality(quality)
 	mediaSaver.SetTimeout(time.Duration(config.GetConfig().MediaSaver.Timeout) * time.Second)
 }
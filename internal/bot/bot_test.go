@@ -0,0 +1,87 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDatabaseEnv names the database the tests run against, migrated with goose. Tests that need it are skipped without.
+const testDatabaseEnv = "BOTFETCHR_TEST_DATABASE_URL"
+
+func newTestStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+
+	url := os.Getenv(testDatabaseEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	db, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	return storage.NewStorage(db)
+}
+
+// newTestAccount creates a bot account, negative ids can't clash with real Telegram users
+func newTestAccount(t *testing.T, store *storage.Storage) sqlc.AccountTelegram {
+	t.Helper()
+	ctx := context.Background()
+
+	account, err := store.CreateAccountTelegram(ctx, sqlc.CreateAccountTelegramParams{
+		TelegramID: -time.Now().UnixNano(),
+		IsBot:      true,
+		FirstName:  t.Name(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.DeleteAccountTelegram(ctx, account.ID); err != nil {
+			t.Errorf("failed to delete account: %v", err)
+		}
+	})
+	return account
+}
+
+// newTestPlan creates a plan without features, its prices, features and subscriptions go with it
+func newTestPlan(t *testing.T, store *storage.Storage) string {
+	t.Helper()
+	ctx := context.Background()
+
+	planID := fmt.Sprintf("PlanTest%d", time.Now().UnixNano())
+	if _, err := store.CreatePlan(ctx, planID); err != nil {
+		t.Fatalf("failed to create plan: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.DeletePlan(ctx, planID); err != nil {
+			t.Errorf("failed to delete plan: %v", err)
+		}
+	})
+	return planID
+}
+
+// newTestSubscription puts an account on a plan without end
+func newTestSubscription(t *testing.T, store *storage.Storage, accountID int64, planID string) {
+	t.Helper()
+
+	if _, err := store.CreateSubscription(context.Background(), sqlc.CreateSubscriptionParams{
+		AccountID: accountID,
+		PlanID:    planID,
+		Status:    sqlc.SubscriptionStatusesACTIVE,
+		StartDate: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+}
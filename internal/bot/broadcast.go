@@ -0,0 +1,116 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// Telegram allows about 30 messages per second to different chats, some are left for regular replies
+	broadcastRate = 25
+	// Accounts loaded per page
+	broadcastPageSize = 500
+)
+
+// handleBroadcast sends a text, or a copy of the message replied to, to every account that is not banned.
+// Sending takes a while for many accounts, the admin is told once it is done.
+func (b *DefaultBot) handleBroadcast(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	msg := update.Message
+	if args == "" && msg.ReplyToMessage == nil {
+		b.replyAdminUsage(ctx, loc, update, "broadcast")
+		return
+	}
+
+	if !b.broadcasting.CompareAndSwap(false, true) {
+		b.reply(ctx, update, loc.T("admin.broadcast_running"))
+		return
+	}
+
+	b.reply(ctx, update, loc.T("admin.broadcast_started"))
+
+	// Replied to messages are copied with their media and formatting
+	send := func(chatID int64) error {
+		_, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: args})
+		return err
+	}
+	if msg.ReplyToMessage != nil {
+		send = func(chatID int64) error {
+			_, err := b.CopyMessage(ctx, &bot.CopyMessageParams{
+				ChatID:     chatID,
+				FromChatID: msg.Chat.ID,
+				MessageID:  msg.ReplyToMessage.ID,
+			})
+			return err
+		}
+	}
+
+	go func() {
+		defer b.broadcasting.Store(false)
+
+		sent, failed, err := b.broadcast(ctx, send)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Broadcast stopped: %v", err)
+		}
+		logger.Log.Sugar().Infof("Admin %d broadcast a message to %d accounts, %d failed", msg.From.ID, sent, failed)
+
+		b.reply(ctx, update, loc.T("admin.broadcast_done", sent, failed))
+	}()
+}
+
+// broadcast calls send for the chat of every account that is not banned, at most broadcastRate times per second.
+// Accounts that blocked the bot count as failed.
+func (b *DefaultBot) broadcast(ctx context.Context, send func(chatID int64) error) (int, int, error) {
+	ticker := time.NewTicker(time.Second / broadcastRate)
+	defer ticker.Stop()
+
+	var sent, failed int
+	var afterID int64
+	for {
+		recipients, err := b.storage.ListAccountTelegramRecipients(ctx, sqlc.ListAccountTelegramRecipientsParams{
+			AfterID: afterID,
+			Limit:   broadcastPageSize,
+		})
+		if err != nil {
+			return sent, failed, err
+		}
+		if len(recipients) == 0 {
+			return sent, failed, nil
+		}
+
+		for _, recipient := range recipients {
+			select {
+			case <-ctx.Done():
+				return sent, failed, ctx.Err()
+			case <-ticker.C:
+			}
+
+			err := send(recipient.TelegramID)
+
+			// Wait as long as Telegram asks and try once more
+			var tooManyRequests *bot.TooManyRequestsError
+			if errors.As(err, &tooManyRequests) {
+				time.Sleep(time.Duration(tooManyRequests.RetryAfter) * time.Second)
+				err = send(recipient.TelegramID)
+			}
+
+			if err != nil {
+				if !errors.Is(err, bot.ErrorForbidden) {
+					logger.Log.Sugar().Errorf("Failed to broadcast to user %d: %v", recipient.TelegramID, err)
+				}
+				failed++
+			} else {
+				sent++
+			}
+		}
+
+		afterID = recipients[len(recipients)-1].ID
+	}
+}
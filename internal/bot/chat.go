@@ -0,0 +1,267 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Callback data of chat settings buttons is "chat:<key>:<value>"
+const chatCallbackPrefix = "chat:"
+
+const (
+	chatSettingKeyEnabled = "enabled"
+	chatSettingKeyTrigger = "trigger"
+)
+
+// chatSettingOptions are the values of every chat setting, titled by the message "chat.<key>.<value>"
+var chatSettingOptions = map[string][]string{
+	chatSettingKeyEnabled: {"on", "off"},
+	chatSettingKeyTrigger: {string(TriggerModeAuto), string(TriggerModeMention), string(TriggerModeCommand)},
+}
+
+// getOrCreateChat returns the stored settings of a group or channel, creating them from the config on first contact
+func (b *DefaultBot) getOrCreateChat(ctx context.Context, chat models.Chat) (sqlc.AccountChat, error) {
+	accountChat, err := b.storage.GetAccountChat(ctx, sqlc.GetAccountChatParams{
+		ChatID: pgtype.Int8{Int64: chat.ID, Valid: true},
+	})
+	if err == nil {
+		return accountChat, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return sqlc.AccountChat{}, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	// Concurrent first messages of the chat all get the chat created by the first one
+	accountChat, err = b.storage.CreateAccountChat(ctx, sqlc.CreateAccountChatParams{
+		ChatID:      chat.ID,
+		Type:        string(chat.Type),
+		Title:       chat.Title,
+		Enabled:     true,
+		TriggerMode: config.GetConfig().TelegramBot.Group.TriggerMode,
+	})
+	if err != nil {
+		return sqlc.AccountChat{}, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	return accountChat, nil
+}
+
+// getMessageChat returns the chat settings of a message sent in a group or channel, nil for private chats.
+// Returns false if the bot is disabled in the chat or the settings could not be loaded.
+func (b *DefaultBot) getMessageChat(ctx context.Context, msg *models.Message) (*sqlc.AccountChat, bool) {
+	if msg.Chat.Type == models.ChatTypePrivate {
+		return nil, true
+	}
+
+	chat, err := b.getOrCreateChat(ctx, msg.Chat)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get chat %d: %v", msg.Chat.ID, err)
+		return nil, false
+	}
+
+	return &chat, chat.Enabled
+}
+
+// isTriggered reports whether a message in a group or channel asks the bot to download its links
+func (b *DefaultBot) isTriggered(chat sqlc.AccountChat, msg *models.Message) bool {
+	switch TriggerMode(chat.TriggerMode) {
+	case TriggerModeAuto:
+		return true
+	case TriggerModeMention:
+		if msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil && msg.ReplyToMessage.From.ID == b.me.ID {
+			return true
+		}
+		return strings.Contains(strings.ToLower(getMessageText(msg)), "@"+strings.ToLower(b.me.Username))
+	default:
+		// Only /dl, handled by the command router
+		return false
+	}
+}
+
+// isChatAdmin reports whether a user can change the settings of a chat
+func (b *DefaultBot) isChatAdmin(ctx context.Context, chatID, userID int64) (bool, error) {
+	member, err := b.GetChatMember(ctx, &bot.GetChatMemberParams{
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get chat member: %w", err)
+	}
+
+	return member.Type == models.ChatMemberTypeOwner || member.Type == models.ChatMemberTypeAdministrator, nil
+}
+
+func (b *DefaultBot) handleChat(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	msg := update.Message
+	if msg.Chat.Type == models.ChatTypePrivate {
+		b.reply(ctx, update, loc.T("chat.private"))
+		return
+	}
+
+	// Anonymous admins post as the group itself
+	isAdmin := msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID
+	if !isAdmin {
+		var err error
+		if isAdmin, err = b.isChatAdmin(ctx, msg.Chat.ID, msg.From.ID); err != nil {
+			logger.Log.Sugar().Errorf("Failed to check admin of chat %d: %v", msg.Chat.ID, err)
+		}
+	}
+	if !isAdmin {
+		b.reply(ctx, update, loc.T("chat.admins_only"))
+		return
+	}
+
+	chat, err := b.getOrCreateChat(ctx, msg.Chat)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get chat %d: %v", msg.Chat.ID, err)
+		b.reply(ctx, update, loc.T("chat.failed"))
+		return
+	}
+
+	if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      msg.Chat.ID,
+		Text:        getChatSettingsText(loc, chat),
+		ReplyMarkup: getChatSettingsKeyboard(loc, chat),
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: msg.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send chat settings: %v", err)
+	}
+}
+
+// handleChatCallback applies a chat setting pressed by an admin and refreshes the keyboard
+func (b *DefaultBot) handleChatCallback(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	loc := b.getUserLocalizer(ctx, &query.From)
+
+	answer := func(text string) {
+		if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to answer callback query: %v", err)
+		}
+	}
+
+	key, value, ok := strings.Cut(strings.TrimPrefix(query.Data, chatCallbackPrefix), ":")
+	if !ok || !slices.Contains(chatSettingOptions[key], value) || query.Message.Message == nil {
+		answer(loc.T("callback.unknown_setting"))
+		return
+	}
+	msg := query.Message.Message
+
+	isAdmin, err := b.isChatAdmin(ctx, msg.Chat.ID, query.From.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to check admin of chat %d: %v", msg.Chat.ID, err)
+	}
+	if !isAdmin {
+		answer(loc.T("chat.callback.admins_only"))
+		return
+	}
+
+	chat, err := b.getOrCreateChat(ctx, msg.Chat)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get chat %d: %v", msg.Chat.ID, err)
+		answer(loc.T("callback.save_failed"))
+		return
+	}
+
+	params := sqlc.UpdateAccountChatParams{ID: chat.ID}
+	switch key {
+	case chatSettingKeyEnabled:
+		params.Enabled = pgtype.Bool{Bool: value == "on", Valid: true}
+	case chatSettingKeyTrigger:
+		params.TriggerMode = pgtype.Text{String: value, Valid: true}
+	}
+
+	chat, err = b.storage.UpdateAccountChat(ctx, params)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to update setting %s of chat %d: %v", key, msg.Chat.ID, err)
+		answer(loc.T("callback.save_failed"))
+		return
+	}
+
+	answer(loc.T("callback.saved"))
+
+	if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      msg.Chat.ID,
+		MessageID:   msg.ID,
+		Text:        getChatSettingsText(loc, chat),
+		ReplyMarkup: getChatSettingsKeyboard(loc, chat),
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to edit chat settings message: %v", err)
+	}
+}
+
+func getChatSettingValue(chat sqlc.AccountChat, key string) string {
+	switch key {
+	case chatSettingKeyEnabled:
+		if chat.Enabled {
+			return "on"
+		}
+		return "off"
+	case chatSettingKeyTrigger:
+		return chat.TriggerMode
+	default:
+		return ""
+	}
+}
+
+func getChatSettingsText(loc *i18n.Localizer, chat sqlc.AccountChat) string {
+	groupConfig := config.GetConfig().TelegramBot.Group
+
+	limit := "∞"
+	if groupConfig.Limit > 0 {
+		limit = fmt.Sprint(groupConfig.Limit)
+	}
+
+	// Usage is reset lazily on the next download, so an expired period counts as zero here
+	if groupConfig.DaysToReset > 0 && chat.ResetAt.Time.AddDate(0, 0, groupConfig.DaysToReset).Before(time.Now()) {
+		chat.Usage = 0
+	}
+
+	return loc.T("chat.text",
+		getOptionTitle(loc, "chat."+chatSettingKeyEnabled, getChatSettingValue(chat, chatSettingKeyEnabled)),
+		getOptionTitle(loc, "chat."+chatSettingKeyTrigger, getChatSettingValue(chat, chatSettingKeyTrigger)),
+		chat.Usage, limit,
+	)
+}
+
+func getChatSettingsKeyboard(loc *i18n.Localizer, chat sqlc.AccountChat) *models.InlineKeyboardMarkup {
+	var rows [][]models.InlineKeyboardButton
+	for _, key := range []string{chatSettingKeyEnabled, chatSettingKeyTrigger} {
+		current := getChatSettingValue(chat, key)
+
+		var row []models.InlineKeyboardButton
+		for _, value := range chatSettingOptions[key] {
+			title := getOptionTitle(loc, "chat."+key, value)
+			if value == current {
+				title = "✅ " + title
+			}
+
+			row = append(row, models.InlineKeyboardButton{
+				Text:         title,
+				CallbackData: chatCallbackPrefix + key + ":" + value,
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
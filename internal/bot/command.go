@@ -0,0 +1,462 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// CommandHandler handles a bot command, args is the message text after the command
+type CommandHandler func(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string)
+
+type Command struct {
+	Name        string // Without leading slash
+	Description string // Message key of the description shown in the Telegram command menu and /help
+	Usage       string // Optional message key of the arguments hint shown in /help
+	Hidden      bool   // Not listed in the command menu and /help
+	Admin       bool   // Only for admins from the config, listed in their command menu and /help only
+	Handler     CommandHandler
+}
+
+// Message keys of feature names in /usage
+var featureTitles = map[model.Feature]string{
+	model.FeatureGetMedia:      "feature.downloads",
+	model.FeatureHighQuality:   "feature.high_quality",
+	model.FeatureExtractAudio:  "feature.extract_audio",
+	model.FeatureBatchDownload: "feature.batch_download",
+	model.FeatureLargeFiles:    "feature.large_files",
+	model.FeatureInlineMode:    "feature.inline_mode",
+	model.FeaturePriorityQueue: "feature.priority_queue",
+}
+
+// gatedFeatures have no limit, /usage lists them as included in the plan
+var gatedFeatures = []model.Feature{
+	model.FeatureHighQuality,
+	model.FeatureExtractAudio,
+	model.FeatureBatchDownload,
+	model.FeatureLargeFiles,
+	model.FeatureInlineMode,
+	model.FeaturePriorityQueue,
+}
+
+// commands returns every command the bot understands, in the order they are listed to users
+func (b *DefaultBot) commands() []Command {
+	return []Command{
+		{Name: "start", Description: "command.start", Handler: b.handleStart},
+		{Name: "help", Description: "command.help", Handler: b.handleHelp},
+		{Name: "dl", Description: "command.dl", Usage: "command.args.link", Handler: b.handleDownloadCommand("")},
+		{Name: "audio", Description: "command.audio", Usage: "command.args.link", Handler: b.handleDownloadCommand(DownloadModeAudio)},
+		{Name: "file", Description: "command.file", Usage: "command.args.link", Handler: b.handleDownloadCommand(DownloadModeDocument)},
+		{Name: "usage", Description: "command.usage", Handler: b.handleUsage},
+		{Name: "plan", Description: "command.plan", Handler: b.handlePlan},
+		{Name: "upgrade", Description: "command.upgrade", Handler: b.handleUpgrade},
+		{Name: "redeem", Description: "command.redeem", Usage: "command.args.code", Handler: b.handleRedeem},
+		{Name: "referrals", Description: "command.referrals", Handler: b.handleReferrals},
+		{Name: "history", Description: "command.history", Handler: b.handleHistory},
+		{Name: "settings", Description: "command.settings", Handler: b.handleSettings},
+		{Name: "chat", Description: "command.chat", Handler: b.handleChat},
+		{Name: "stats", Description: "command.stats", Usage: "command.args.days", Admin: true, Handler: b.handleStats},
+		{Name: "user", Description: "command.user", Usage: "command.args.user", Admin: true, Handler: b.handleUser},
+		{Name: "grant", Description: "command.grant", Usage: "command.args.grant", Admin: true, Handler: b.handleGrant},
+		{Name: "promo", Description: "command.promo", Usage: "command.args.promo", Admin: true, Handler: b.handlePromo},
+		{Name: "ban", Description: "command.ban", Usage: "command.args.user", Admin: true, Handler: b.handleBan},
+		{Name: "unban", Description: "command.unban", Usage: "command.args.user", Admin: true, Handler: b.handleUnban},
+		{Name: "resetusage", Description: "command.resetusage", Usage: "command.args.user", Admin: true, Handler: b.handleResetUsage},
+		{Name: "broadcast", Description: "command.broadcast", Usage: "command.args.text", Admin: true, Handler: b.handleBroadcast},
+	}
+}
+
+// registerCommands registers a handler for every command, callback and inline query, other messages go to the default handler
+func (b *DefaultBot) registerCommands() {
+	for _, command := range b.commands() {
+		b.RegisterHandlerMatchFunc(b.matchCommand(command.Name), b.commandHandlerFunc(command))
+	}
+
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, settingsCallbackPrefix, bot.MatchTypePrefix, b.handleSettingsCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, variantCallbackPrefix, bot.MatchTypePrefix, b.handleVariantCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, chatCallbackPrefix, bot.MatchTypePrefix, b.handleChatCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, historyCallbackPrefix, bot.MatchTypePrefix, b.handleHistoryCallback)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, upgradeCallbackPrefix, bot.MatchTypePrefix, b.handleUpgradeCallback)
+	b.RegisterHandlerMatchFunc(matchPreCheckoutQuery, b.handlePreCheckoutQuery)
+	b.RegisterHandlerMatchFunc(matchSuccessfulPayment, b.handleSuccessfulPayment)
+	b.RegisterHandlerMatchFunc(matchRefundedPayment, b.handleRefundedPayment)
+
+	if config.GetConfig().TelegramBot.Inline.Enabled {
+		b.RegisterHandlerMatchFunc(matchInlineQuery, b.handleInlineQuery)
+	}
+}
+
+// setMyCommands publishes the visible commands to the Telegram command menu in every supported language,
+// clients with other languages get the default one. Admins get a menu with the admin commands in their private chat.
+func (b *DefaultBot) setMyCommands(ctx context.Context) error {
+	for _, language := range i18n.Languages() {
+		loc := i18n.New(string(language))
+
+		params := &bot.SetMyCommandsParams{Commands: b.getBotCommands(loc, false)}
+		if language != i18n.DefaultLanguage {
+			params.LanguageCode = string(language)
+		}
+
+		if _, err := b.SetMyCommands(ctx, params); err != nil {
+			return fmt.Errorf("failed to set bot commands for language %s: %w", language, err)
+		}
+
+		for _, adminID := range config.GetConfig().TelegramBot.Admins {
+			params.Commands = b.getBotCommands(loc, true)
+			params.Scope = &models.BotCommandScopeChat{ChatID: adminID}
+
+			if _, err := b.SetMyCommands(ctx, params); err != nil {
+				return fmt.Errorf("failed to set admin commands of %d for language %s: %w", adminID, language, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getBotCommands returns the command menu, with the admin commands if admin
+func (b *DefaultBot) getBotCommands(loc *i18n.Localizer, admin bool) []models.BotCommand {
+	var botCommands []models.BotCommand
+	for _, command := range b.commands() {
+		if command.Hidden || (command.Admin && !admin) {
+			continue
+		}
+		botCommands = append(botCommands, models.BotCommand{
+			Command:     command.Name,
+			Description: loc.T(command.Description),
+		})
+	}
+	return botCommands
+}
+
+func (b *DefaultBot) commandHandlerFunc(command Command) bot.HandlerFunc {
+	return func(ctx context.Context, _ *bot.Bot, update *models.Update) {
+		// Admin commands don't exist for other users
+		if command.Admin && !isAdmin(update.Message.From.ID) {
+			return
+		}
+
+		_, args := parseCommand(update.Message.Text)
+
+		// Users who opened a referral link are new, their first message is /start ref_<code>
+		var referralCode string
+		if command.Name == "start" {
+			referralCode = getReferralCode(args)
+		}
+
+		account, err := b.getOrCreateReferredAccount(ctx, update.Message.From, referralCode)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to get account for command /%s: %v", command.Name, err)
+			return
+		}
+		if command.Name == "start" && update.Message.Chat.Type == models.ChatTypePrivate {
+			b.startTrial(ctx, account)
+		}
+
+		command.Handler(ctx, account, b.getAccountLocalizer(ctx, account), update, args)
+	}
+}
+
+// matchCommand matches messages starting with the command, addressed to this bot if a username suffix is given
+func (b *DefaultBot) matchCommand(name string) bot.MatchFunc {
+	return func(update *models.Update) bool {
+		if update.Message == nil || update.Message.From == nil {
+			return false
+		}
+
+		command, _ := parseCommand(update.Message.Text)
+		if command != name {
+			return false
+		}
+
+		// In groups /dl@otherbot is meant for another bot
+		_, username, found := strings.Cut(strings.Fields(update.Message.Text)[0], "@")
+		return !found || strings.EqualFold(username, b.me.Username)
+	}
+}
+
+// parseCommand splits a message like "/audio@botfetchr_bot https://..." into the command name and its arguments.
+// Returns an empty command if the text is not a command.
+func parseCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", text
+	}
+
+	command, args := text, ""
+	if idx := strings.IndexAny(text, " \n"); idx != -1 {
+		command, args = text[:idx], strings.TrimSpace(text[idx+1:])
+	}
+
+	// Commands in groups are suffixed with the bot username, e.g. /audio@botfetchr_bot
+	command, _, _ = strings.Cut(strings.TrimPrefix(command, "/"), "@")
+
+	return strings.ToLower(command), args
+}
+
+// handleDownloadCommand downloads the links after the command, or in the message replied to
+func (b *DefaultBot) handleDownloadCommand(mode DownloadMode) CommandHandler {
+	return func(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+		chat, ok := b.getMessageChat(ctx, update.Message)
+		if !ok {
+			return
+		}
+
+		text := getDownloadText(update.Message, args)
+		if len(extractURLs(text)) == 0 {
+			b.reply(ctx, update, loc.T("command.no_links"))
+			return
+		}
+
+		// Anonymous admins and posts on behalf of a chat are not the user they come from, only the chat quota applies
+		if update.Message.SenderChat != nil {
+			account = sqlc.AccountTelegram{}
+		}
+
+		b.handleURLs(ctx, account, chat, update.Message, text, mode)
+	}
+}
+
+func (b *DefaultBot) handleStart(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	saverTypes := make([]SaverType, 0, len(mediaSaverFactory))
+	for saverType := range mediaSaverFactory {
+		saverTypes = append(saverTypes, saverType)
+	}
+	slices.Sort(saverTypes)
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("start.greeting", account.FirstName))
+	for _, saverType := range saverTypes {
+		fmt.Fprintf(&sb, "• %s: %s\n", saverType.Title(), loc.T(saverType.Description()))
+	}
+	sb.WriteString(loc.T("start.footer"))
+
+	b.reply(ctx, update, sb.String())
+}
+
+func (b *DefaultBot) handleHelp(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	var sb strings.Builder
+	sb.WriteString(loc.T("help.intro"))
+	for _, command := range b.commands() {
+		if command.Hidden || (command.Admin && !isAdmin(account.TelegramID)) {
+			continue
+		}
+
+		sb.WriteString("/" + command.Name)
+		if command.Usage != "" {
+			sb.WriteString(" " + loc.T(command.Usage))
+		}
+		sb.WriteString(" - " + loc.T(command.Description) + "\n")
+	}
+
+	b.reply(ctx, update, sb.String())
+}
+
+func (b *DefaultBot) handleUsage(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	subscription, err := b.GetActiveSubscription(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get subscription of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("usage.failed"))
+		return
+	}
+
+	planID := getCurrentPlanID(subscription)
+	planFeatures, err := b.storage.ListPlanFeatures(ctx, sqlc.ListPlanFeaturesParams{
+		PlanID: pgtype.Text{String: planID, Valid: true},
+		Limit:  100,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to list features of plan %s: %v", planID, err)
+		b.reply(ctx, update, loc.T("usage.failed"))
+		return
+	}
+
+	usages, err := b.storage.ListAccountUsages(ctx, sqlc.ListAccountUsagesParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+		Limit:     100,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to list usages of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("usage.failed"))
+		return
+	}
+
+	usageByFeature := make(map[string]sqlc.AccountUsage, len(usages))
+	for _, usage := range usages {
+		usageByFeature[usage.Feature] = usage
+	}
+
+	var sb strings.Builder
+	var included []string
+	var burst int64
+	sb.WriteString(loc.T("usage.title", getPlanTitle(planID)))
+	for _, planFeature := range planFeatures {
+		// The burst allowance is not used up, it is shown as part of the rate limit
+		if planFeature.Feature == model.FeatureRateLimitBurst.String() {
+			burst = planFeature.Limit
+			continue
+		}
+		if isGatedFeature(planFeature.Feature) {
+			included = append(included, loc.T(getFeatureTitle(planFeature.Feature)))
+			continue
+		}
+
+		usage, ok := usageByFeature[planFeature.Feature]
+
+		// Usage is reset lazily on the next request, so an expired period counts as zero here
+		var used, bonus int64
+		resetAt := time.Now()
+		if ok {
+			periodUsage, err := getPeriodUsage(ctx, b.storage.Queries, usage)
+			if err != nil {
+				logger.Log.Sugar().Errorf("Failed to sum usage of account %d: %v", account.ID, err)
+				b.reply(ctx, update, loc.T("usage.failed"))
+				return
+			}
+
+			used, bonus = getMeteredUsage(planFeature.Meter, periodUsage), usage.Bonus
+			resetAt = usage.ResetAt.Time.AddDate(0, 0, int(planFeature.DaysToReset))
+			if planFeature.DaysToReset > 0 && resetAt.Before(time.Now()) {
+				used, bonus = 0, getBonusLeft(usage.Bonus, used, planFeature.Limit)
+				resetAt = time.Now().AddDate(0, 0, int(planFeature.DaysToReset))
+			}
+		} else {
+			resetAt = resetAt.AddDate(0, 0, int(planFeature.DaysToReset))
+		}
+
+		limit := "∞"
+		if planFeature.Limit > 0 {
+			limit = formatMeteredUsage(planFeature.Meter, planFeature.Limit)
+		}
+
+		fmt.Fprintf(&sb, "• %s: %s/%s", loc.T(getFeatureTitle(planFeature.Feature)), formatMeteredUsage(planFeature.Meter, used), limit)
+		if bonus > 0 && planFeature.Limit > 0 {
+			sb.WriteString(loc.T("usage.bonus", formatMeteredUsage(planFeature.Meter, bonus)))
+		}
+		if planFeature.DaysToReset > 0 && planFeature.Limit > 0 {
+			sb.WriteString(loc.T("usage.resets_in", formatDuration(loc, time.Until(resetAt))))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(included) > 0 {
+		sb.WriteString(loc.T("usage.included", strings.Join(included, ", ")))
+	}
+
+	if cfg := config.GetConfig().TelegramBot.RateLimit; cfg.Enabled {
+		perMinute, concurrent := "∞", "∞"
+		if cfg.PerMinute > 0 {
+			perMinute = strconv.FormatInt(int64(cfg.PerMinute)+burst, 10)
+		}
+		if cfg.Concurrent > 0 {
+			concurrent = strconv.Itoa(cfg.Concurrent)
+		}
+		sb.WriteString(loc.T("usage.rate_limit", perMinute, concurrent))
+	}
+
+	if len(planFeatures) == 0 {
+		sb.WriteString(loc.T("usage.no_features"))
+	}
+
+	b.reply(ctx, update, sb.String())
+}
+
+func (b *DefaultBot) handlePlan(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	subscription, err := b.GetActiveSubscription(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get subscription of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("plan.failed"))
+		return
+	}
+
+	// A subscription past its end is free until the scheduler expires it, show it the way quotas treat it
+	planID, status := getCurrentPlanID(subscription), string(subscription.Status)
+	if planID != subscription.PlanID {
+		status = string(sqlc.SubscriptionStatusesEXPIRED)
+		if subscription.CancelAt.Valid && !subscription.CancelAt.Time.After(time.Now()) {
+			status = string(sqlc.SubscriptionStatusesCANCELED)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("plan.title", getPlanTitle(planID), loc.T("subscription.status."+strings.ToLower(status))))
+	if subscription.StartDate.Valid {
+		sb.WriteString(loc.T("plan.started", subscription.StartDate.Time.Format(time.DateOnly)))
+	}
+
+	switch {
+	case !subscription.EndDate.Valid:
+		sb.WriteString(loc.T("plan.ends_never"))
+	case subscription.EndDate.Time.Before(time.Now()):
+		sb.WriteString(loc.T("plan.expired", subscription.EndDate.Time.Format(time.DateOnly)))
+	default:
+		sb.WriteString(loc.T("plan.ends", subscription.EndDate.Time.Format(time.DateOnly), formatDuration(loc, time.Until(subscription.EndDate.Time))))
+	}
+
+	if subscription.CancelAt.Valid {
+		sb.WriteString(loc.T("plan.cancels", subscription.CancelAt.Time.Format(time.DateOnly)))
+	}
+
+	b.reply(ctx, update, sb.String())
+}
+
+// reply sends a plain text answer to the message of the update
+func (b *DefaultBot) reply(ctx context.Context, update *models.Update, text string) {
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: update.Message.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send reply: %v", err)
+	}
+}
+
+func getFeatureTitle(feature string) string {
+	for f, title := range featureTitles {
+		if f.String() == feature {
+			return title
+		}
+	}
+	return feature
+}
+
+func isGatedFeature(feature string) bool {
+	return slices.ContainsFunc(gatedFeatures, func(f model.Feature) bool {
+		return f.String() == feature
+	})
+}
+
+func getPlanTitle(planID string) string {
+	return strings.TrimPrefix(planID, "Plan")
+}
+
+// formatDuration formats a duration in days and hours, or minutes if less than an hour
+func formatDuration(loc *i18n.Localizer, d time.Duration) string {
+	if d < time.Minute {
+		return loc.N("duration.seconds", max(int64(d.Seconds()), 1))
+	}
+	if d < time.Hour {
+		return loc.N("duration.minutes", max(int64(d.Minutes()), 1))
+	}
+
+	days, hours := int64(d.Hours())/24, int64(d.Hours())%24
+	if days == 0 {
+		return loc.N("duration.hours", hours)
+	}
+	return loc.N("duration.days", days) + " " + loc.N("duration.hours", hours)
+}
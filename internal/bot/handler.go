@@ -10,25 +10,49 @@ import (
 	"time"
 
 	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
 	"github.com/codeonbeans/botfetchr/internal/logger"
 	"github.com/codeonbeans/botfetchr/internal/model"
 	"github.com/codeonbeans/botfetchr/internal/utils/ptr"
+	"github.com/codeonbeans/botfetchr/internal/utils/saga"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+var (
+	errQuotaNotReserved = errors.New("quota could not be reserved")
+	// The user picks a variant first, the download continues in another saga once they did
+	errVariantPending = fmt.Errorf("waiting for a variant to be picked: %w", saga.ErrStop)
+	// Failures to send are reported to the user by the status updater
+	errMediaNotSent = errors.New("media could not be sent")
+	// Every media was too large to upload, links to it were sent instead
+	errNothingUploaded = errors.New("no media could be uploaded")
+)
+
 type MediaResult struct {
-	State  string
-	Medias []MediaData
+	State    string
+	Medias   []MediaData
+	Keyboard *models.InlineKeyboardMarkup // Buttons shown under the status message
+	Done     chan<- error                 // Receives the outcome of sending Medias
 }
 
 type MediaData struct {
-	Filename  string
-	Size      int64
-	Media     io.ReadCloser
-	DirectURL string
+	Filename    string
+	Type        string // One of download.MediaType*
+	ContentType string // Sniffed from the downloaded content
+	Size        int64
+	Media       io.ReadCloser
+	LocalPath   string // File on disk, sent by path to a local Bot API server or uploaded from disk
+	DirectURL   string
+
+	// Video only, filled by probing the downloaded file
+	Width         int
+	Height        int
+	Duration      int // Seconds
+	ThumbnailPath string
 }
 
 // ProcessingContext encapsulates all the context needed for processing a URL
@@ -38,75 +62,241 @@ type ProcessingContext struct {
 	originalMsgID int
 	urlIndex      int
 	url           string
+	mode          DownloadMode
+	account       sqlc.AccountTelegram
+	chat          *sqlc.AccountChat // Group or channel the download counts against, nil for the personal quota
+	settings      sqlc.AccountSetting
+	features      PlanFeatures // Features of the plan of the account, the free plan without one
+	loc           *i18n.Localizer
 	statusMsg     *models.Message
+	requestID     int64  // Row of the request in the history, 0 if it could not be recorded
+	finishJob     func() // Frees the running download of the rate limits, nil for downloads continued from the quality picker
+
+	// Set when the user picked a variant, the url is not resolved again
+	directURL string
+	userAgent string
+	metadata  mediasaverbase.Metadata
 }
 
+// Handler handles every update no command or callback handler matched, i.e. messages and channel posts with links
 func (b *DefaultBot) Handler(ctx context.Context, update *models.Update) {
-	account, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
-		TelegramID: pgtype.Int8{Int64: int64(update.Message.From.ID), Valid: true},
-	})
+	switch {
+	case update.Message != nil:
+		b.handleMessage(ctx, update.Message)
+	case update.ChannelPost != nil:
+		b.handleChannelPost(ctx, update.ChannelPost)
+	}
+}
+
+func (b *DefaultBot) handleMessage(ctx context.Context, msg *models.Message) {
+	chat, ok := b.getMessageChat(ctx, msg)
+	if !ok || (chat != nil && !b.isTriggered(*chat, msg)) {
+		return
+	}
+
+	// Accounts are only created for messages with links, chatter in groups is not a user of the bot
+	text := getMessageText(msg)
+	if len(extractURLs(text)) == 0 {
+		return
+	}
+
+	// Messages sent on behalf of a chat have no user, only the chat quota applies.
+	// Anonymous admins come from GroupAnonymousBot, which is not them either.
+	if msg.From == nil || msg.SenderChat != nil {
+		if chat != nil {
+			b.handleURLs(ctx, sqlc.AccountTelegram{}, chat, msg, text, "")
+		}
+		return
+	}
+
+	account, err := b.getOrCreateAccount(ctx, msg.From)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			account, err = b.storage.CreateAccountTelegram(ctx, sqlc.CreateAccountTelegramParams{
-				TelegramID:   update.Message.From.ID,
-				IsBot:        update.Message.From.IsBot,
-				FirstName:    update.Message.From.FirstName,
-				LastName:     update.Message.From.LastName,
-				Username:     pgtype.Text{String: update.Message.From.Username, Valid: true},
-				LanguageCode: update.Message.From.LanguageCode,
-				IsPremium:    update.Message.From.IsPremium,
-			})
-			if err != nil {
-				logger.Log.Sugar().Errorf("Failed to create account: %v", err)
-				return
-			}
-		} else {
-			logger.Log.Sugar().Errorf("Failed to get account: %v", err)
+		logger.Log.Sugar().Errorf("Failed to get account: %v", err)
+		return
+	}
+
+	b.handleURLs(ctx, account, chat, msg, text, "")
+}
+
+// handleChannelPost downloads links posted in channels the bot is admin of, against the channel quota
+func (b *DefaultBot) handleChannelPost(ctx context.Context, post *models.Message) {
+	chat, ok := b.getMessageChat(ctx, post)
+	if !ok || chat == nil {
+		return
+	}
+
+	// Channel posts can't be commands for the router, /dl is handled here
+	text := getMessageText(post)
+	command, args := parseCommand(text)
+	switch {
+	case command == "dl":
+		b.handleURLs(ctx, sqlc.AccountTelegram{}, chat, post, getDownloadText(post, args), "")
+	case command == "" && b.isTriggered(*chat, post):
+		b.handleURLs(ctx, sqlc.AccountTelegram{}, chat, post, text, "")
+	}
+}
+
+// handleURLs processes every link in the text concurrently, an empty mode uses the account default.
+//
+// Downloads in groups and channels count against the quota of the chat, account is empty if there is no sender.
+func (b *DefaultBot) handleURLs(ctx context.Context, account sqlc.AccountTelegram, chat *sqlc.AccountChat, msg *models.Message, text string, mode DownloadMode) {
+	urls := extractURLs(text)
+	if len(urls) == 0 {
+		return
+	}
+
+	settings := getDefaultSettings()
+	if account.ID != 0 {
+		var err error
+		if settings, err = b.getOrCreateSettings(ctx, account.ID); err != nil {
+			logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
 			return
 		}
 	}
 
-	urls := extractURLs(update.Message.Text)
+	if mode == "" {
+		mode = DownloadMode(settings.DownloadMode)
+	}
+
+	// The first personal download starts the trial, downloads in chats count against the chat quota
+	if account.ID != 0 && chat == nil {
+		b.startTrial(ctx, account)
+	}
+
+	features, err := b.GetPlanFeatures(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get plan features of account %d: %v", account.ID, err)
+		return
+	}
+
+	// Posts without a sender follow the free plan, the notice is only for users who can upgrade
+	if len(urls) > 1 && !features.Has(model.FeatureBatchDownload) {
+		urls = urls[:1]
+		if account.ID != 0 {
+			b.replyBatchLocked(ctx, account, settings, msg)
+		}
+	}
+
+	// Links over the rate limit are skipped, the sender is told when to send them again
+	burst := features[model.FeatureRateLimitBurst.String()].Limit
 	for i, url := range urls {
-		go b.processURLAsync(ctx, account, update, url, i)
-	}
-}
-
-func (b *DefaultBot) processURLAsync(ctx context.Context, account sqlc.AccountTelegram, update *models.Update, url string, index int) {
-	// Check subscription
-	if err := b.IsAccountAllow(ctx, account.ID, model.FeatureGetMedia, 1); err != nil {
-		logger.Log.Sugar().Errorf("Account %d is not allowed to download: %v", update.Message.From.ID, err)
-		// Send error message to user
-		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: update.Message.Chat.ID,
-			Text:   fmt.Sprintf("You are not allowed to download media: %v", err),
-			ReplyParameters: &models.ReplyParameters{
-				MessageID: update.Message.ID,
-			},
-		}); err != nil {
-			logger.Log.Sugar().Errorf("Failed to send error message: %v", err)
+		finishJob, err := b.startJob(ctx, account.ID, chat, burst)
+		if err != nil {
+			logger.Log.Sugar().Infof("Rate limited %d links of chat %d: %v", len(urls)-i, msg.Chat.ID, err)
+			if account.ID != 0 {
+				b.replyRateLimited(ctx, account, settings, msg, err, len(urls)-i)
+			}
 			return
 		}
 
-		return
+		go b.processURLAsync(ctx, account, chat, settings, features, msg, url, i, mode, finishJob)
 	}
+}
+
+// getOrCreateAccount returns the account of a Telegram user, creating it on first contact
+func (b *DefaultBot) getOrCreateAccount(ctx context.Context, from *models.User) (sqlc.AccountTelegram, error) {
+	return b.getOrCreateReferredAccount(ctx, from, "")
+}
 
+// getOrCreateReferredAccount is getOrCreateAccount for users who opened a /start link,
+// a new account is attributed to the owner of the referral code. Existing accounts can't be referred.
+func (b *DefaultBot) getOrCreateReferredAccount(ctx context.Context, from *models.User, referralCode string) (sqlc.AccountTelegram, error) {
+	account, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		TelegramID: pgtype.Int8{Int64: from.ID, Valid: true},
+	})
+	if err == nil {
+		return account, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return sqlc.AccountTelegram{}, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	account, err = b.storage.CreateAccountTelegram(ctx, sqlc.CreateAccountTelegramParams{
+		TelegramID:   from.ID,
+		IsBot:        from.IsBot,
+		FirstName:    from.FirstName,
+		LastName:     from.LastName,
+		Username:     pgtype.Text{String: from.Username, Valid: true},
+		LanguageCode: from.LanguageCode,
+		IsPremium:    from.IsPremium,
+	})
+	if err != nil {
+		return sqlc.AccountTelegram{}, fmt.Errorf("failed to create account: %w", err)
+	}
+
+	if referralCode != "" {
+		b.attributeReferral(ctx, account, referralCode)
+	}
+
+	return account, nil
+}
+
+// replyBatchLocked tells the sender that only the first link of the message is downloaded on their plan
+func (b *DefaultBot) replyBatchLocked(ctx context.Context, account sqlc.AccountTelegram, settings sqlc.AccountSetting, msg *models.Message) {
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: msg.Chat.ID,
+		Text:   getLocalizer(account, settings).T("batch.locked"),
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: msg.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send batch locked message: %v", err)
+	}
+}
+
+// replyRateLimited tells the sender how many links of the message were skipped and when to send them again
+func (b *DefaultBot) replyRateLimited(ctx context.Context, account sqlc.AccountTelegram, settings sqlc.AccountSetting, msg *models.Message, err error, skipped int) {
+	loc := getLocalizer(account, settings)
+	links := loc.N("rate_limit.links", int64(skipped))
+
+	text := loc.T("rate_limit.running", links)
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+		text = loc.T("rate_limit.retry_after", links, formatDuration(loc, rateLimitErr.RetryAfter))
+	}
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: msg.Chat.ID,
+		Text:   text,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: msg.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send rate limit message: %v", err)
+	}
+}
+
+// getRateLimitText tells when a download that was rate limited can be started again, for answers to a single download
+func getRateLimitText(loc *i18n.Localizer, err error) string {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+		return loc.T("rate_limit.try_again_in", formatDuration(loc, rateLimitErr.RetryAfter))
+	}
+	return loc.T("rate_limit.try_again_later")
+}
+
+func (b *DefaultBot) processURLAsync(ctx context.Context, account sqlc.AccountTelegram, chat *sqlc.AccountChat, settings sqlc.AccountSetting, features PlanFeatures, msg *models.Message, url string, index int, mode DownloadMode, finishJob func()) {
 	processCtx := &ProcessingContext{
 		ctx:           ctx,
-		chatID:        update.Message.Chat.ID,
-		originalMsgID: update.Message.ID,
+		chatID:        msg.Chat.ID,
+		originalMsgID: msg.ID,
 		urlIndex:      index,
 		url:           url,
+		mode:          mode,
+		account:       account,
+		chat:          chat,
+		settings:      settings,
+		features:      features,
+		loc:           getLocalizer(account, settings),
+		finishJob:     finishJob,
 	}
+	b.startMediaRequest(processCtx)
+	b.runMediaProcessor(processCtx)
+}
 
-	// Send initial status message
-	statusMsg, err := b.sendInitialStatus(ctx, processCtx)
-	if err != nil {
-		logger.Log.Sugar().Errorf("Failed to send initial status: %v", err)
-		return
-	}
-	processCtx.statusMsg = statusMsg
-
+// runMediaProcessor downloads and sends the media of a url in a saga, reporting progress in the status message
+func (b *DefaultBot) runMediaProcessor(processCtx *ProcessingContext) {
 	processor := &MediaProcessor{
 		bot:        b,
 		processCtx: processCtx,
@@ -116,9 +306,24 @@ func (b *DefaultBot) processURLAsync(ctx context.Context, account sqlc.AccountTe
 	// Start status updater goroutine
 	go processor.handleStatusUpdates()
 
-	// Process the URL
-	if err := processor.processURL(); err != nil {
-		processor.updateChan <- MediaResult{State: err.Error()}
+	err := processor.newSaga().Execute(processCtx.ctx)
+	processor.leaveQueue()
+	if processCtx.finishJob != nil {
+		processCtx.finishJob()
+	}
+
+	switch {
+	case err == nil, errors.Is(err, errVariantPending), errors.Is(err, errMediaNotSent):
+	case errors.Is(err, errQuotaNotReserved), errors.Is(err, ErrFeatureNotAvailable):
+		logger.Log.Sugar().Errorf("Chat %d is not allowed to download: %v", processCtx.chatID, err)
+		b.finishMediaRequest(processCtx, mediasaverbase.Metadata{}, nil, nil, err)
+		b.sendAllowError(processor, err)
+	default:
+		logger.Log.Sugar().Errorf("Failed to process %s: %v", processCtx.url, err)
+		b.finishMediaRequest(processCtx, processor.metadata, nil, nil, err)
+		if processCtx.statusMsg != nil {
+			processor.updateChan <- MediaResult{State: processCtx.loc.T("status.failed", getErrorText(processCtx.loc, err))}
+		}
 	}
 
 	// Clean up
@@ -126,10 +331,31 @@ func (b *DefaultBot) processURLAsync(ctx context.Context, account sqlc.AccountTe
 	close(processor.updateChan)
 }
 
+// sendAllowError tells the user that the quota is used up, in the status message of a picked variant or in reply to the link
+func (b *DefaultBot) sendAllowError(processor *MediaProcessor, err error) {
+	processCtx := processor.processCtx
+	text := getAllowErrorText(processCtx.loc, err, processCtx.chat != nil)
+
+	if processCtx.statusMsg != nil {
+		processor.updateChan <- MediaResult{State: text}
+		return
+	}
+
+	if _, err := b.SendMessage(processCtx.ctx, &bot.SendMessageParams{
+		ChatID: processCtx.chatID,
+		Text:   text,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: processCtx.originalMsgID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send error message: %v", err)
+	}
+}
+
 func (b *DefaultBot) sendInitialStatus(ctx context.Context, processCtx *ProcessingContext) (*models.Message, error) {
 	return b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: processCtx.chatID,
-		Text:   fmt.Sprintf("%d. %s\nState: ⌛ queued...", processCtx.urlIndex+1, processCtx.url),
+		Text:   processCtx.loc.T("status.line", processCtx.urlIndex+1, processCtx.url, processCtx.loc.T("status.queued")),
 		ReplyParameters: &models.ReplyParameters{
 			MessageID: processCtx.originalMsgID,
 		},
@@ -139,15 +365,29 @@ func (b *DefaultBot) sendInitialStatus(ctx context.Context, processCtx *Processi
 	})
 }
 
+// extractURLs returns every http(s) link in the text, links can be anywhere in a line, e.g. after a mention
 func extractURLs(text string) []string {
-	lines := strings.Split(text, "\n")
 	var urls []string
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
-			urls = append(urls, line)
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+			urls = append(urls, field)
 		}
 	}
 
 	return urls
 }
+
+// getDownloadText returns the text to take links from for a download command, the message replied to if no link was given
+func getDownloadText(msg *models.Message, args string) string {
+	if len(extractURLs(args)) > 0 || msg.ReplyToMessage == nil {
+		return args
+	}
+
+	return getMessageText(msg.ReplyToMessage)
+}
+
+// getMessageText returns the text of a message together with the caption of its media, links can be in either
+func getMessageText(msg *models.Message) string {
+	return msg.Text + "\n" + msg.Caption
+}
@@ -0,0 +1,385 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/utils/download"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Callback data of history buttons is "history:page:<page>" or "history:send:<request id>"
+const historyCallbackPrefix = "history:"
+
+const (
+	historyActionPage = "page"
+	historyActionSend = "send"
+	// Requests per history page
+	historyPageSize = 5
+	// Telegram accepts 2 to 10 items per media group
+	maxMediaGroupItems = 10
+)
+
+// Types of sent media that are not downloaded as such, audio is extracted from videos
+const (
+	sentMediaTypeAudio = "audio"
+	sentMediaTypeVoice = "voice"
+)
+
+// sentMedia is a media uploaded to Telegram, it is sent again by file id without downloading it
+type sentMedia struct {
+	Type   string // One of download.MediaType* or sentMediaType*
+	FileID string
+}
+
+// getSentMedia returns the file of a message with media
+func getSentMedia(msg *models.Message) (sentMedia, bool) {
+	switch {
+	case len(msg.Photo) > 0:
+		return sentMedia{Type: download.MediaTypePhoto, FileID: msg.Photo[len(msg.Photo)-1].FileID}, true
+	case msg.Video != nil:
+		return sentMedia{Type: download.MediaTypeVideo, FileID: msg.Video.FileID}, true
+	case msg.Animation != nil:
+		// Animations have a document too, so they are checked first
+		return sentMedia{Type: download.MediaTypeAnimation, FileID: msg.Animation.FileID}, true
+	case msg.Audio != nil:
+		return sentMedia{Type: sentMediaTypeAudio, FileID: msg.Audio.FileID}, true
+	case msg.Voice != nil:
+		return sentMedia{Type: sentMediaTypeVoice, FileID: msg.Voice.FileID}, true
+	case msg.Document != nil:
+		return sentMedia{Type: download.MediaTypeDocument, FileID: msg.Document.FileID}, true
+	default:
+		return sentMedia{}, false
+	}
+}
+
+// addSentMessages keeps the files of sent messages for the history
+func (mp *MediaProcessor) addSentMessages(msgs ...*models.Message) {
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		if media, ok := getSentMedia(msg); ok {
+			mp.sent = append(mp.sent, media)
+		}
+	}
+}
+
+// startMediaRequest records a request before it is processed, the download goes on if it can't be recorded
+func (b *DefaultBot) startMediaRequest(processCtx *ProcessingContext) {
+	request, err := b.storage.CreateMediaRequest(processCtx.ctx, sqlc.CreateMediaRequestParams{
+		AccountID: pgtype.Int8{Int64: processCtx.account.ID, Valid: processCtx.account.ID != 0},
+		ChatID:    processCtx.chatID,
+		Url:       processCtx.url,
+		SaverType: string(getSaverType(processCtx.url)),
+		Mode:      string(processCtx.mode),
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to record request of %s: %v", processCtx.url, err)
+		return
+	}
+
+	processCtx.requestID = request.ID
+}
+
+// finishMediaRequest records the outcome of a request, err is nil if the media was sent
+func (b *DefaultBot) finishMediaRequest(processCtx *ProcessingContext, metadata mediasaverbase.Metadata, medias []MediaData, sent []sentMedia, err error) {
+	if processCtx.requestID == 0 {
+		return
+	}
+
+	files, marshalErr := json.Marshal(sent)
+	if marshalErr != nil || sent == nil {
+		files = []byte("[]")
+	}
+
+	params := sqlc.FinishMediaRequestParams{
+		ID:         processCtx.requestID,
+		Status:     sqlc.MediaRequestStatusesSUCCEEDED,
+		Title:      truncate(metadata.Title, 255),
+		Author:     truncate(metadata.Author, 255),
+		MediaCount: int32(len(medias)),
+		Files:      files,
+	}
+	for _, media := range medias {
+		params.Size += media.Size
+		params.MediaDuration += int32(media.Duration)
+	}
+
+	if err != nil {
+		params.Status = sqlc.MediaRequestStatusesFAILED
+		params.ErrorKind = pgtype.Text{String: string(getErrorKind(err)), Valid: true}
+	}
+
+	if _, err = b.storage.FinishMediaRequest(processCtx.ctx, params); err != nil {
+		logger.Log.Sugar().Errorf("Failed to record outcome of request %d: %v", processCtx.requestID, err)
+	}
+
+	// The referral of an account is rewarded with its first successful download
+	if params.Status == sqlc.MediaRequestStatusesSUCCEEDED && processCtx.account.ID != 0 {
+		b.rewardReferral(processCtx.ctx, processCtx.account.ID)
+	}
+}
+
+func (b *DefaultBot) handleHistory(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	text, keyboard, err := b.getHistoryPage(ctx, loc, account.ID, 0)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get history of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("history.failed"))
+		return
+	}
+
+	if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        text,
+		ReplyMarkup: keyboard,
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: update.Message.ID,
+		},
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send history: %v", err)
+	}
+}
+
+// handleHistoryCallback turns the page of the history or sends a previous download again
+func (b *DefaultBot) handleHistoryCallback(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+
+	answer := func(text string) {
+		if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to answer callback query: %v", err)
+		}
+	}
+
+	account, err := b.getOrCreateAccount(ctx, &query.From)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account for history callback: %v", err)
+		answer(i18n.New(query.From.LanguageCode).T("history.failed"))
+		return
+	}
+	loc := b.getAccountLocalizer(ctx, account)
+
+	action, value, _ := strings.Cut(strings.TrimPrefix(query.Data, historyCallbackPrefix), ":")
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || query.Message.Message == nil {
+		answer(loc.T("history.unavailable"))
+		return
+	}
+	msg := query.Message.Message
+
+	switch action {
+	case historyActionPage:
+		text, keyboard, err := b.getHistoryPage(ctx, loc, account.ID, int(number))
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to get history of account %d: %v", account.ID, err)
+			answer(loc.T("history.failed"))
+			return
+		}
+
+		answer("")
+		if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      msg.Chat.ID,
+			MessageID:   msg.ID,
+			Text:        text,
+			ReplyMarkup: keyboard,
+			LinkPreviewOptions: &models.LinkPreviewOptions{
+				IsDisabled: bot.True(),
+			},
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to edit history message: %v", err)
+		}
+	case historyActionSend:
+		request, err := b.storage.GetMediaRequest(ctx, number)
+		if err != nil || request.AccountID.Int64 != account.ID || request.Status != sqlc.MediaRequestStatusesSUCCEEDED {
+			answer(loc.T("history.unavailable"))
+			return
+		}
+
+		var files []sentMedia
+		if err = json.Unmarshal(request.Files, &files); err != nil || len(files) == 0 {
+			answer(loc.T("history.unavailable"))
+			return
+		}
+
+		settings, err := b.getOrCreateSettings(ctx, account.ID)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
+			settings = getDefaultSettings()
+		}
+
+		answer(loc.T("history.sending"))
+
+		caption := getCaption(settings, mediasaverbase.Metadata{Title: request.Title, Author: request.Author}, request.Url)
+		if err = b.sendFilesByID(ctx, msg.Chat.ID, files, caption); err != nil {
+			logger.Log.Sugar().Errorf("Failed to send request %d again: %v", request.ID, err)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: msg.Chat.ID,
+				Text:   loc.T("status.send_failed", getErrorText(loc, err)),
+			})
+		}
+	default:
+		answer(loc.T("history.unavailable"))
+	}
+}
+
+// getHistoryPage returns the text and navigation of a page of the requests of an account, newest first
+func (b *DefaultBot) getHistoryPage(ctx context.Context, loc *i18n.Localizer, accountID int64, page int) (string, *models.InlineKeyboardMarkup, error) {
+	total, err := b.storage.CountMediaRequests(ctx, sqlc.CountMediaRequestsParams{
+		AccountID: pgtype.Int8{Int64: accountID, Valid: true},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to count requests: %w", err)
+	}
+	if total == 0 {
+		return loc.T("history.empty"), nil, nil
+	}
+
+	pages := int((total + historyPageSize - 1) / historyPageSize)
+	page = min(max(page, 0), pages-1)
+
+	requests, err := b.storage.ListMediaRequests(ctx, sqlc.ListMediaRequestsParams{
+		AccountID: pgtype.Int8{Int64: accountID, Valid: true},
+		Limit:     historyPageSize,
+		Offset:    int32(page * historyPageSize),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list requests: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(loc.T("history.title", page+1, pages))
+
+	var sendRow []models.InlineKeyboardButton
+	for i, request := range requests {
+		number := page*historyPageSize + i + 1
+
+		title := request.Title
+		if title == "" {
+			title = request.Author
+		}
+
+		fmt.Fprintf(&sb, "%d. %s %s\n", number, getRequestStatusIcon(request.Status), truncate(title, 64))
+		fmt.Fprintf(&sb, "%s\n%s", request.Url, request.CreatedAt.Time.Format("2006-01-02 15:04"))
+		if request.Size > 0 {
+			sb.WriteString(" · " + download.ByteCountBinary(request.Size))
+		}
+		sb.WriteString("\n\n")
+
+		if request.Status == sqlc.MediaRequestStatusesSUCCEEDED && len(request.Files) > len("[]") {
+			sendRow = append(sendRow, models.InlineKeyboardButton{
+				Text:         fmt.Sprintf("🔁 %d", number),
+				CallbackData: fmt.Sprintf("%s%s:%d", historyCallbackPrefix, historyActionSend, request.ID),
+			})
+		}
+	}
+
+	var navRow []models.InlineKeyboardButton
+	if page > 0 {
+		navRow = append(navRow, models.InlineKeyboardButton{
+			Text:         "◀️",
+			CallbackData: fmt.Sprintf("%s%s:%d", historyCallbackPrefix, historyActionPage, page-1),
+		})
+	}
+	if page < pages-1 {
+		navRow = append(navRow, models.InlineKeyboardButton{
+			Text:         "▶️",
+			CallbackData: fmt.Sprintf("%s%s:%d", historyCallbackPrefix, historyActionPage, page+1),
+		})
+	}
+
+	var rows [][]models.InlineKeyboardButton
+	for _, row := range [][]models.InlineKeyboardButton{sendRow, navRow} {
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	return strings.TrimSpace(sb.String()), &models.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// sendFilesByID sends uploaded media again, photos and videos as media groups and everything else one by one.
+// The caption is shown under the first message.
+func (b *DefaultBot) sendFilesByID(ctx context.Context, chatID int64, files []sentMedia, caption string) error {
+	var groupable []models.InputMedia
+	var singles []sentMedia
+	for _, file := range files {
+		switch file.Type {
+		case download.MediaTypePhoto:
+			groupable = append(groupable, &models.InputMediaPhoto{Media: file.FileID})
+		case download.MediaTypeVideo:
+			groupable = append(groupable, &models.InputMediaVideo{Media: file.FileID, SupportsStreaming: true})
+		default:
+			singles = append(singles, file)
+		}
+	}
+
+	for start := 0; start < len(groupable); start += maxMediaGroupItems {
+		group := groupable[start:min(start+maxMediaGroupItems, len(groupable))]
+		if caption != "" {
+			setInputMediaCaption(group[0], caption)
+			caption = ""
+		}
+
+		if _, err := b.SendMediaGroup(ctx, &bot.SendMediaGroupParams{ChatID: chatID, Media: group}); err != nil {
+			return fmt.Errorf("failed to send media group: %w", err)
+		}
+	}
+
+	for _, file := range singles {
+		inputFile := &models.InputFileString{Data: file.FileID}
+
+		var err error
+		switch file.Type {
+		case download.MediaTypeAnimation:
+			_, err = b.SendAnimation(ctx, &bot.SendAnimationParams{ChatID: chatID, Animation: inputFile, Caption: caption})
+		case sentMediaTypeAudio:
+			_, err = b.SendAudio(ctx, &bot.SendAudioParams{ChatID: chatID, Audio: inputFile, Caption: caption})
+		case sentMediaTypeVoice:
+			_, err = b.SendVoice(ctx, &bot.SendVoiceParams{ChatID: chatID, Voice: inputFile, Caption: caption})
+		default:
+			_, err = b.SendDocument(ctx, &bot.SendDocumentParams{ChatID: chatID, Document: inputFile, Caption: caption})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send %s: %w", file.Type, err)
+		}
+		caption = ""
+	}
+
+	return nil
+}
+
+func getRequestStatusIcon(status sqlc.MediaRequestStatuses) string {
+	switch status {
+	case sqlc.MediaRequestStatusesSUCCEEDED:
+		return "✅"
+	case sqlc.MediaRequestStatusesFAILED:
+		return "❌"
+	default:
+		return "⏳"
+	}
+}
+
+// truncate shortens a text to at most n runes, marking the cut with an ellipsis
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
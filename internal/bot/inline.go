@@ -0,0 +1,415 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/utils/download"
+
+	"github.com/eko/gocache/lib/v4/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// File ids never expire, the ttl only bounds the size of the cache
+	inlineResultTTL = 7 * 24 * time.Hour
+	// Queries are sent while typing, a url is charged once per account within this period
+	inlineUsageTTL = time.Hour
+	// Seconds Telegram may cache an answer on its side
+	inlineAnswerCacheTime = 300
+	// Telegram accepts at most 50 results per answer
+	maxInlineResults = 50
+	// Answers while a url is resolved are cached briefly, the next query gets the media (0 is Telegram's default of 300)
+	inlineLoadingCacheTime = 1
+)
+
+// inlineInProgressError is returned while a query resolves the url, it is resolved once for all accounts
+type inlineInProgressError struct {
+	accountID int64 // Account of the query resolving the url
+}
+
+func (e *inlineInProgressError) Error() string {
+	return fmt.Sprintf("url is already being resolved for account %d", e.accountID)
+}
+
+// inlineResult is the media of a url uploaded to the cache chat, shared in inline answers by file id
+type inlineResult struct {
+	Metadata mediasaverbase.Metadata
+	Medias   []sentMedia
+}
+
+func matchInlineQuery(update *models.Update) bool {
+	return update.InlineQuery != nil
+}
+
+// handleInlineQuery answers "@bot <url>" queries, resolving can take a while so it does not block other updates
+func (b *DefaultBot) handleInlineQuery(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	go b.answerInlineQuery(ctx, update.InlineQuery)
+}
+
+func (b *DefaultBot) answerInlineQuery(ctx context.Context, query *models.InlineQuery) {
+	url := strings.TrimSpace(query.Query)
+	if len(extractURLs(url)) == 0 || query.From == nil {
+		return
+	}
+
+	account, err := b.getOrCreateAccount(ctx, query.From)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account for inline query: %v", err)
+		return
+	}
+
+	settings, err := b.getOrCreateSettings(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
+		return
+	}
+
+	loc := getLocalizer(account, settings)
+
+	// The query can be an incomplete url while the user is typing, no need to answer
+	saver, err := b.GetMediaSaver(url, settings)
+	if err != nil {
+		return
+	}
+
+	features, err := b.GetPlanFeatures(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get plan features of account %d: %v", account.ID, err)
+		return
+	}
+
+	if err = features.Require(model.FeatureInlineMode); err != nil {
+		b.answerInlineError(ctx, query.ID, loc.T("inline.not_allowed"), getAllowErrorText(loc, err, false), 0)
+		return
+	}
+
+	charged, err := b.chargeInlineUsage(ctx, account.ID, url)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Account %d is not allowed to use inline mode: %v", account.ID, err)
+		b.answerInlineError(ctx, query.ID, loc.T("inline.not_allowed"), getAllowErrorText(loc, err, false), 0)
+		return
+	}
+
+	// Like downloads in chats, a url that could not be resolved costs nothing
+	result, err := b.getInlineResult(ctx, account, saver, url, settings, features)
+	var inProgressErr *inlineInProgressError
+	if errors.As(err, &inProgressErr) {
+		// A query of the same account holds the charge of the url, otherwise the query that gets the media is charged
+		if charged {
+			b.releaseInlineUsage(ctx, account.ID, url, inProgressErr.accountID != account.ID)
+		}
+		b.answerInlineError(ctx, query.ID, loc.T("inline.loading"), loc.T("inline.loading_hint"), inlineLoadingCacheTime)
+		return
+	}
+	if err != nil {
+		if charged {
+			b.releaseInlineUsage(ctx, account.ID, url, true)
+		}
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			logger.Log.Sugar().Infof("Rate limited inline query of account %d: %v", account.ID, err)
+			b.answerInlineError(ctx, query.ID, loc.T("inline.rate_limited"), getRateLimitText(loc, err), 0)
+			return
+		}
+		logger.Log.Sugar().Errorf("Failed to resolve inline query %s: %v", url, err)
+		b.answerInlineError(ctx, query.ID, loc.T("inline.failed"), getErrorText(loc, err), 0)
+		return
+	}
+
+	if _, err = b.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: query.ID,
+		Results:       getInlineQueryResults(loc, result, getCaption(settings, result.Metadata, url)),
+		CacheTime:     inlineAnswerCacheTime,
+		IsPersonal:    true,
+	}); err != nil {
+		// Resolving can outlast the query, the result is cached for the next one
+		logger.Log.Sugar().Errorf("Failed to answer inline query: %v", err)
+	}
+}
+
+// chargeInlineUsage counts an inline download against the plan limits, once per url within inlineUsageTTL.
+// It reports whether this query was charged, a url charged before is not.
+func (b *DefaultBot) chargeInlineUsage(ctx context.Context, accountID int64, url string) (bool, error) {
+	key := getInlineUsageKey(accountID, url)
+
+	var charged bool
+	if _, err := b.cacheManager.Get(ctx, key, &charged); err == nil && charged {
+		return false, nil
+	}
+
+	if err := b.IsAccountAllow(ctx, accountID, model.FeatureGetMedia, 0, model.Usage{Requests: 1}); err != nil {
+		return false, err
+	}
+
+	if err := b.cacheManager.Set(ctx, key, true, store.WithExpiration(inlineUsageTTL)); err != nil {
+		logger.Log.Sugar().Errorf("Failed to store inline usage of account %d: %v", accountID, err)
+	}
+
+	return true, nil
+}
+
+// releaseInlineUsage gives back the charge of an inline query, forget makes the next query of the url charged again
+func (b *DefaultBot) releaseInlineUsage(ctx context.Context, accountID int64, url string, forget bool) {
+	if err := b.ReleaseAccountUsage(ctx, accountID, model.FeatureGetMedia, 0, model.Usage{Requests: 1}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to release inline usage of account %d: %v", accountID, err)
+		return
+	}
+
+	if forget {
+		if err := b.cacheManager.Delete(ctx, getInlineUsageKey(accountID, url)); err != nil {
+			logger.Log.Sugar().Errorf("Failed to delete inline usage of account %d: %v", accountID, err)
+		}
+	}
+}
+
+func getInlineUsageKey(accountID int64, url string) string {
+	return fmt.Sprintf("inline_usage:%d:%s", accountID, url)
+}
+
+// getInlineResult returns the cached file ids of a url, downloading and uploading its media on the first query
+func (b *DefaultBot) getInlineResult(ctx context.Context, account sqlc.AccountTelegram, saver MediaSaver, url string, settings sqlc.AccountSetting, features PlanFeatures) (inlineResult, error) {
+	key := "inline_result:" + url
+
+	var result inlineResult
+	if _, err := b.cacheManager.Get(ctx, key, &result); err == nil && len(result.Medias) > 0 {
+		return result, nil
+	}
+
+	// Every keystroke sends a new query, only the first one resolves the url
+	if holder, loaded := b.inlineRequests.LoadOrStore(url, account.ID); loaded {
+		return inlineResult{}, &inlineInProgressError{accountID: holder.(int64)}
+	}
+	defer b.inlineRequests.Delete(url)
+
+	// Resolving is a download like in chats, answers from the cache are not
+	finishJob, err := b.startJob(ctx, account.ID, nil, features[model.FeatureRateLimitBurst.String()].Limit)
+	if err != nil {
+		return inlineResult{}, err
+	}
+	defer finishJob()
+
+	result, err = b.resolveInlineResult(ctx, account, saver, url, settings, features)
+	if err != nil {
+		return inlineResult{}, err
+	}
+
+	if err = b.cacheManager.Set(ctx, key, result, store.WithExpiration(inlineResultTTL)); err != nil {
+		logger.Log.Sugar().Errorf("Failed to cache inline result of %s: %v", url, err)
+	}
+
+	return result, nil
+}
+
+// resolveInlineResult downloads the media of a url and uploads it to the cache chat to get file ids.
+// It is recorded in the history of the account that queried the url first, later queries are answered from the cache.
+func (b *DefaultBot) resolveInlineResult(ctx context.Context, account sqlc.AccountTelegram, saver MediaSaver, url string, settings sqlc.AccountSetting, features PlanFeatures) (result inlineResult, err error) {
+	// Inline results can't be audio extracted from videos
+	mode := DownloadMode(settings.DownloadMode)
+	if mode == DownloadModeAudio {
+		mode = DownloadModeMedia
+	}
+
+	mp := &MediaProcessor{
+		bot: b,
+		processCtx: &ProcessingContext{
+			ctx:      ctx,
+			chatID:   config.GetConfig().TelegramBot.Inline.CacheChatID,
+			url:      url,
+			mode:     mode,
+			account:  account,
+			settings: settings,
+			features: features,
+			loc:      i18n.New(string(i18n.DefaultLanguage)),
+		},
+		updateChan: make(chan MediaResult, 10),
+	}
+
+	var medias []MediaData
+	b.startMediaRequest(mp.processCtx)
+	defer func() {
+		b.finishMediaRequest(mp.processCtx, mp.metadata, medias, result.Medias, err)
+	}()
+
+	// Nobody sees the progress of an inline query
+	go func() {
+		for range mp.updateChan {
+		}
+	}()
+	defer close(mp.updateChan)
+
+	directUrls, err := mp.getDirectURLs(saver)
+	if err != nil {
+		return inlineResult{}, err
+	}
+	mp.metadata = saver.GetMetadata()
+
+	medias, err = mp.downloadMedias(saver, directUrls)
+	if err != nil {
+		return inlineResult{}, err
+	}
+	defer mp.closeMediaStreams(medias)
+
+	result = inlineResult{Metadata: mp.metadata}
+	for _, media := range medias {
+		if media.Size >= getMaxMediaSize(mp.processCtx.features) {
+			logger.Log.Sugar().Infof("Skipping %s in inline result, too large (%s)", media.Filename, download.ByteCountBinary(media.Size))
+			continue
+		}
+
+		uploaded, err := mp.uploadInlineMedia(media)
+		if err != nil {
+			return inlineResult{}, err
+		}
+		result.Medias = append(result.Medias, uploaded)
+	}
+
+	if len(result.Medias) == 0 {
+		return inlineResult{}, fmt.Errorf("no media of %s can be shared inline", url)
+	}
+
+	return result, nil
+}
+
+// uploadInlineMedia sends a media to the cache chat and returns its file id
+func (mp *MediaProcessor) uploadInlineMedia(media MediaData) (sentMedia, error) {
+	inputFile, closeFile, err := mp.createInputFile(media)
+	if err != nil {
+		return sentMedia{}, err
+	}
+	defer closeFile()
+
+	if media.Type == download.MediaTypePhoto && media.Size > maxPhotoSize {
+		media.Type = download.MediaTypeDocument
+	}
+
+	ctx, chatID := mp.processCtx.ctx, mp.processCtx.chatID
+
+	var msg *models.Message
+
+	switch media.Type {
+	case download.MediaTypePhoto:
+		msg, err = mp.bot.SendPhoto(ctx, &bot.SendPhotoParams{ChatID: chatID, Photo: inputFile, DisableNotification: true})
+	case download.MediaTypeVideo:
+		params := &bot.SendVideoParams{
+			ChatID:              chatID,
+			Video:               inputFile,
+			Width:               media.Width,
+			Height:              media.Height,
+			Duration:            media.Duration,
+			SupportsStreaming:   true,
+			DisableNotification: true,
+		}
+
+		if media.ThumbnailPath != "" {
+			thumbnail, closeThumbnail, err := createThumbnailFile(media.ThumbnailPath)
+			if err != nil {
+				return sentMedia{}, err
+			}
+			defer closeThumbnail()
+			params.Thumbnail = thumbnail
+		}
+
+		msg, err = mp.bot.SendVideo(ctx, params)
+	case download.MediaTypeAnimation:
+		msg, err = mp.bot.SendAnimation(ctx, &bot.SendAnimationParams{ChatID: chatID, Animation: inputFile, DisableNotification: true})
+	default:
+		msg, err = mp.bot.SendDocument(ctx, &bot.SendDocumentParams{
+			ChatID:                      chatID,
+			Document:                    inputFile,
+			DisableContentTypeDetection: true,
+			DisableNotification:         true,
+		})
+	}
+
+	if err != nil {
+		return sentMedia{}, fmt.Errorf("failed to upload %s to cache chat: %w", media.Filename, err)
+	}
+
+	uploaded, ok := getSentMedia(msg)
+	if !ok {
+		return sentMedia{}, fmt.Errorf("no file in uploaded message of %s", media.Filename)
+	}
+
+	return uploaded, nil
+}
+
+// answerInlineError answers with a single article explaining why there is no media, cached by Telegram for cacheTime seconds
+func (b *DefaultBot) answerInlineError(ctx context.Context, queryID, title, description string, cacheTime int) {
+	if _, err := b.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: queryID,
+		Results: []models.InlineQueryResult{
+			&models.InlineQueryResultArticle{
+				ID:          "error",
+				Title:       title,
+				Description: description,
+				InputMessageContent: &models.InputTextMessageContent{
+					MessageText: title + ": " + description,
+				},
+			},
+		},
+		CacheTime:  cacheTime,
+		IsPersonal: true,
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to answer inline query: %v", err)
+	}
+}
+
+func getInlineQueryResults(loc *i18n.Localizer, result inlineResult, caption string) []models.InlineQueryResult {
+	title := result.Metadata.Title
+	if title == "" {
+		title = result.Metadata.Author
+	}
+	if title == "" {
+		title = loc.T("inline.media")
+	}
+
+	var results []models.InlineQueryResult
+	for i, media := range result.Medias[:min(len(result.Medias), maxInlineResults)] {
+		id := strconv.Itoa(i)
+
+		mediaTitle := title
+		if len(result.Medias) > 1 {
+			mediaTitle = fmt.Sprintf("%s %d", title, i+1)
+		}
+
+		switch media.Type {
+		case download.MediaTypePhoto:
+			results = append(results, &models.InlineQueryResultCachedPhoto{ID: id, PhotoFileID: media.FileID, Caption: caption})
+		case download.MediaTypeVideo:
+			results = append(results, &models.InlineQueryResultCachedVideo{ID: id, VideoFileID: media.FileID, Title: mediaTitle, Caption: caption})
+		case download.MediaTypeAnimation:
+			results = append(results, &models.InlineQueryResultCachedMpeg4Gif{ID: id, Mpeg4FileID: media.FileID, Caption: caption})
+		default:
+			results = append(results, &models.InlineQueryResultCachedDocument{ID: id, DocumentFileID: media.FileID, Title: mediaTitle, Caption: caption})
+		}
+	}
+
+	return results
+}
+
+// createThumbnailFile returns the thumbnail of a video as input file, the returned func closes it
+func createThumbnailFile(path string) (models.InputFile, func(), error) {
+	if isLocalAPIServer() {
+		return &models.InputFileString{Data: "file://" + path}, func() {}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open thumbnail %s: %w", path, err)
+	}
+
+	return &models.InputFileUpload{Filename: "thumbnail.jpg", Data: f}, func() { f.Close() }, nil
+}
@@ -0,0 +1,87 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// getLocalizer returns the localizer of an account, the language chosen in settings wins over the Telegram language
+func getLocalizer(account sqlc.AccountTelegram, settings sqlc.AccountSetting) *i18n.Localizer {
+	if settings.LanguageCode.Valid {
+		return i18n.New(settings.LanguageCode.String)
+	}
+	return i18n.New(account.LanguageCode)
+}
+
+// getAccountLocalizer loads the settings of an account to pick its language
+func (b *DefaultBot) getAccountLocalizer(ctx context.Context, account sqlc.AccountTelegram) *i18n.Localizer {
+	settings, err := b.getOrCreateSettings(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
+		return i18n.New(account.LanguageCode)
+	}
+
+	return getLocalizer(account, settings)
+}
+
+// getUserLocalizer returns the localizer of the account of a Telegram user, e.g. for callback queries
+func (b *DefaultBot) getUserLocalizer(ctx context.Context, from *models.User) *i18n.Localizer {
+	account, err := b.getOrCreateAccount(ctx, from)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account of user %d: %v", from.ID, err)
+		return i18n.New(from.LanguageCode)
+	}
+
+	return b.getAccountLocalizer(ctx, account)
+}
+
+// getAllowErrorText explains to the user why a download is not allowed, inChat if the chat quota was checked
+func getAllowErrorText(loc *i18n.Localizer, err error, inChat bool) string {
+	var lockedErr *FeatureLockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		return loc.T("error.feature_locked", loc.T(getFeatureTitle(lockedErr.Feature.String())))
+	case errors.Is(err, ErrFeatureLimitExceeded) && inChat:
+		return loc.T("error.chat_limit_exceeded")
+	case errors.Is(err, ErrFeatureNotAvailable):
+		return loc.T("error.feature_not_available")
+	default:
+		return getErrorText(loc, err)
+	}
+}
+
+// getErrorText returns a message with a hint for the kind of an error, the details are for the logs only
+func getErrorText(loc *i18n.Localizer, err error) string {
+	kind := getErrorKind(err)
+	if kind == model.ErrorKindUnknown {
+		return loc.T("error.internal")
+	}
+	return loc.T("error." + string(kind))
+}
+
+// getErrorKind classifies an error, including errors of the Telegram API that have no kind
+func getErrorKind(err error) model.ErrorKind {
+	if kind := model.GetErrorKind(err); kind != model.ErrorKindUnknown {
+		return kind
+	}
+
+	var tooManyRequests *bot.TooManyRequestsError
+	switch {
+	case errors.As(err, &tooManyRequests):
+		return model.ErrorKindRateLimited
+	case strings.Contains(err.Error(), "too big"), strings.Contains(err.Error(), "Too Large"):
+		// "Bad Request: file is too big" or HTTP 413 "Request Entity Too Large"
+		return model.ErrorKindTooLarge
+	default:
+		return model.ErrorKindUnknown
+	}
+}
@@ -1,27 +1,70 @@
 package tgbot
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
 	"github.com/codeonbeans/botfetchr/internal/client/browserpool"
+	"github.com/codeonbeans/botfetchr/internal/client/ffmpeg"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
 	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
 	"github.com/codeonbeans/botfetchr/internal/utils/common"
 	"github.com/codeonbeans/botfetchr/internal/utils/download"
 	"github.com/codeonbeans/botfetchr/internal/utils/ptr"
+	"github.com/codeonbeans/botfetchr/internal/utils/saga"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
 
+const (
+	// Telegram requires thumbnails to be JPEG of at most 320x320
+	maxThumbnailSize = 320
+	// Telegram rejects photos larger than 10MB, larger ones are sent as documents
+	maxPhotoSize = 10 * 1024 * 1024
+	// Telegram limit of media captions in characters
+	maxCaptionLength = 1024
+)
+
+// mediaGroup is a media group with the extra files its items reference (e.g. thumbnails)
+type mediaGroup struct {
+	medias      []models.InputMedia
+	attachments []attachment
+}
+
+// readCloser pairs a wrapping reader with the closer of the underlying stream
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // MediaProcessor handles the processing of individual URLs
 type MediaProcessor struct {
 	bot        *DefaultBot
 	processCtx *ProcessingContext
 	updateChan chan MediaResult
+	metadata   mediasaverbase.Metadata
+	sent       []sentMedia // Files of the sent messages, recorded in the history
+	delivered  model.Usage // Items and bytes uploaded to the chat, metered once they are sent
+
+	// Passed between the steps of the saga
+	release    func() // Frees the slot of the download queue, set once the download got one
+	saver      MediaSaver
+	directUrls []string
+	medias     []MediaData
 }
 
 func (mp *MediaProcessor) handleStatusUpdates() {
@@ -30,44 +73,173 @@ func (mp *MediaProcessor) handleStatusUpdates() {
 	}
 }
 
-func (mp *MediaProcessor) processURL() error {
-	attempts := config.GetConfig().MediaSaver.RetryCount
-
-	return common.DoWithRetry(common.RetryConfig{
-		Attempts: attempts,
-		Delay:    2 * time.Second,
-	}, mp.attemptDownload)
+// newSaga returns the steps of a download. The quota is reserved first, so users without quota left never wait
+// in the queue or for the media, and released again if it is not delivered, so users are only charged for media
+// they got. Asking for a variant gives the reservation back before the saga stops.
+func (mp *MediaProcessor) newSaga() *saga.Saga {
+	s := saga.New()
+	s.AddStep("check features", mp.checkFeatures, nil)
+	s.AddStep("reserve quota", mp.reserveQuota, mp.releaseQuota)
+	s.AddStep("queue", mp.queue, nil)
+	s.AddStep("fetch", mp.fetch, nil)
+	s.AddStep("download", mp.download, nil)
+	s.AddStep("send", mp.send, nil)
+	return s
 }
 
-func (mp *MediaProcessor) attemptDownload() error {
-	// Get video saver
-	saver, err := mp.bot.GetMediaSaver(mp.processCtx.url)
+// reserveQuota counts the download against the quota of the chat in groups and channels, the personal plan otherwise
+func (mp *MediaProcessor) reserveQuota(ctx context.Context) error {
+	var err error
+	if mp.processCtx.chat != nil {
+		err = mp.bot.IsChatAllow(ctx, *mp.processCtx.chat, 1)
+	} else {
+		err = mp.bot.IsAccountAllow(ctx, mp.processCtx.account.ID, model.FeatureGetMedia, mp.processCtx.requestID, model.Usage{Requests: 1})
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get media saver: %w", err)
+		return fmt.Errorf("%w: %w", errQuotaNotReserved, err)
+	}
+	return nil
+}
+
+func (mp *MediaProcessor) releaseQuota(ctx context.Context) error {
+	if mp.processCtx.chat != nil {
+		return mp.bot.ReleaseChatUsage(ctx, *mp.processCtx.chat, 1)
 	}
+	return mp.bot.ReleaseAccountUsage(ctx, mp.processCtx.account.ID, model.FeatureGetMedia, mp.processCtx.requestID, model.Usage{Requests: 1})
+}
 
-	// Get direct URLs
-	directUrls, err := mp.getDirectURLs(saver)
+// checkFeatures stops downloads that need a feature the plan does not include, before any quota is used
+func (mp *MediaProcessor) checkFeatures(_ context.Context) error {
+	if mp.processCtx.mode == DownloadModeAudio {
+		return mp.processCtx.features.Require(model.FeatureExtractAudio)
+	}
+	return nil
+}
+
+// queue waits for a slot of the download queue, shown as queued in the status message.
+// The slot is freed once the saga is done, whatever its outcome.
+func (mp *MediaProcessor) queue(ctx context.Context) error {
+	if mp.processCtx.statusMsg == nil {
+		statusMsg, err := mp.bot.sendInitialStatus(ctx, mp.processCtx)
+		if err != nil {
+			return fmt.Errorf("failed to send initial status: %w", err)
+		}
+		mp.processCtx.statusMsg = statusMsg
+	}
+
+	release, err := mp.bot.downloadQueue.acquire(ctx, mp.processCtx.features.Has(model.FeaturePriorityQueue))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to wait for a download slot: %w", err)
 	}
+	mp.release = release
+	return nil
+}
+
+// leaveQueue frees the slot of the download queue, if the download got one
+func (mp *MediaProcessor) leaveQueue() {
+	if mp.release != nil {
+		mp.release()
+	}
+}
 
-	// Download media files
-	medias, err := mp.downloadMedias(saver, directUrls)
+// fetch resolves the direct urls of the media, unless the user picked a variant already.
+// If the user is asked to pick one, the quota is released and errVariantPending stops the saga,
+// another one reserves it again and continues once they did.
+func (mp *MediaProcessor) fetch(ctx context.Context) error {
+	saver, err := mp.bot.GetMediaSaver(mp.processCtx.url, mp.processCtx.settings)
 	if err != nil {
+		return fmt.Errorf("failed to get media saver: %w", err)
+	}
+	// Plans without high quality get the lowest rendition, whatever the settings say
+	if !mp.processCtx.features.Has(model.FeatureHighQuality) {
+		saver.SetQuality("low")
+	}
+	mp.saver = saver
+
+	if mp.processCtx.directURL != "" {
+		// Variant picked by the user, resolved when the choice was offered
+		mp.directUrls = []string{mp.processCtx.directURL}
+		mp.metadata = mp.processCtx.metadata
+		saver.SetUserAgent(mp.processCtx.userAgent)
+		return nil
+	}
+
+	if err = mp.retry(func() error {
+		mp.directUrls, err = mp.getDirectURLs(saver)
+		return err
+	}); err != nil {
 		return err
 	}
+	mp.metadata = saver.GetMetadata()
+
+	// Let the user pick a rendition, the download continues on callback
+	if mp.shouldAskVariant(saver, mp.directUrls) {
+		if err = mp.askVariant(saver); err != nil {
+			return err
+		}
+		// Stopping the saga does not compensate, a failed release only costs the user one download
+		if err = mp.releaseQuota(ctx); err != nil {
+			logger.Log.Sugar().Errorf("Failed to release quota of %s while a variant is picked: %v", mp.processCtx.url, err)
+		}
+		return errVariantPending
+	}
 
-	// Send success result
-	mp.sendSuccessResult(medias)
 	return nil
 }
 
+// download downloads the media files, replacing videos with their audio track in audio mode
+func (mp *MediaProcessor) download(_ context.Context) error {
+	return mp.retry(func() error {
+		medias, err := mp.downloadMedias(mp.saver, mp.directUrls)
+		if err != nil {
+			return err
+		}
+
+		if mp.processCtx.mode == DownloadModeAudio {
+			medias, err = mp.extractAudios(medias)
+			if err != nil {
+				return err
+			}
+		}
+
+		mp.medias = medias
+		return nil
+	})
+}
+
+// send hands the media to the status updater, which sends it after the pending status updates, and waits for it
+func (mp *MediaProcessor) send(ctx context.Context) error {
+	done := make(chan error, 1)
+	mp.sendSuccessResult(mp.medias, done)
+
+	if err := <-done; err != nil {
+		return fmt.Errorf("%w: %w", errMediaNotSent, err)
+	}
+
+	// Items and bytes are only known now, they are metered after delivery for plans that limit them.
+	// Media only linked because it was too large is not counted.
+	if mp.processCtx.chat == nil {
+		if err := mp.bot.RecordAccountUsage(ctx, mp.processCtx.account.ID, model.FeatureGetMedia, mp.processCtx.requestID, mp.delivered); err != nil {
+			logger.Log.Sugar().Errorf("Failed to record usage of account %d: %v", mp.processCtx.account.ID, err)
+		}
+	}
+	return nil
+}
+
+// retry runs a step again on retryable errors, e.g. timeouts of the platform
+func (mp *MediaProcessor) retry(fn func() error) error {
+	return common.DoWithRetry(common.RetryConfig{
+		Attempts:    config.GetConfig().MediaSaver.RetryCount,
+		Delay:       2 * time.Second,
+		ShouldRetry: model.IsRetryable,
+	}, fn)
+}
+
 func (mp *MediaProcessor) getDirectURLs(saver MediaSaver) ([]string, error) {
 	var directUrls []string
 
 	err := mp.bot.browserPool.UseBrowser(func(ctx context.Context, browser *browserpool.Browser) error {
-		mp.updateChan <- MediaResult{State: "🔎 getting info..."}
+		mp.updateChan <- MediaResult{State: mp.processCtx.loc.T("status.getting_info")}
 		logger.Log.Sugar().Infof("Processing URL: %s", mp.processCtx.url)
 
 		var err error
@@ -89,6 +261,7 @@ func (mp *MediaProcessor) downloadMedias(saver MediaSaver, directUrls []string)
 	for j, directUrl := range directUrls {
 		media, err := mp.downloadSingleMedia(saver, directUrl, j, len(directUrls))
 		if err != nil {
+			mp.closeMediaStreams(medias)
 			return nil, err
 		}
 		medias = append(medias, media)
@@ -103,7 +276,7 @@ func (mp *MediaProcessor) downloadSingleMedia(saver MediaSaver, directUrl string
 
 	// Update download progress
 	mp.updateChan <- MediaResult{
-		State: fmt.Sprintf("⬇️ downloading media %d/%d...%s", index+1, total, sizeStr),
+		State: mp.processCtx.loc.T("status.downloading", index+1, total, sizeStr),
 	}
 
 	// Create and configure request
@@ -120,18 +293,214 @@ func (mp *MediaProcessor) downloadSingleMedia(saver MediaSaver, directUrl string
 		return MediaData{}, fmt.Errorf("failed to download video from %s: %w", directUrl, err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return MediaData{}, fmt.Errorf("failed to download video from %s: HTTP %d %s", directUrl, resp.StatusCode, resp.Status)
+	if err = download.CheckStatus(directUrl, resp); err != nil {
+		resp.Body.Close()
+		return MediaData{}, err
+	}
+
+	filename := saver.GetFilename(mp.processCtx.url, directUrl)
+
+	// Sniff the first bytes instead of trusting the extension of the CDN url
+	buffered := bufio.NewReaderSize(resp.Body, download.SniffLen)
+	head, _ := buffered.Peek(download.SniffLen)
+	body := readCloser{Reader: buffered, Closer: resp.Body}
+
+	contentType := download.DetectContentType(head, resp.Header.Get("Content-Type"))
+	mediaType := download.DetectMediaType(contentType, head, filename)
+	filename = fixExtension(filename, contentType)
+
+	// Originals are sent as is, without probing or conversion
+	if mp.processCtx.mode == DownloadModeDocument {
+		mediaType = download.MediaTypeDocument
+	}
+
+	isVideo := mediaType == download.MediaTypeVideo
+	needsConversion := mediaType == download.MediaTypePhoto && download.NeedsJPEGConversion(contentType)
+
+	// Videos are probed and some photos converted from disk, everything else is streamed unless the
+	// local Bot API server reads it by path
+	if !isVideo && !needsConversion && !isLocalAPIServer() {
+		return MediaData{
+			Filename:    filename,
+			Type:        mediaType,
+			ContentType: contentType,
+			Size:        fileSize,
+			Media:       body,
+			DirectURL:   directUrl,
+		}, nil
+	}
+
+	media, err := mp.spoolMedia(body, filename, directUrl)
+	if err != nil {
+		return MediaData{}, err
+	}
+	media.Type = mediaType
+	media.ContentType = contentType
+
+	if isVideo && mp.processCtx.mode != DownloadModeAudio {
+		mp.probeVideo(&media)
+	}
+
+	if needsConversion {
+		mp.convertPhoto(&media)
+	}
+
+	if !isLocalAPIServer() {
+		f, err := os.Open(media.LocalPath)
+		if err != nil {
+			mp.closeMediaStreams([]MediaData{media})
+			return MediaData{}, fmt.Errorf("failed to open spooled media %s: %w", media.LocalPath, err)
+		}
+		media.Media = f
+	}
+
+	return media, nil
+}
+
+// convertPhoto converts a photo Telegram doesn't accept (e.g. HEIC) into JPEG.
+// If the conversion fails, the original file is sent as document instead
+func (mp *MediaProcessor) convertPhoto(media *MediaData) {
+	ctx, cancel := context.WithTimeout(mp.processCtx.ctx, time.Duration(config.GetConfig().FFmpeg.Timeout)*time.Second)
+	defer cancel()
+
+	output := strings.TrimSuffix(media.LocalPath, filepath.Ext(media.LocalPath)) + ".jpg"
+	if err := mp.bot.ffmpeg.ConvertToJPEG(ctx, media.LocalPath, output); err != nil {
+		logger.Log.Sugar().Warnf("Failed to convert %s to jpeg, sending as document: %v", media.Filename, err)
+		os.Remove(output)
+		media.Type = download.MediaTypeDocument
+		return
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		logger.Log.Sugar().Warnf("Failed to stat converted photo %s, sending as document: %v", output, err)
+		media.Type = download.MediaTypeDocument
+		return
+	}
+
+	os.Remove(media.LocalPath)
+	media.LocalPath = output
+	media.Size = info.Size()
+	media.ContentType = "image/jpeg"
+	media.Filename = fixExtension(media.Filename, media.ContentType)
+}
+
+// probeVideo fills dimensions and duration of a spooled video and generates its thumbnail.
+// Failures are only logged, the video is still sent without them
+func (mp *MediaProcessor) probeVideo(media *MediaData) {
+	ctx, cancel := context.WithTimeout(mp.processCtx.ctx, time.Duration(config.GetConfig().FFmpeg.Timeout)*time.Second)
+	defer cancel()
+
+	info, err := mp.bot.ffmpeg.ProbeVideo(ctx, media.LocalPath)
+	if err != nil {
+		logger.Log.Sugar().Warnf("Failed to probe video %s: %v", media.Filename, err)
+		return
+	}
+
+	media.Width = info.Width
+	media.Height = info.Height
+	media.Duration = int(math.Round(info.Duration))
+
+	// Take the frame at 1s to skip black intro frames, unless the video is shorter than that
+	thumbnailAt := min(1, info.Duration/2)
+	thumbnailPath := strings.TrimSuffix(media.LocalPath, filepath.Ext(media.LocalPath)) + "_thumb.jpg"
+
+	if err = mp.bot.ffmpeg.Thumbnail(ctx, media.LocalPath, thumbnailPath, thumbnailAt, maxThumbnailSize); err != nil {
+		logger.Log.Sugar().Warnf("Failed to create thumbnail for %s: %v", media.Filename, err)
+		os.Remove(thumbnailPath)
+		return
+	}
+
+	media.ThumbnailPath = thumbnailPath
+}
+
+// spoolMedia writes the response body to the spool directory, so the file can be probed
+// or sent by path to the local Bot API server instead of streaming it through multipart
+func (mp *MediaProcessor) spoolMedia(body io.ReadCloser, filename, directUrl string) (MediaData, error) {
+	defer body.Close()
+
+	path, size, err := download.SpoolToFile(getSpoolDir(), filename, body)
+	if err != nil {
+		return MediaData{}, fmt.Errorf("failed to spool media from %s: %w", directUrl, err)
 	}
 
 	return MediaData{
-		Filename:  saver.GetFilename(mp.processCtx.url, directUrl),
-		Size:      fileSize,
-		Media:     resp.Body,
+		Filename:  filename,
+		Size:      size,
+		LocalPath: path,
 		DirectURL: directUrl,
 	}, nil
 }
 
+// extractAudios replaces downloaded videos with their audio track, photos are dropped
+func (mp *MediaProcessor) extractAudios(medias []MediaData) ([]MediaData, error) {
+	defer mp.closeMediaStreams(medias)
+
+	var audios []MediaData
+	for i, media := range medias {
+		if media.Type != download.MediaTypeVideo {
+			continue
+		}
+
+		mp.updateChan <- MediaResult{
+			State: mp.processCtx.loc.T("status.extracting_audio", i+1, len(medias)),
+		}
+
+		audio, err := mp.extractAudio(media)
+		if err != nil {
+			mp.closeMediaStreams(audios)
+			return nil, err
+		}
+		audios = append(audios, audio)
+	}
+
+	if len(audios) == 0 {
+		return nil, errors.New("no video with audio track found")
+	}
+
+	return audios, nil
+}
+
+func (mp *MediaProcessor) extractAudio(media MediaData) (MediaData, error) {
+	input := media.LocalPath
+	if input == "" {
+		// ffmpeg needs a seekable input since mp4 files may keep their index at the end
+		path, _, err := download.SpoolToFile(getSpoolDir(), media.Filename, media.Media)
+		if err != nil {
+			return MediaData{}, fmt.Errorf("failed to spool media for audio extraction: %w", err)
+		}
+		defer os.Remove(path)
+		input = path
+	}
+
+	format := config.GetConfig().MediaSaver.AudioFormat
+	output := strings.TrimSuffix(input, filepath.Ext(input)) + "." + format
+
+	ctx, cancel := context.WithTimeout(mp.processCtx.ctx, time.Duration(config.GetConfig().FFmpeg.Timeout)*time.Second)
+	defer cancel()
+
+	if err := mp.bot.ffmpeg.ExtractAudio(ctx, input, output, ffmpeg.Tags{
+		Title:  mp.metadata.Title,
+		Artist: mp.metadata.Author,
+	}); err != nil {
+		os.Remove(output)
+		return MediaData{}, err
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return MediaData{}, fmt.Errorf("failed to stat extracted audio: %w", err)
+	}
+
+	return MediaData{
+		Filename:  strings.TrimSuffix(media.Filename, filepath.Ext(media.Filename)) + "." + format,
+		Type:      download.MediaTypeDocument,
+		Size:      info.Size(),
+		LocalPath: output,
+		DirectURL: media.DirectURL,
+	}, nil
+}
+
 func (mp *MediaProcessor) configureRequest(req *http.Request, saver MediaSaver, directUrl string) {
 	userAgent := saver.GetUA()
 	logger.Log.Sugar().Infof("downloading media from %s with user agent %s", directUrl, userAgent)
@@ -142,18 +511,19 @@ func (mp *MediaProcessor) configureRequest(req *http.Request, saver MediaSaver,
 	req.Header.Set("Accept-Encoding", "identity")
 }
 
-func (mp *MediaProcessor) sendSuccessResult(medias []MediaData) {
+func (mp *MediaProcessor) sendSuccessResult(medias []MediaData, done chan<- error) {
 	totalSize := mp.calculateTotalSize(medias)
 	sizeStr := getSizeStr(totalSize)
 
 	mp.updateChan <- MediaResult{
-		State: fmt.Sprintf("📲 sending media%s", sizeStr),
+		State: mp.processCtx.loc.T("status.sending", sizeStr),
 	}
 
 	successState := mp.getSuccessMessage(medias, sizeStr)
 	mp.updateChan <- MediaResult{
 		Medias: medias,
 		State:  successState,
+		Done:   done,
 	}
 }
 
@@ -166,18 +536,18 @@ func (mp *MediaProcessor) calculateTotalSize(medias []MediaData) int64 {
 }
 
 func (mp *MediaProcessor) getSuccessMessage(medias []MediaData, sizeStr string) string {
-	if len(medias) > 1 {
-		return fmt.Sprintf("✅ got %d medias successfully%s", len(medias), sizeStr)
-	}
-	return fmt.Sprintf("✅ get media successfully%s", sizeStr)
+	return mp.processCtx.loc.N("status.success", int64(len(medias)), sizeStr)
 }
 
 func (mp *MediaProcessor) updateStatus(result MediaResult) {
 	defer mp.closeMediaStreams(result.Medias)
 
-	if len(result.Medias) > 0 {
+	switch {
+	case len(result.Medias) > 0:
 		mp.handleMediaSending(result)
-	} else {
+	case result.Keyboard != nil:
+		mp.editStatusMessage(result.State, result.Keyboard)
+	default:
 		mp.updateStatusMessage(result.State)
 	}
 }
@@ -187,59 +557,181 @@ func (mp *MediaProcessor) closeMediaStreams(medias []MediaData) {
 		if media.Media != nil {
 			media.Media.Close()
 		}
+		for _, path := range []string{media.LocalPath, media.ThumbnailPath} {
+			if path == "" {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				logger.Log.Sugar().Errorf("Failed to remove spooled file %s: %v", path, err)
+			}
+		}
 	}
 }
 
 func (mp *MediaProcessor) handleMediaSending(result MediaResult) {
-	groups := mp.createMediaGroups(result.Medias)
-
-	if err := mp.sendMediaGroups(groups); err != nil {
-		mp.updateStatusMessage(fmt.Sprintf("❌ failed to send media: %v", err))
+	var err error
+	if mp.processCtx.mode == DownloadModeAudio {
+		err = mp.sendAudios(result.Medias)
 	} else {
+		err = mp.sendMedias(result.Medias)
+	}
+
+	mp.bot.finishMediaRequest(mp.processCtx, mp.metadata, result.Medias, mp.sent, err)
+
+	switch {
+	case errors.Is(err, errNothingUploaded):
+		// The links to the media tell the user what happened
+		logger.Log.Sugar().Infof("Media of %s is too large to send, only links were sent", mp.processCtx.url)
+		mp.deleteStatusMessage()
+	case err != nil:
+		logger.Log.Sugar().Errorf("Failed to send media of %s: %v", mp.processCtx.url, err)
+		mp.updateStatusMessage(mp.processCtx.loc.T("status.send_failed", getErrorText(mp.processCtx.loc, err)))
+	default:
 		mp.deleteStatusMessage()
 	}
+
+	if result.Done != nil {
+		result.Done <- err
+	}
 }
 
-func (mp *MediaProcessor) createMediaGroups(medias []MediaData) [][]models.InputMedia {
-	maxGroupSize := int64(config.GetConfig().MediaSaver.MaxGroupMediaSize * 1024 * 1024)
-
-	var groups [][]models.InputMedia
-	var currentGroup []models.InputMedia
-	var currentGroupSize int64
+// sendMedias sends photos and videos as media groups, animations and documents one by one
+func (mp *MediaProcessor) sendMedias(medias []MediaData) error {
+	maxSize := getMaxMediaSize(mp.processCtx.features)
 
+	var groupable, singles []MediaData
 	for mediaIdx, media := range medias {
-		if media.Size >= maxGroupSize {
+		if media.Size >= maxSize {
 			mp.sendOversizedMediaURL(media, mediaIdx)
 			continue
 		}
 
-		inputMedia := mp.createInputMedia(media)
+		if media.Type == download.MediaTypePhoto && media.Size > maxPhotoSize {
+			logger.Log.Sugar().Infof("Photo %s exceeds photo size limit (%s), sending as document", media.Filename, download.ByteCountBinary(media.Size))
+			media.Type = download.MediaTypeDocument
+		}
+
+		switch media.Type {
+		case download.MediaTypePhoto, download.MediaTypeVideo:
+			groupable = append(groupable, media)
+		default:
+			singles = append(singles, media)
+		}
+	}
+
+	uploads := slices.Concat(groupable, singles)
+	if len(uploads) == 0 {
+		return errNothingUploaded
+	}
+
+	groups := mp.createMediaGroups(groupable)
+
+	// Caption is shown once, under the first media group or the first single media
+	caption := mp.getCaption()
+	if len(groups) > 0 {
+		setInputMediaCaption(groups[0].medias[0], caption)
+		caption = ""
+	}
+
+	if err := mp.sendMediaGroups(groups); err != nil {
+		return err
+	}
+
+	for _, media := range singles {
+		if err := mp.sendSingleMedia(media, caption); err != nil {
+			return err
+		}
+		caption = ""
+	}
+
+	mp.delivered = model.Usage{Items: int64(len(uploads)), Bytes: mp.calculateTotalSize(uploads)}
+	return nil
+}
+
+func (mp *MediaProcessor) createMediaGroups(medias []MediaData) []mediaGroup {
+	maxGroupSize := getMaxMediaSize(mp.processCtx.features)
+
+	var groups []mediaGroup
+	var currentGroup mediaGroup
+	var currentGroupSize int64
+
+	for _, media := range medias {
+		inputMedia, thumbnail := mp.createInputMedia(media)
 		if inputMedia == nil {
 			continue
 		}
 
-		if currentGroupSize+media.Size > maxGroupSize && len(currentGroup) > 0 {
+		if currentGroupSize+media.Size > maxGroupSize && len(currentGroup.medias) > 0 {
 			groups = append(groups, currentGroup)
-			currentGroup = nil
+			currentGroup = mediaGroup{}
 			currentGroupSize = 0
 		}
 
-		currentGroup = append(currentGroup, inputMedia)
+		currentGroup.medias = append(currentGroup.medias, inputMedia)
+		if thumbnail != nil {
+			currentGroup.attachments = append(currentGroup.attachments, *thumbnail)
+		}
 		currentGroupSize += media.Size
 	}
 
-	if len(currentGroup) > 0 {
+	if len(currentGroup.medias) > 0 {
 		groups = append(groups, currentGroup)
 	}
 
 	return groups
 }
 
+// sendSingleMedia sends media that can't be part of a media group
+func (mp *MediaProcessor) sendSingleMedia(media MediaData, caption string) error {
+	inputFile, closeFile, err := mp.createInputFile(media)
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	replyParameters := &models.ReplyParameters{
+		MessageID: mp.processCtx.originalMsgID,
+	}
+
+	var msg *models.Message
+	switch media.Type {
+	case download.MediaTypeAnimation:
+		msg, err = mp.bot.SendAnimation(mp.processCtx.ctx, &bot.SendAnimationParams{
+			ChatID:          mp.processCtx.chatID,
+			Animation:       inputFile,
+			Caption:         caption,
+			ReplyParameters: replyParameters,
+		})
+	default:
+		// Keep documents as files, otherwise Telegram turns uploaded mp4 files back into videos
+		msg, err = mp.bot.SendDocument(mp.processCtx.ctx, &bot.SendDocumentParams{
+			ChatID:                      mp.processCtx.chatID,
+			Document:                    inputFile,
+			Caption:                     caption,
+			DisableContentTypeDetection: true,
+			ReplyParameters:             replyParameters,
+		})
+	}
+
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to send %s %s: %v", media.Type, media.Filename, err)
+		return err
+	}
+	mp.addSentMessages(msg)
+
+	return nil
+}
+
 func (mp *MediaProcessor) sendOversizedMediaURL(media MediaData, index int) {
-	text := fmt.Sprintf("%d. %s\nFile (%d) too large to send directly (%.2f MB). Direct URL: %s",
+	text := mp.processCtx.loc.T("status.too_large",
 		mp.processCtx.urlIndex+1, mp.processCtx.url, index+1,
 		float64(media.Size)/1024.0/1024.0, media.DirectURL)
 
+	// The self-hosted server could send it on a plan with large files
+	if config.GetConfig().TelegramBot.APIServer.Enabled && !mp.processCtx.features.Has(model.FeatureLargeFiles) {
+		text += "\n\n" + mp.processCtx.loc.T("error.feature_locked", mp.processCtx.loc.T(getFeatureTitle(model.FeatureLargeFiles.String())))
+	}
+
 	_, err := mp.bot.SendMessage(mp.processCtx.ctx, &bot.SendMessageParams{
 		ChatID: mp.processCtx.chatID,
 		Text:   text,
@@ -256,60 +748,209 @@ func (mp *MediaProcessor) sendOversizedMediaURL(media MediaData, index int) {
 	}
 }
 
-func (mp *MediaProcessor) createInputMedia(media MediaData) models.InputMedia {
-	mediaType := download.DetectFileType(media.Filename)
+// createInputMedia returns the input media to send, and the thumbnail to upload alongside it if any
+func (mp *MediaProcessor) createInputMedia(media MediaData) (models.InputMedia, *attachment) {
+	// Spooled files are read by the local Bot API server directly from disk
+	mediaRef := fmt.Sprintf("attach://%s", media.Filename)
+	if isLocalAPIServer() && media.LocalPath != "" {
+		mediaRef = "file://" + media.LocalPath
+	}
 
-	switch mediaType {
-	case "video":
-		return &models.InputMediaVideo{
-			Media:           fmt.Sprintf("attach://%s", media.Filename),
-			MediaAttachment: media.Media,
+	switch media.Type {
+	case download.MediaTypeVideo:
+		inputMedia := &models.InputMediaVideo{
+			Media:             mediaRef,
+			Width:             media.Width,
+			Height:            media.Height,
+			Duration:          media.Duration,
+			SupportsStreaming: true,
+			MediaAttachment:   media.Media,
 		}
-	case "photo":
+
+		if media.ThumbnailPath == "" {
+			return inputMedia, nil
+		}
+
+		if isLocalAPIServer() {
+			inputMedia.Thumbnail = &models.InputFileString{Data: "file://" + media.ThumbnailPath}
+			return inputMedia, nil
+		}
+
+		thumbnail := &attachment{
+			Name: "thumb_" + strings.TrimSuffix(media.Filename, filepath.Ext(media.Filename)),
+			Path: media.ThumbnailPath,
+		}
+		inputMedia.Thumbnail = &models.InputFileString{Data: "attach://" + thumbnail.Name}
+		return inputMedia, thumbnail
+	case download.MediaTypePhoto:
 		return &models.InputMediaPhoto{
-			Media:           fmt.Sprintf("attach://%s", media.Filename),
+			Media:           mediaRef,
 			MediaAttachment: media.Media,
-		}
+		}, nil
 	default:
 		logger.Log.Sugar().Errorf("Unsupported media type for file %s", media.Filename)
-		return nil
+		return nil, nil
 	}
 }
 
-func (mp *MediaProcessor) sendMediaGroups(groups [][]models.InputMedia) error {
+func (mp *MediaProcessor) sendMediaGroups(groups []mediaGroup) error {
 	for _, group := range groups {
-		if len(group) == 0 {
+		if len(group.medias) == 0 {
 			continue
 		}
 
-		_, err := mp.bot.SendMediaGroup(mp.processCtx.ctx, &bot.SendMediaGroupParams{
+		params := &bot.SendMediaGroupParams{
 			ChatID: mp.processCtx.chatID,
-			Media:  group,
+			Media:  group.medias,
 			ReplyParameters: &models.ReplyParameters{
 				MessageID: mp.processCtx.originalMsgID,
 			},
-		})
+		}
+
+		var msgs []*models.Message
+		var err error
+		if len(group.attachments) > 0 {
+			msgs, err = mp.bot.sendMediaGroupWithAttachments(mp.processCtx.ctx, params, group.attachments)
+		} else {
+			msgs, err = mp.bot.SendMediaGroup(mp.processCtx.ctx, params)
+		}
 
 		if err != nil {
 			logger.Log.Sugar().Errorf("Failed to send media group: %v", err)
 			return err
 		}
+		mp.addSentMessages(msgs...)
 	}
 
 	return nil
 }
 
+func (mp *MediaProcessor) sendAudios(medias []MediaData) error {
+	caption := mp.getCaption()
+
+	title := mp.metadata.Title
+	if len([]rune(title)) > 64 {
+		title = string([]rune(title)[:64]) + "…"
+	}
+
+	for _, media := range medias {
+		audio, closeFile, err := mp.createInputFile(media)
+		if err != nil {
+			return err
+		}
+
+		// Opus in ogg is what Telegram clients play as voice messages
+		var msg *models.Message
+		if strings.EqualFold(filepath.Ext(media.Filename), ".ogg") {
+			msg, err = mp.bot.SendVoice(mp.processCtx.ctx, &bot.SendVoiceParams{
+				ChatID:  mp.processCtx.chatID,
+				Voice:   audio,
+				Caption: caption,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: mp.processCtx.originalMsgID,
+				},
+			})
+		} else {
+			msg, err = mp.bot.SendAudio(mp.processCtx.ctx, &bot.SendAudioParams{
+				ChatID:    mp.processCtx.chatID,
+				Audio:     audio,
+				Title:     title,
+				Performer: mp.metadata.Author,
+				Caption:   caption,
+				ReplyParameters: &models.ReplyParameters{
+					MessageID: mp.processCtx.originalMsgID,
+				},
+			})
+		}
+		closeFile()
+
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to send audio: %v", err)
+			return err
+		}
+		mp.addSentMessages(msg)
+	}
+
+	mp.delivered = model.Usage{Items: int64(len(medias)), Bytes: mp.calculateTotalSize(medias)}
+	return nil
+}
+
+// getCaption returns the caption of sent media, empty if disabled in settings
+func (mp *MediaProcessor) getCaption() string {
+	return getCaption(mp.processCtx.settings, mp.metadata, mp.processCtx.url)
+}
+
+// getCaption builds a caption from the saver metadata and the source link
+func getCaption(settings sqlc.AccountSetting, metadata mediasaverbase.Metadata, url string) string {
+	if !settings.Caption {
+		return ""
+	}
+
+	var lines []string
+	if metadata.Author != "" {
+		lines = append(lines, "👤 "+metadata.Author)
+	}
+	if metadata.Title != "" {
+		lines = append(lines, metadata.Title)
+	}
+	lines = append(lines, "🔗 "+url)
+
+	caption := []rune(strings.Join(lines, "\n"))
+	if len(caption) > maxCaptionLength {
+		caption = append(caption[:maxCaptionLength-1], '…')
+	}
+
+	return string(caption)
+}
+
+func setInputMediaCaption(inputMedia models.InputMedia, caption string) {
+	switch m := inputMedia.(type) {
+	case *models.InputMediaVideo:
+		m.Caption = caption
+	case *models.InputMediaPhoto:
+		m.Caption = caption
+	}
+}
+
+// createInputFile returns an input file for a media, the returned func closes the file if it had to be opened
+func (mp *MediaProcessor) createInputFile(media MediaData) (models.InputFile, func(), error) {
+	if isLocalAPIServer() && media.LocalPath != "" {
+		return &models.InputFileString{Data: "file://" + media.LocalPath}, func() {}, nil
+	}
+
+	if media.Media != nil {
+		return &models.InputFileUpload{Filename: media.Filename, Data: media.Media}, func() {}, nil
+	}
+
+	f, err := os.Open(media.LocalPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", media.LocalPath, err)
+	}
+
+	return &models.InputFileUpload{Filename: media.Filename, Data: f}, func() { f.Close() }, nil
+}
+
 func (mp *MediaProcessor) updateStatusMessage(state string) {
-	text := fmt.Sprintf("%d. %s\nState: %s", mp.processCtx.urlIndex+1, mp.processCtx.url, state)
+	mp.editStatusMessage(state, nil)
+}
+
+// editStatusMessage sets the state of the status message, a nil keyboard removes its buttons
+func (mp *MediaProcessor) editStatusMessage(state string, keyboard *models.InlineKeyboardMarkup) {
+	text := mp.processCtx.loc.T("status.line", mp.processCtx.urlIndex+1, mp.processCtx.url, state)
 
-	_, err := mp.bot.EditMessageText(mp.processCtx.ctx, &bot.EditMessageTextParams{
+	params := &bot.EditMessageTextParams{
 		ChatID:    mp.processCtx.chatID,
 		MessageID: mp.processCtx.statusMsg.ID,
 		Text:      text,
 		LinkPreviewOptions: &models.LinkPreviewOptions{
 			IsDisabled: ptr.ToPtr(true),
 		},
-	})
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+
+	_, err := mp.bot.EditMessageText(mp.processCtx.ctx, params)
 
 	if err != nil {
 		logger.Log.Sugar().Errorf("Failed to edit message text: \"%s\" %v", text, err)
@@ -327,6 +968,16 @@ func (mp *MediaProcessor) deleteStatusMessage() {
 	}
 }
 
+// fixExtension replaces the extension of filename with the one matching the detected content type
+func fixExtension(filename, contentType string) string {
+	ext := download.ExtensionFromContentType(contentType)
+	if ext == "" || strings.EqualFold(filepath.Ext(filename), ext) {
+		return filename
+	}
+
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
+
 func getSizeStr(fileSize int64) string {
 	if fileSize == 0 {
 		return ""
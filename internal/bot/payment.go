@@ -0,0 +1,555 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	paymentbase "github.com/codeonbeans/botfetchr/internal/client/payment/base"
+	"github.com/codeonbeans/botfetchr/internal/client/payment/fake"
+	"github.com/codeonbeans/botfetchr/internal/client/payment/stars"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Callback data is "upgrade:<plan price id>"
+const upgradeCallbackPrefix = "upgrade:"
+
+var (
+	errInvoiceAlreadyPaid = errors.New("invoice is already paid")
+	errInvalidInvoice     = errors.New("invoice does not match the payment")
+)
+
+// PaymentProvider takes the payments of invoices, paid and refunded payments are reported as events
+type PaymentProvider interface {
+	Name() string // Stored with invoices, webhooks of the provider are posted to /payments/<name>
+	Currency() string
+	CreateCheckout(ctx context.Context, req paymentbase.CheckoutRequest) (paymentbase.Checkout, error)
+	VerifyWebhook(r *http.Request) (paymentbase.Event, error)
+	Refund(ctx context.Context, req paymentbase.RefundRequest) error
+}
+
+// setupPayments selects the provider plans are sold with. Telegram Stars are always accepted,
+// payments of invoices sent before the provider was changed still arrive.
+func (b *DefaultBot) setupPayments() error {
+	cfg := config.GetConfig()
+
+	starsClient := stars.NewClient(b.Bot)
+	b.paymentProviders = map[string]PaymentProvider{starsClient.Name(): starsClient}
+	b.paymentProvider = starsClient
+
+	if cfg.TelegramBot.Payments.Provider == fake.Name {
+		fakeConfig := cfg.TelegramBot.Payments.Fake
+		if cfg.IsProduction() {
+			return errors.New("fake payment provider can't be used in production")
+		}
+		if fakeConfig.Url == "" || fakeConfig.Secret == "" {
+			return errors.New("fake payment provider needs a url and a secret")
+		}
+
+		currency := fakeConfig.Currency
+		if currency == "" {
+			currency = stars.Currency
+		}
+
+		fakeClient := fake.NewClient(fakeConfig.Secret, fakeConfig.Url, currency)
+		b.paymentProviders[fakeClient.Name()] = fakeClient
+		b.paymentProvider = fakeClient
+
+		logger.Log.Sugar().Warn("Using the fake payment provider, payments are not real")
+	}
+
+	return nil
+}
+
+func matchPreCheckoutQuery(update *models.Update) bool {
+	return update.PreCheckoutQuery != nil
+}
+
+func matchSuccessfulPayment(update *models.Update) bool {
+	return update.Message != nil && update.Message.SuccessfulPayment != nil
+}
+
+func matchRefundedPayment(update *models.Update) bool {
+	return update.Message != nil && update.Message.RefundedPayment != nil
+}
+
+// handleUpgrade lists the prices of the paid plans, each button starts a checkout with the payment provider
+func (b *DefaultBot) handleUpgrade(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	// Invoices are paid by whoever presses the button, in groups that might not be the sender
+	if update.Message.Chat.Type != models.ChatTypePrivate {
+		b.reply(ctx, update, loc.T("upgrade.private"))
+		return
+	}
+
+	subscription, err := b.GetActiveSubscription(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get subscription of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("upgrade.failed"))
+		return
+	}
+	if isNeverEnding(subscription) {
+		b.reply(ctx, update, loc.T("upgrade.never_ends", getPlanTitle(subscription.PlanID)))
+		return
+	}
+
+	prices, err := b.storage.ListPlanPrices(ctx, sqlc.ListPlanPricesParams{Limit: 100})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to list plan prices: %v", err)
+		b.reply(ctx, update, loc.T("upgrade.failed"))
+		return
+	}
+
+	var rows [][]models.InlineKeyboardButton
+	for _, price := range prices {
+		if price.PlanID == model.PlanFree.String() || price.Price <= 0 {
+			continue
+		}
+
+		rows = append(rows, []models.InlineKeyboardButton{{
+			Text: loc.T("upgrade.button", getPlanTitle(price.PlanID), getIntervalTitle(loc, price.Interval),
+				formatPrice(int(price.Price), b.paymentProvider.Currency())),
+			CallbackData: upgradeCallbackPrefix + strconv.FormatInt(price.ID, 10),
+		}})
+	}
+	if len(rows) == 0 {
+		b.reply(ctx, update, loc.T("upgrade.no_prices"))
+		return
+	}
+
+	if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        loc.T("upgrade.title", getPlanTitle(subscription.PlanID)),
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: rows},
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: update.Message.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send prices: %v", err)
+	}
+}
+
+// handleUpgradeCallback creates an invoice for the chosen price and starts its checkout in the chat of the button
+func (b *DefaultBot) handleUpgradeCallback(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+
+	answer := func(text string) {
+		if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to answer callback query: %v", err)
+		}
+	}
+
+	account, err := b.getOrCreateAccount(ctx, &query.From)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account for upgrade callback: %v", err)
+		answer(i18n.New(query.From.LanguageCode).T("upgrade.invoice_failed"))
+		return
+	}
+	loc := b.getAccountLocalizer(ctx, account)
+
+	priceID, err := strconv.ParseInt(strings.TrimPrefix(query.Data, upgradeCallbackPrefix), 10, 64)
+	if err != nil || query.Message.Message == nil {
+		answer(loc.T("payment.invalid"))
+		return
+	}
+	chatID := query.Message.Message.Chat.ID
+
+	price, err := b.storage.GetPlanPrice(ctx, priceID)
+	if errors.Is(err, sql.ErrNoRows) || price.PlanID == model.PlanFree.String() {
+		answer(loc.T("payment.invalid"))
+		return
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get plan price %d: %v", priceID, err)
+		answer(loc.T("upgrade.invoice_failed"))
+		return
+	}
+
+	provider := b.paymentProvider
+	invoice, err := b.storage.CreatePlanInvoice(ctx, sqlc.CreatePlanInvoiceParams{
+		AccountID:   pgtype.Int8{Int64: account.ID, Valid: true},
+		PlanPriceID: pgtype.Int8{Int64: price.ID, Valid: true},
+		Amount:      price.Price,
+		Currency:    provider.Currency(),
+		Provider:    provider.Name(),
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to create invoice for account %d: %v", account.ID, err)
+		answer(loc.T("upgrade.invoice_failed"))
+		return
+	}
+
+	plan, interval := getPlanTitle(price.PlanID), getIntervalTitle(loc, price.Interval)
+	checkout, err := provider.CreateCheckout(ctx, paymentbase.CheckoutRequest{
+		InvoiceID:   invoice.ID,
+		ChatID:      chatID,
+		Title:       loc.T("upgrade.invoice_title", plan),
+		Description: loc.T("upgrade.invoice_description", plan, interval),
+		Currency:    invoice.Currency,
+		Amount:      int(invoice.Amount),
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to create checkout of invoice %s with %s: %v", invoice.ID, provider.Name(), err)
+		answer(loc.T("upgrade.invoice_failed"))
+		return
+	}
+
+	if err = b.storage.SetInvoiceCheckoutID(ctx, sqlc.SetInvoiceCheckoutIDParams{
+		ID:         invoice.ID,
+		CheckoutID: pgtype.Text{String: checkout.ID, Valid: true},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to save checkout of invoice %s: %v", invoice.ID, err)
+	}
+
+	answer("")
+
+	// Providers without invoices in Telegram are paid on their checkout page
+	if checkout.URL != "" {
+		if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   loc.T("upgrade.checkout", plan, interval),
+			ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{{
+				Text: loc.T("upgrade.pay_button", formatPrice(int(invoice.Amount), invoice.Currency)),
+				URL:  checkout.URL,
+			}}}},
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to send checkout of invoice %s: %v", invoice.ID, err)
+		}
+	}
+}
+
+// handlePreCheckoutQuery confirms a payment in Stars only if its invoice is still open and matches what is paid.
+// Telegram cancels the payment if there is no answer within 10 seconds.
+func (b *DefaultBot) handlePreCheckoutQuery(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.PreCheckoutQuery
+
+	params := &bot.AnswerPreCheckoutQueryParams{PreCheckoutQueryID: query.ID, OK: true}
+
+	account, err := b.getOrCreateAccount(ctx, query.From)
+	if err == nil {
+		err = b.checkInvoice(ctx, account.ID, query.InvoicePayload, query.Currency, query.TotalAmount)
+	}
+	if err != nil {
+		logger.Log.Sugar().Warnf("Rejected payment of invoice %s by user %d: %v", query.InvoicePayload, query.From.ID, err)
+
+		params.OK = false
+		params.ErrorMessage = b.getAccountLocalizer(ctx, account).T("payment.invalid")
+	}
+
+	if _, err = b.AnswerPreCheckoutQuery(ctx, params); err != nil {
+		logger.Log.Sugar().Errorf("Failed to answer pre-checkout query: %v", err)
+	}
+}
+
+// checkInvoice returns an error if an invoice in Stars can't be paid by the account with the given amount
+func (b *DefaultBot) checkInvoice(ctx context.Context, accountID int64, invoiceID, currency string, amount int) error {
+	invoice, err := b.storage.GetInvoice(ctx, invoiceID)
+	if err != nil {
+		return fmt.Errorf("failed to get invoice: %w", err)
+	}
+	if invoice.Paid {
+		return errInvoiceAlreadyPaid
+	}
+	if invoice.AccountID.Int64 != accountID {
+		return errInvalidInvoice
+	}
+	if err = matchInvoice(invoice, stars.Name, currency, amount); err != nil {
+		return err
+	}
+
+	subscription, err := b.GetActiveSubscription(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if isNeverEnding(subscription) {
+		return errPlanNeverEnds
+	}
+
+	return nil
+}
+
+func (b *DefaultBot) handleSuccessfulPayment(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	msg := update.Message
+	b.handlePaymentEvent(ctx, b.paymentProviders[stars.Name], stars.NewPaidEvent(msg.From.ID, msg.SuccessfulPayment))
+}
+
+func (b *DefaultBot) handleRefundedPayment(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	msg := update.Message
+	b.handlePaymentEvent(ctx, b.paymentProviders[stars.Name], stars.NewRefundedEvent(msg.From.ID, msg.RefundedPayment))
+}
+
+// handlePaymentEvent applies an event of a provider to its invoice and tells the buyer.
+// Payments that can't be applied are refunded, events that were applied before are ignored.
+func (b *DefaultBot) handlePaymentEvent(ctx context.Context, provider PaymentProvider, event paymentbase.Event) {
+	switch event.Type {
+	case paymentbase.EventTypePaid:
+		b.handlePaid(ctx, provider, event)
+	case paymentbase.EventTypeRefunded:
+		b.handleRefunded(ctx, provider, event)
+	default:
+		logger.Log.Sugar().Warnf("Ignored payment event %q of %s", event.Type, provider.Name())
+	}
+}
+
+func (b *DefaultBot) handlePaid(ctx context.Context, provider PaymentProvider, event paymentbase.Event) {
+	account, subscription, err := b.applyPayment(ctx, provider.Name(), event)
+	if errors.Is(err, errInvoiceAlreadyPaid) {
+		// The provider reported the payment again
+		return
+	}
+
+	// The buyer is known to the provider or by the invoice
+	telegramID := event.UserID
+	if telegramID == 0 {
+		telegramID = account.TelegramID
+	}
+	loc := b.getAccountLocalizer(ctx, account)
+	notify := func(text string) {
+		if telegramID != 0 {
+			b.notify(ctx, telegramID, text)
+		}
+	}
+
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to apply payment %s of %s: %v", event.ChargeID, provider.Name(), err)
+
+		if err = provider.Refund(ctx, paymentbase.RefundRequest{
+			ChargeID: event.ChargeID,
+			UserID:   telegramID,
+			Currency: event.Currency,
+			Amount:   event.Amount,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to refund payment %s of %s: %v", event.ChargeID, provider.Name(), err)
+			notify(loc.T("payment.failed"))
+			return
+		}
+
+		notify(loc.T("payment.refunded"))
+		return
+	}
+
+	logger.Log.Sugar().Infof("Account %d paid %d %s with %s for plan %s", account.ID, event.Amount, event.Currency, provider.Name(), subscription.PlanID)
+
+	if subscription.EndDate.Valid {
+		notify(loc.T("payment.success", getPlanTitle(subscription.PlanID), subscription.EndDate.Time.Format(time.DateOnly)))
+	} else {
+		notify(loc.T("payment.success_forever", getPlanTitle(subscription.PlanID)))
+	}
+}
+
+// applyPayment marks the invoice of a payment as paid and starts or extends its plan in one transaction
+func (b *DefaultBot) applyPayment(ctx context.Context, provider string, event paymentbase.Event) (sqlc.AccountTelegram, sqlc.SubscriptionSubscription, error) {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.AccountTelegram{}, sqlc.SubscriptionSubscription{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	invoice, err := txStorage.PayInvoice(ctx, sqlc.PayInvoiceParams{
+		ID:                      event.InvoiceID,
+		ChargeID:                pgtype.Text{String: event.ChargeID, Valid: true},
+		ProviderPaymentChargeID: pgtype.Text{String: event.ProviderChargeID, Valid: event.ProviderChargeID != ""},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Reported again, or a second payment of the invoice which is refunded
+		paid, err := txStorage.GetInvoice(ctx, event.InvoiceID)
+		if err == nil && paid.Provider == provider && paid.ChargeID.String == event.ChargeID {
+			return sqlc.AccountTelegram{}, sqlc.SubscriptionSubscription{}, errInvoiceAlreadyPaid
+		}
+		return sqlc.AccountTelegram{}, sqlc.SubscriptionSubscription{}, errInvalidInvoice
+	}
+	if err != nil {
+		return sqlc.AccountTelegram{}, sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to pay invoice: %w", err)
+	}
+
+	account, err := txStorage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{ID: invoice.AccountID})
+	if err != nil {
+		return sqlc.AccountTelegram{}, sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to get account of invoice: %w", err)
+	}
+	if event.UserID != 0 && event.UserID != account.TelegramID {
+		return account, sqlc.SubscriptionSubscription{}, errInvalidInvoice
+	}
+	if err = matchInvoice(invoice, provider, event.Currency, event.Amount); err != nil {
+		return account, sqlc.SubscriptionSubscription{}, err
+	}
+
+	price, err := txStorage.GetPlanPrice(ctx, invoice.PlanPriceID.Int64)
+	if err != nil {
+		return account, sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to get plan price: %w", err)
+	}
+
+	subscription, extendedFrom, err := extendSubscription(ctx, txStorage, account.ID, price.PlanID, func(from time.Time) pgtype.Timestamptz {
+		return getPriceEnd(price.Interval, from)
+	})
+	if err != nil {
+		return account, sqlc.SubscriptionSubscription{}, err
+	}
+
+	// The time the invoice paid for is kept, so a refund takes back only that and not what later invoices added
+	if _, err = txStorage.UpdateInvoice(ctx, sqlc.UpdateInvoiceParams{
+		ID:             invoice.ID,
+		SubscriptionID: pgtype.Text{String: subscription.ID, Valid: true},
+		ExtendedFrom:   extendedFrom,
+		ExtendedTo:     subscription.EndDate,
+	}); err != nil {
+		return account, sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to update invoice: %w", err)
+	}
+
+	return account, subscription, txStorage.Commit(ctx)
+}
+
+func (b *DefaultBot) handleRefunded(ctx context.Context, provider PaymentProvider, event paymentbase.Event) {
+	account, err := b.revokePayment(ctx, provider.Name(), event)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Refunded before, or refunded by the bot because it could not be applied
+		return
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to revoke refunded payment %s of %s: %v", event.ChargeID, provider.Name(), err)
+		return
+	}
+
+	logger.Log.Sugar().Infof("Payment %s of account %d was refunded by %s", event.ChargeID, account.ID, provider.Name())
+	b.notify(ctx, account.TelegramID, b.getAccountLocalizer(ctx, account).T("payment.refund_received"))
+}
+
+// revokePayment marks the invoice of a refunded payment and takes back the subscription time it paid for.
+// sql.ErrNoRows is returned if there is no paid invoice of the payment that is not refunded yet.
+func (b *DefaultBot) revokePayment(ctx context.Context, provider string, event paymentbase.Event) (sqlc.AccountTelegram, error) {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.AccountTelegram{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	invoice, err := txStorage.RefundInvoice(ctx, sqlc.RefundInvoiceParams{
+		Provider: provider,
+		ChargeID: pgtype.Text{String: event.ChargeID, Valid: true},
+	})
+	if err != nil {
+		return sqlc.AccountTelegram{}, err
+	}
+
+	account, err := txStorage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{ID: invoice.AccountID})
+	if err != nil {
+		return sqlc.AccountTelegram{}, fmt.Errorf("failed to get account of invoice: %w", err)
+	}
+
+	switch {
+	case !invoice.SubscriptionID.Valid:
+	case invoice.ExtendedFrom.Valid:
+		if err = revokeInvoicePeriod(ctx, txStorage, account.ID, invoice); err != nil {
+			return account, err
+		}
+	default:
+		// Paid before the time of invoices was kept, the subscription it paid for is canceled
+		if _, err = txStorage.UpdateSubscription(ctx, sqlc.UpdateSubscriptionParams{
+			ID:       invoice.SubscriptionID.String,
+			Status:   sqlc.NullSubscriptionStatuses{SubscriptionStatuses: sqlc.SubscriptionStatusesCANCELED, Valid: true},
+			CancelAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		}); err != nil {
+			return account, fmt.Errorf("failed to cancel subscription: %w", err)
+		}
+	}
+
+	return account, txStorage.Commit(ctx)
+}
+
+// revokeInvoicePeriod takes the time a refunded invoice paid for back from its subscription, started or extended by it.
+// Time paid for by other invoices is kept, the subscription is canceled if nothing is left.
+func revokeInvoicePeriod(ctx context.Context, txStorage *storage.TxStorage, accountID int64, invoice sqlc.SubscriptionInvoice) error {
+	subscriptions, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(subscriptions, func(subscription sqlc.SubscriptionSubscription) bool {
+		return subscription.ID == invoice.SubscriptionID.String
+	})
+	// Ended or replaced since, there is no time left to take back
+	if i < 0 {
+		return nil
+	}
+	subscription := subscriptions[i]
+
+	var end pgtype.Timestamptz
+	switch {
+	case !invoice.ExtendedTo.Valid:
+		// A lifetime plan was bought, the subscription ends where it did before
+		end = invoice.ExtendedFrom
+	case !subscription.EndDate.Valid:
+		// A later lifetime purchase covers the refunded time
+		return nil
+	default:
+		end = pgtype.Timestamptz{Time: subscription.EndDate.Time.Add(-invoice.ExtendedTo.Time.Sub(invoice.ExtendedFrom.Time)), Valid: true}
+	}
+
+	params := sqlc.UpdateSubscriptionParams{
+		ID:      subscription.ID,
+		EndDate: end,
+	}
+	if now := time.Now(); !end.Time.After(now) {
+		params.Status = sqlc.NullSubscriptionStatuses{SubscriptionStatuses: sqlc.SubscriptionStatusesCANCELED, Valid: true}
+		params.CancelAt = pgtype.Timestamptz{Time: now, Valid: true}
+	}
+	if _, err = txStorage.UpdateSubscription(ctx, params); err != nil {
+		return fmt.Errorf("failed to shorten subscription: %w", err)
+	}
+
+	return nil
+}
+
+// matchInvoice returns errInvalidInvoice if an invoice is not for a plan or was issued for another provider or amount
+func matchInvoice(invoice sqlc.SubscriptionInvoice, provider, currency string, amount int) error {
+	if !invoice.PlanPriceID.Valid || invoice.Provider != provider ||
+		invoice.Currency != currency || int(invoice.Amount) != amount {
+		return errInvalidInvoice
+	}
+	return nil
+}
+
+// isNeverEnding reports whether a subscription is on a paid plan without end, there is nothing to upgrade then
+func isNeverEnding(subscription sqlc.SubscriptionSubscription) bool {
+	return subscription.PlanID != model.PlanFree.String() && !subscription.EndDate.Valid
+}
+
+// getPriceEnd returns the end of a plan bought at a price from the given time, not valid for lifetime plans
+func getPriceEnd(interval sqlc.SubscriptionPlanIntervals, from time.Time) pgtype.Timestamptz {
+	switch interval {
+	case sqlc.SubscriptionPlanIntervalsMONTHLY:
+		return pgtype.Timestamptz{Time: from.AddDate(0, 1, 0), Valid: true}
+	case sqlc.SubscriptionPlanIntervalsYEARLY:
+		return pgtype.Timestamptz{Time: from.AddDate(1, 0, 0), Valid: true}
+	default:
+		return pgtype.Timestamptz{}
+	}
+}
+
+func getIntervalTitle(loc *i18n.Localizer, interval sqlc.SubscriptionPlanIntervals) string {
+	return loc.T("upgrade.interval." + strings.ToLower(string(interval)))
+}
+
+// formatPrice formats an amount in the smallest unit of a currency, e.g. "100 ⭐"
+func formatPrice(amount int, currency string) string {
+	if currency == stars.Currency {
+		return fmt.Sprintf("%d ⭐", amount)
+	}
+	return fmt.Sprintf("%d %s", amount, currency)
+}
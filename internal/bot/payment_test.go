@@ -0,0 +1,258 @@
+package tgbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	paymentbase "github.com/codeonbeans/botfetchr/internal/client/payment/base"
+	"github.com/codeonbeans/botfetchr/internal/client/payment/fake"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+
+	"github.com/go-telegram/bot"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap/zaptest"
+)
+
+const (
+	testWebhookURL    = "http://bot.test/payments/" + fake.Name
+	testWebhookSecret = "secret"
+	testPlanPrice     = 100
+)
+
+// newTestPaymentBot returns a bot that sells plans with a fake provider, its messages go to a Telegram API that accepts all.
+// store may be nil for tests that don't get past the webhook signature.
+func newTestPaymentBot(t *testing.T, store *storage.Storage) (*DefaultBot, *fake.Client) {
+	t.Helper()
+	logger.Log = zaptest.NewLogger(t)
+
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`))
+	}))
+	t.Cleanup(telegram.Close)
+
+	api, err := bot.New("test:token", bot.WithServerURL(telegram.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("failed to create bot: %v", err)
+	}
+
+	provider := fake.NewClient(testWebhookSecret, testWebhookURL, "USD")
+	return &DefaultBot{
+		Bot:              api,
+		storage:          store,
+		paymentProvider:  provider,
+		paymentProviders: map[string]PaymentProvider{fake.Name: provider},
+	}, provider
+}
+
+// postTestWebhook posts the signed webhook of an event to the bot and returns the status it answered with
+func postTestWebhook(t *testing.T, b *DefaultBot, provider *fake.Client, event paymentbase.Event) int {
+	t.Helper()
+	ctx := context.Background()
+
+	req, err := provider.NewWebhookRequest(ctx, event)
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /payments/{provider}", b.paymentWebhookHandler(ctx))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// newTestPrice creates a monthly price of a plan
+func newTestPrice(t *testing.T, store *storage.Storage, planID string) sqlc.SubscriptionPlanPrice {
+	t.Helper()
+
+	price, err := store.CreatePlanPrice(context.Background(), sqlc.CreatePlanPriceParams{
+		PlanID:   planID,
+		Price:    testPlanPrice,
+		Interval: sqlc.SubscriptionPlanIntervalsMONTHLY,
+	})
+	if err != nil {
+		t.Fatalf("failed to create plan price: %v", err)
+	}
+	return price
+}
+
+// newTestCheckout creates an invoice of a price like /upgrade does and returns the id of its checkout
+func newTestCheckout(t *testing.T, store *storage.Storage, provider *fake.Client, accountID int64, price sqlc.SubscriptionPlanPrice) string {
+	t.Helper()
+	ctx := context.Background()
+
+	invoice, err := store.CreatePlanInvoice(ctx, sqlc.CreatePlanInvoiceParams{
+		AccountID:   pgtype.Int8{Int64: accountID, Valid: true},
+		PlanPriceID: pgtype.Int8{Int64: price.ID, Valid: true},
+		Amount:      price.Price,
+		Currency:    provider.Currency(),
+		Provider:    provider.Name(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create invoice: %v", err)
+	}
+	// Invoices of payments that were not applied belong to no subscription, nothing else deletes them
+	t.Cleanup(func() {
+		if err := store.DeleteInvoice(ctx, invoice.ID); err != nil {
+			t.Errorf("failed to delete invoice: %v", err)
+		}
+	})
+
+	checkout, err := provider.CreateCheckout(ctx, paymentbase.CheckoutRequest{
+		InvoiceID: invoice.ID,
+		Currency:  invoice.Currency,
+		Amount:    int(invoice.Amount),
+	})
+	if err != nil {
+		t.Fatalf("failed to create checkout: %v", err)
+	}
+	return checkout.ID
+}
+
+// payTestCheckout pays a checkout and posts the webhook of the payment
+func payTestCheckout(t *testing.T, b *DefaultBot, provider *fake.Client, checkoutID string) paymentbase.Event {
+	t.Helper()
+
+	event, err := provider.Pay(checkoutID)
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	if status := postTestWebhook(t, b, provider, event); status != http.StatusOK {
+		t.Fatalf("paid webhook was answered with status %d", status)
+	}
+	return event
+}
+
+// chargebackTestCheckout refunds a checkout and posts the webhook of the refund
+func chargebackTestCheckout(t *testing.T, b *DefaultBot, provider *fake.Client, checkoutID string) {
+	t.Helper()
+
+	event, err := provider.Chargeback(checkoutID)
+	if err != nil {
+		t.Fatalf("failed to charge back: %v", err)
+	}
+	if status := postTestWebhook(t, b, provider, event); status != http.StatusOK {
+		t.Fatalf("refunded webhook was answered with status %d", status)
+	}
+}
+
+func getTestSubscription(t *testing.T, b *DefaultBot, accountID int64) sqlc.SubscriptionSubscription {
+	t.Helper()
+
+	subscription, err := b.GetActiveSubscription(context.Background(), accountID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	return subscription
+}
+
+// assertEndsAround fails if a subscription does not end about the given time, the steps of a test take a moment
+func assertEndsAround(t *testing.T, subscription sqlc.SubscriptionSubscription, want time.Time) {
+	t.Helper()
+
+	if !subscription.EndDate.Valid {
+		t.Fatalf("subscription never ends, want end at %s", want)
+	}
+	if diff := subscription.EndDate.Time.Sub(want).Abs(); diff > time.Minute {
+		t.Errorf("subscription ends at %s, want %s", subscription.EndDate.Time, want)
+	}
+}
+
+func TestPaymentWebhookRejectsInvalidSignature(t *testing.T) {
+	b, _ := newTestPaymentBot(t, nil)
+
+	// Events signed with another secret are not from the provider the bot knows
+	forger := fake.NewClient("forged", testWebhookURL, "USD")
+	event := paymentbase.Event{Type: paymentbase.EventTypePaid, InvoiceID: "invoice", Currency: "USD", Amount: testPlanPrice, ChargeID: "charge"}
+
+	if status := postTestWebhook(t, b, forger, event); status != http.StatusUnauthorized {
+		t.Errorf("forged webhook was answered with status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestPaymentWebhookAppliesDuplicatePaidEventOnce(t *testing.T) {
+	store := newTestStorage(t)
+	b, provider := newTestPaymentBot(t, store)
+
+	account := newTestAccount(t, store)
+	price := newTestPrice(t, store, newTestPlan(t, store))
+	checkoutID := newTestCheckout(t, store, provider, account.ID, price)
+
+	// Providers retry webhooks, the same payment is reported twice
+	event := payTestCheckout(t, b, provider, checkoutID)
+	if status := postTestWebhook(t, b, provider, event); status != http.StatusOK {
+		t.Fatalf("duplicate webhook was answered with status %d", status)
+	}
+
+	subscription := getTestSubscription(t, b, account.ID)
+	if subscription.PlanID != price.PlanID {
+		t.Errorf("account is on plan %s, want %s", subscription.PlanID, price.PlanID)
+	}
+	assertEndsAround(t, subscription, getPriceEnd(price.Interval, subscription.StartDate.Time).Time)
+
+	if payment, _ := provider.Get(checkoutID); payment.Refunded {
+		t.Error("duplicate webhook refunded the payment")
+	}
+}
+
+func TestPaymentWebhookRefundsPaymentOnNeverEndingPlan(t *testing.T) {
+	store := newTestStorage(t)
+	b, provider := newTestPaymentBot(t, store)
+
+	account := newTestAccount(t, store)
+	lifetimePlanID := newTestPlan(t, store)
+	newTestSubscription(t, store, account.ID, lifetimePlanID)
+
+	price := newTestPrice(t, store, newTestPlan(t, store))
+	checkoutID := newTestCheckout(t, store, provider, account.ID, price)
+	payTestCheckout(t, b, provider, checkoutID)
+
+	if payment, _ := provider.Get(checkoutID); !payment.Refunded {
+		t.Error("payment that can't be applied was not refunded")
+	}
+	if subscription := getTestSubscription(t, b, account.ID); subscription.PlanID != lifetimePlanID || subscription.EndDate.Valid {
+		t.Errorf("account is on plan %s until %v, want %s without end", subscription.PlanID, subscription.EndDate, lifetimePlanID)
+	}
+}
+
+func TestPaymentWebhookRefundAfterExtensionKeepsLaterTime(t *testing.T) {
+	store := newTestStorage(t)
+	b, provider := newTestPaymentBot(t, store)
+
+	account := newTestAccount(t, store)
+	price := newTestPrice(t, store, newTestPlan(t, store))
+
+	// The first payment starts the plan, the second one extends it by another month
+	first := newTestCheckout(t, store, provider, account.ID, price)
+	payTestCheckout(t, b, provider, first)
+	second := newTestCheckout(t, store, provider, account.ID, price)
+	payTestCheckout(t, b, provider, second)
+
+	start := getTestSubscription(t, b, account.ID).StartDate.Time
+
+	// Refunding the payment that started the plan keeps the month of the extension
+	chargebackTestCheckout(t, b, provider, first)
+	subscription := getTestSubscription(t, b, account.ID)
+	if subscription.Status != sqlc.SubscriptionStatusesACTIVE {
+		t.Fatalf("subscription is %s after refunding the first payment, want %s", subscription.Status, sqlc.SubscriptionStatusesACTIVE)
+	}
+	monthEnd := getPriceEnd(price.Interval, start).Time
+	assertEndsAround(t, subscription, getPriceEnd(price.Interval, monthEnd).Time.Add(-monthEnd.Sub(start)))
+
+	// Refunding the extension too leaves no paid time
+	chargebackTestCheckout(t, b, provider, second)
+	canceled, err := store.GetSubscription(context.Background(), subscription.ID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if canceled.Status != sqlc.SubscriptionStatusesCANCELED {
+		t.Errorf("subscription is %s after refunding both payments, want %s", canceled.Status, sqlc.SubscriptionStatusesCANCELED)
+	}
+}
@@ -0,0 +1,313 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Redemptions listed by /promo
+const maxPromoRedemptions = 10
+
+var (
+	errPromoCodeUnavailable = errors.New("promo code does not exist, expired or is used up")
+	errPromoCodeRedeemed    = errors.New("promo code was redeemed by the account before")
+
+	// Codes are stored upper case, they are matched case-insensitively
+	promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{1,32}$`)
+)
+
+// startTrial gives an account on the free plan the trial plan for the configured days, once per account.
+// It is started on a /start in private or the first personal download, not for every account created,
+// e.g. by group messages or inline queries. Failures are logged, the account stays on the free plan then.
+func (b *DefaultBot) startTrial(ctx context.Context, account sqlc.AccountTelegram) {
+	cfg := config.GetConfig().TelegramBot.Subscriptions
+	if cfg.TrialDays == 0 || account.IsBot || account.TrialUsedAt.Valid {
+		return
+	}
+
+	subscription, err := b.claimTrial(ctx, account.ID, cfg.TrialPlan, cfg.TrialDays)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errPlanPaid) {
+		return
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to start trial of account %d: %v", account.ID, err)
+		return
+	}
+
+	logger.Log.Sugar().Infof("Account %d started a trial of plan %s", account.ID, subscription.PlanID)
+
+	loc := b.getAccountLocalizer(ctx, account)
+	b.notify(ctx, account.TelegramID, loc.T("trial.started", getPlanTitle(subscription.PlanID),
+		loc.N("duration.days", int64(cfg.TrialDays)), subscription.EndDate.Time.Format(time.DateOnly)))
+}
+
+// claimTrial marks the trial of an account as used and starts a trialing subscription on the plan.
+// sql.ErrNoRows is returned if the account had its trial before, errPlanPaid if it is on another plan than free.
+func (b *DefaultBot) claimTrial(ctx context.Context, accountID int64, planID string, days int) (sqlc.SubscriptionSubscription, error) {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	if _, err = txStorage.ClaimAccountTrial(ctx, accountID); err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+
+	subscriptions, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+	// A trial never replaces a plan the account paid for or redeemed
+	if len(subscriptions) > 0 && getCurrentPlanID(subscriptions[0]) != model.PlanFree.String() {
+		return sqlc.SubscriptionSubscription{}, errPlanPaid
+	}
+
+	if _, err = txStorage.CancelActiveSubscriptions(ctx, accountID); err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to cancel subscriptions: %w", err)
+	}
+
+	now := time.Now()
+	subscription, err := txStorage.CreateSubscription(ctx, sqlc.CreateSubscriptionParams{
+		AccountID: accountID,
+		PlanID:    planID,
+		Status:    sqlc.SubscriptionStatusesTRIALING,
+		StartDate: pgtype.Timestamptz{Time: now, Valid: true},
+		EndDate:   pgtype.Timestamptz{Time: now.AddDate(0, 0, days), Valid: true},
+	})
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	return subscription, txStorage.Commit(ctx)
+}
+
+func (b *DefaultBot) handleRedeem(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	code := strings.ToUpper(strings.TrimSpace(args))
+	if code == "" {
+		b.reply(ctx, update, loc.T("redeem.usage"))
+		return
+	}
+
+	subscription, err := b.redeemPromoCode(ctx, account.ID, code)
+	switch {
+	case err == nil:
+	case errors.Is(err, errPromoCodeUnavailable):
+		b.replyPromoCodeUnavailable(ctx, loc, update, code)
+		return
+	case errors.Is(err, errPromoCodeRedeemed):
+		b.reply(ctx, update, loc.T("redeem.already_redeemed", code))
+		return
+	case errors.Is(err, errPlanNeverEnds):
+		current, _ := b.GetActiveSubscription(ctx, account.ID)
+		b.reply(ctx, update, loc.T("upgrade.never_ends", getPlanTitle(current.PlanID)))
+		return
+	case errors.Is(err, errPlanPaid):
+		current, _ := b.GetActiveSubscription(ctx, account.ID)
+		b.reply(ctx, update, loc.T("redeem.plan_paid", getPlanTitle(current.PlanID), current.EndDate.Time.Format(time.DateOnly), code))
+		return
+	default:
+		logger.Log.Sugar().Errorf("Failed to redeem promo code %s for account %d: %v", code, account.ID, err)
+		b.reply(ctx, update, loc.T("redeem.failed"))
+		return
+	}
+
+	logger.Log.Sugar().Infof("Account %d redeemed promo code %s for plan %s", account.ID, code, subscription.PlanID)
+
+	if subscription.EndDate.Valid {
+		b.reply(ctx, update, loc.T("redeem.success", getPlanTitle(subscription.PlanID), subscription.EndDate.Time.Format(time.DateOnly)))
+	} else {
+		b.reply(ctx, update, loc.T("redeem.success_forever", getPlanTitle(subscription.PlanID)))
+	}
+}
+
+// replyPromoCodeUnavailable explains why a code could not be claimed, it is looked up again after the fact
+func (b *DefaultBot) replyPromoCodeUnavailable(ctx context.Context, loc *i18n.Localizer, update *models.Update, code string) {
+	promoCode, err := b.storage.GetPromoCodeByCode(ctx, code)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		b.reply(ctx, update, loc.T("redeem.not_found", code))
+	case err != nil:
+		logger.Log.Sugar().Errorf("Failed to get promo code %s: %v", code, err)
+		b.reply(ctx, update, loc.T("redeem.failed"))
+	case promoCode.ExpiresAt.Valid && !promoCode.ExpiresAt.Time.After(time.Now()):
+		b.reply(ctx, update, loc.T("redeem.expired", code))
+	default:
+		b.reply(ctx, update, loc.T("redeem.used_up", code))
+	}
+}
+
+// redeemPromoCode counts a redemption of a code and gives its plan to the account in one transaction.
+// The code row stays locked until the commit, so a code can't be redeemed more often than allowed,
+// and the unique redemption of each account stops it from redeeming a code twice.
+func (b *DefaultBot) redeemPromoCode(ctx context.Context, accountID int64, code string) (sqlc.SubscriptionSubscription, error) {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	promoCode, err := txStorage.ClaimPromoCode(ctx, code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sqlc.SubscriptionSubscription{}, errPromoCodeUnavailable
+	}
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to claim promo code: %w", err)
+	}
+
+	// Redeeming a code never cancels a paid plan, the code is not used then
+	subscription, err := grantSubscription(ctx, txStorage, accountID, promoCode.PlanID, func(from time.Time) pgtype.Timestamptz {
+		if promoCode.Days == 0 {
+			return pgtype.Timestamptz{}
+		}
+		return pgtype.Timestamptz{Time: from.AddDate(0, 0, int(promoCode.Days)), Valid: true}
+	})
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+
+	_, err = txStorage.CreatePromoCodeRedemption(ctx, sqlc.CreatePromoCodeRedemptionParams{
+		PromoCodeID:    promoCode.ID,
+		AccountID:      accountID,
+		SubscriptionID: pgtype.Text{String: subscription.ID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return sqlc.SubscriptionSubscription{}, errPromoCodeRedeemed
+	}
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to create redemption: %w", err)
+	}
+
+	return subscription, txStorage.Commit(ctx)
+}
+
+// handlePromo creates a promo code from "<code> <plan> <days> [max redemptions] [valid for days]",
+// or shows a code and its latest redemptions if only the code is given
+func (b *DefaultBot) handlePromo(ctx context.Context, _ sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 1 && (len(fields) < 3 || len(fields) > 5) {
+		b.replyAdminUsage(ctx, loc, update, "promo")
+		return
+	}
+
+	code := strings.ToUpper(fields[0])
+	if !promoCodePattern.MatchString(code) {
+		b.reply(ctx, update, loc.T("admin.promo_invalid_code"))
+		return
+	}
+
+	if len(fields) == 1 {
+		promoCode, err := b.storage.GetPromoCodeByCode(ctx, code)
+		if errors.Is(err, sql.ErrNoRows) {
+			b.reply(ctx, update, loc.T("admin.promo_not_found", code))
+			return
+		}
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to get promo code %s: %v", code, err)
+			b.reply(ctx, update, loc.T("admin.failed", err))
+			return
+		}
+
+		b.replyPromoCode(ctx, loc, update, promoCode)
+		return
+	}
+
+	// Days, max redemptions and days the code is valid for, 0 means no limit for each
+	var numbers [3]int
+	for i, field := range fields[2:] {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			b.replyAdminUsage(ctx, loc, update, "promo")
+			return
+		}
+		numbers[i] = n
+	}
+
+	planID, err := b.findPlan(ctx, fields[1])
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to find plan %s: %v", fields[1], err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+	if planID == "" {
+		plans, _ := b.storage.ListPlans(ctx, sqlc.ListPlansParams{Limit: 100})
+		b.reply(ctx, update, loc.T("admin.unknown_plan", fields[1], strings.Join(plans, ", ")))
+		return
+	}
+
+	if _, err = b.storage.GetPromoCodeByCode(ctx, code); err == nil {
+		b.reply(ctx, update, loc.T("admin.promo_exists", code))
+		return
+	}
+
+	var expiresAt pgtype.Timestamptz
+	if numbers[2] > 0 {
+		expiresAt = pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, numbers[2]), Valid: true}
+	}
+
+	promoCode, err := b.storage.CreatePromoCode(ctx, sqlc.CreatePromoCodeParams{
+		Code:           code,
+		PlanID:         planID,
+		Days:           int32(numbers[0]),
+		MaxRedemptions: int32(numbers[1]),
+		ExpiresAt:      expiresAt,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to create promo code %s: %v", code, err)
+		b.reply(ctx, update, loc.T("admin.failed", err))
+		return
+	}
+
+	logger.Log.Sugar().Infof("Admin %d created promo code %s for plan %s", update.Message.From.ID, code, planID)
+	b.replyPromoCode(ctx, loc, update, promoCode)
+}
+
+// replyPromoCode shows a promo code and the accounts that redeemed it last
+func (b *DefaultBot) replyPromoCode(ctx context.Context, loc *i18n.Localizer, update *models.Update, promoCode sqlc.SubscriptionPromoCode) {
+	length := loc.T("admin.promo.forever")
+	if promoCode.Days > 0 {
+		length = loc.N("duration.days", int64(promoCode.Days))
+	}
+	maxRedemptions := loc.T("admin.promo.unlimited")
+	if promoCode.MaxRedemptions > 0 {
+		maxRedemptions = strconv.Itoa(int(promoCode.MaxRedemptions))
+	}
+	expires := loc.T("admin.never")
+	if promoCode.ExpiresAt.Valid {
+		expires = promoCode.ExpiresAt.Time.Format(time.DateOnly)
+	}
+
+	var text strings.Builder
+	text.WriteString(loc.T("admin.promo", promoCode.Code, getPlanTitle(promoCode.PlanID), length, promoCode.Redemptions, maxRedemptions, expires))
+
+	redemptions, err := b.storage.ListPromoCodeRedemptions(ctx, sqlc.ListPromoCodeRedemptionsParams{
+		PromoCodeID: promoCode.ID,
+		Limit:       maxPromoRedemptions,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to list redemptions of promo code %s: %v", promoCode.Code, err)
+	}
+	if len(redemptions) > 0 {
+		text.WriteString(loc.T("admin.promo.redemptions"))
+		for _, redemption := range redemptions {
+			text.WriteString(loc.T("admin.promo.redemption", getAccountName(redemption.AccountTelegram), redemption.RedeemedAt.Time.Format(time.DateTime)))
+		}
+	}
+
+	b.reply(ctx, update, text.String())
+}
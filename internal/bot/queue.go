@@ -0,0 +1,78 @@
+package tgbot
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// downloadQueue limits the number of downloads processed at once. When every slot is busy downloads wait in line,
+// a freed slot goes to the longest waiting priority download first.
+type downloadQueue struct {
+	mu       sync.Mutex
+	slots    int // 0 is unlimited
+	running  int
+	priority []chan struct{}
+	regular  []chan struct{}
+}
+
+func newDownloadQueue(slots int) *downloadQueue {
+	return &downloadQueue{slots: slots}
+}
+
+// acquire waits for a free slot, the returned function gives it back and must be called once the download is done
+func (q *downloadQueue) acquire(ctx context.Context, priority bool) (func(), error) {
+	q.mu.Lock()
+	if q.slots <= 0 || (q.running < q.slots && len(q.priority) == 0 && len(q.regular) == 0) {
+		q.running++
+		q.mu.Unlock()
+		return sync.OnceFunc(q.release), nil
+	}
+
+	ready := make(chan struct{})
+	if priority {
+		q.priority = append(q.priority, ready)
+	} else {
+		q.regular = append(q.regular, ready)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return sync.OnceFunc(q.release), nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		// The slot can be handed over right when the context is done, it is passed on then
+		if i := slices.Index(q.priority, ready); i >= 0 {
+			q.priority = slices.Delete(q.priority, i, i+1)
+		} else if i = slices.Index(q.regular, ready); i >= 0 {
+			q.regular = slices.Delete(q.regular, i, i+1)
+		} else {
+			q.handOver()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (q *downloadQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handOver()
+}
+
+// handOver gives the slot of a finished download to the next one in line, or frees it. q.mu must be held.
+func (q *downloadQueue) handOver() {
+	var next chan struct{}
+	switch {
+	case len(q.priority) > 0:
+		next, q.priority = q.priority[0], q.priority[1:]
+	case len(q.regular) > 0:
+		next, q.regular = q.regular[0], q.regular[1:]
+	default:
+		q.running--
+		return
+	}
+	close(next)
+}
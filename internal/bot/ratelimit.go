@@ -0,0 +1,160 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	rateLimitWindow = time.Minute
+	// Slots of downloads on a replica that died are freed after this long
+	rateLimitJobTTL = 15 * time.Minute
+)
+
+// slidingWindowScript adds a download to the sorted set of the last rateLimitWindow if it has room.
+// It returns 0 if it was added, otherwise the milliseconds until the oldest download leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) >= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return math.max(tonumber(oldest[2]) + window - now, 1)
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], window)
+return 0
+`)
+
+// acquireJobScript counts a running download if fewer than the limit run, it returns 1 if it was counted
+var acquireJobScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// releaseJobScript stops counting a running download, the counter may have expired meanwhile
+var releaseJobScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// RateLimitError is returned when an account or chat starts downloads faster than its rate limit allows
+type RateLimitError struct {
+	RetryAfter time.Duration // 0 if running downloads have to finish first
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter == 0 {
+		return "too many running downloads"
+	}
+	return fmt.Sprintf("too many downloads, retry after %s", e.RetryAfter)
+}
+
+// rateLimit is the rate limit of an account or a chat
+type rateLimit struct {
+	key        string
+	perMinute  int64
+	concurrent int64
+}
+
+// startJob counts a download against the rate limits of the account and the chat, either can be empty.
+// burst is added to the downloads per minute of the account. The returned function is called once the download is done.
+//
+// Redis errors don't stop downloads, the plan quota still applies.
+func (b *DefaultBot) startJob(ctx context.Context, accountID int64, chat *sqlc.AccountChat, burst int64) (func(), error) {
+	cfg := config.GetConfig().TelegramBot.RateLimit
+	if !cfg.Enabled {
+		return func() {}, nil
+	}
+
+	var limits []rateLimit
+	if accountID != 0 {
+		// Burst allowance only raises a limit, it does not turn no limit into one
+		var perMinute int64
+		if cfg.PerMinute > 0 {
+			perMinute = int64(cfg.PerMinute) + burst
+		}
+
+		limits = append(limits, rateLimit{
+			key:        fmt.Sprintf("rate_limit:account:%d", accountID),
+			perMinute:  perMinute,
+			concurrent: int64(cfg.Concurrent),
+		})
+	}
+	if chat != nil {
+		limits = append(limits, rateLimit{
+			key:        fmt.Sprintf("rate_limit:chat:%d", chat.ID),
+			perMinute:  int64(cfg.ChatPerMinute),
+			concurrent: int64(cfg.ChatConcurrent),
+		})
+	}
+
+	// Everything counted so far is undone if a later limit is hit
+	var undo []func()
+	undoAll := func() {
+		for _, fn := range undo {
+			fn()
+		}
+	}
+
+	jobID := uuid.NewString()
+	for _, limit := range limits {
+		if limit.concurrent > 0 {
+			key := limit.key + ":running"
+			acquired, err := acquireJobScript.Run(ctx, b.redisClient, []string{key}, limit.concurrent, rateLimitJobTTL.Milliseconds()).Int()
+			if err != nil {
+				logger.Log.Sugar().Errorf("Failed to check running downloads of %s: %v", limit.key, err)
+			} else if acquired == 0 {
+				undoAll()
+				return nil, &RateLimitError{}
+			} else {
+				undo = append(undo, func() { b.releaseJob(key) })
+			}
+		}
+	}
+
+	// A link rejected for running downloads is not counted in the downloads per minute
+	jobs := len(undo)
+	for _, limit := range limits {
+		if limit.perMinute > 0 {
+			key := limit.key + ":window"
+			retryAfter, err := slidingWindowScript.Run(ctx, b.redisClient, []string{key},
+				time.Now().UnixMilli(), rateLimitWindow.Milliseconds(), limit.perMinute, jobID).Int64()
+			if err != nil {
+				logger.Log.Sugar().Errorf("Failed to check downloads per minute of %s: %v", limit.key, err)
+			} else if retryAfter > 0 {
+				undoAll()
+				return nil, &RateLimitError{RetryAfter: time.Duration(retryAfter) * time.Millisecond}
+			} else {
+				undo = append(undo, func() { b.redisClient.ZRem(context.Background(), key, jobID) })
+			}
+		}
+	}
+
+	// Started downloads stay in the window until it passes, only running ones are released when they are done
+	return sync.OnceFunc(func() {
+		for _, fn := range undo[:jobs] {
+			fn()
+		}
+	}), nil
+}
+
+func (b *DefaultBot) releaseJob(key string) {
+	if err := releaseJobScript.Run(context.Background(), b.redisClient, []string{key}).Err(); err != nil {
+		logger.Log.Sugar().Errorf("Failed to release running download of %s: %v", key, err)
+	}
+}
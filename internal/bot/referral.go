@@ -0,0 +1,232 @@
+package tgbot
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// Payload of /start links, e.g. t.me/botfetchr_bot?start=ref_k3x9m2pq
+	referralPrefix = "ref_"
+	// Referral codes are lower case letters and digits without look-alikes
+	referralCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	referralCodeLength   = 8
+)
+
+// getReferralCode returns the referral code of the arguments of /start, empty if it is not a referral link
+func getReferralCode(args string) string {
+	code, ok := strings.CutPrefix(strings.TrimSpace(args), referralPrefix)
+	if !ok || len(code) > referralCodeLength {
+		return ""
+	}
+	return code
+}
+
+// attributeReferral records that a new account joined with the referral code of another account.
+// Unknown codes are ignored, the account is created anyway.
+func (b *DefaultBot) attributeReferral(ctx context.Context, account sqlc.AccountTelegram, code string) {
+	referralCode, err := b.storage.GetReferralCodeByCode(ctx, code)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && referralCode.AccountID == account.ID) {
+		return
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get referral code %s: %v", code, err)
+		return
+	}
+
+	if _, err = b.storage.CreateReferral(ctx, sqlc.CreateReferralParams{
+		ReferrerID: referralCode.AccountID,
+		RefereeID:  account.ID,
+	}); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Log.Sugar().Errorf("Failed to refer account %d to account %d: %v", account.ID, referralCode.AccountID, err)
+		}
+		return
+	}
+
+	logger.Log.Sugar().Infof("Account %d joined with the referral code of account %d", account.ID, referralCode.AccountID)
+
+	referrer, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		ID: pgtype.Int8{Int64: referralCode.AccountID, Valid: true},
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get referrer %d to notify: %v", referralCode.AccountID, err)
+		return
+	}
+
+	loc := b.getAccountLocalizer(ctx, referrer)
+	b.notify(ctx, referrer.TelegramID, loc.T("referral.joined", getAccountName(account), getReferralBonus(loc)))
+}
+
+// rewardReferral gives the referral bonus to an account and its referrer after its first successful download.
+// Accounts that were not referred or were rewarded before get nothing.
+func (b *DefaultBot) rewardReferral(ctx context.Context, accountID int64) {
+	referral, err := b.claimReferralReward(ctx, accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to reward referral of account %d: %v", accountID, err)
+		return
+	}
+
+	logger.Log.Sugar().Infof("Rewarded referral of account %d by account %d", referral.RefereeID, referral.ReferrerID)
+
+	referee, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		ID: pgtype.Int8{Int64: referral.RefereeID, Valid: true},
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account %d to notify: %v", referral.RefereeID, err)
+		return
+	}
+	referrer, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		ID: pgtype.Int8{Int64: referral.ReferrerID, Valid: true},
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account %d to notify: %v", referral.ReferrerID, err)
+		return
+	}
+
+	refereeLoc := b.getAccountLocalizer(ctx, referee)
+	b.notify(ctx, referee.TelegramID, refereeLoc.T("referral.rewarded_referee", getReferralBonus(refereeLoc)))
+
+	referrerLoc := b.getAccountLocalizer(ctx, referrer)
+	b.notify(ctx, referrer.TelegramID, referrerLoc.T("referral.rewarded_referrer", getAccountName(referee), getReferralBonus(referrerLoc)))
+}
+
+// claimReferralReward marks the referral of an account as rewarded and gives the bonus to both accounts in one transaction.
+// sql.ErrNoRows is returned if there is no referral of the account that is not rewarded yet.
+func (b *DefaultBot) claimReferralReward(ctx context.Context, accountID int64) (sqlc.AccountReferral, error) {
+	cfg := config.GetConfig().TelegramBot.Referrals
+
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return sqlc.AccountReferral{}, err
+	}
+	defer txStorage.Rollback(ctx)
+
+	referral, err := txStorage.ClaimReferralReward(ctx, accountID)
+	if err != nil {
+		return sqlc.AccountReferral{}, err
+	}
+
+	// The referrer is always the older account, subscriptions are locked in the order of the ids
+	for _, id := range []int64{referral.ReferrerID, referral.RefereeID} {
+		if cfg.BonusDownloads > 0 {
+			if _, err = txStorage.AddAccountUsageBonus(ctx, sqlc.AddAccountUsageBonusParams{
+				AccountID: id,
+				Feature:   model.FeatureGetMedia.String(),
+				Bonus:     int64(cfg.BonusDownloads),
+			}); err != nil {
+				return sqlc.AccountReferral{}, fmt.Errorf("failed to add bonus downloads: %w", err)
+			}
+		}
+
+		if cfg.BonusDays > 0 {
+			if err = addReferralBonusDays(ctx, txStorage, id, cfg.BonusPlan, cfg.BonusDays); err != nil {
+				return sqlc.AccountReferral{}, err
+			}
+		}
+	}
+
+	return referral, txStorage.Commit(ctx)
+}
+
+// addReferralBonusDays adds bonus days of the bonus plan if it is the current plan or the account is on the free plan.
+// A reward never replaces another plan, the days are skipped then and the bonus downloads are all there is.
+func addReferralBonusDays(ctx context.Context, txStorage *storage.TxStorage, accountID int64, planID string, days int) error {
+	subscriptions, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) > 0 {
+		if currentPlanID := getCurrentPlanID(subscriptions[0]); currentPlanID != planID && currentPlanID != model.PlanFree.String() {
+			return nil
+		}
+	}
+
+	_, _, err = extendSubscription(ctx, txStorage, accountID, planID, func(from time.Time) pgtype.Timestamptz {
+		return pgtype.Timestamptz{Time: from.AddDate(0, 0, days), Valid: true}
+	})
+	// A plan that never ends has nothing to add to
+	if err != nil && !errors.Is(err, errPlanNeverEnds) {
+		return fmt.Errorf("failed to add bonus days: %w", err)
+	}
+
+	return nil
+}
+
+func (b *DefaultBot) handleReferrals(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	code, err := generateReferralCode()
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to generate referral code: %v", err)
+		b.reply(ctx, update, loc.T("referral.failed"))
+		return
+	}
+
+	referralCode, err := b.storage.GetOrCreateReferralCode(ctx, sqlc.GetOrCreateReferralCodeParams{
+		AccountID: account.ID,
+		Code:      code,
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get referral code of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("referral.failed"))
+		return
+	}
+
+	stats, err := b.storage.GetReferralStats(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get referral stats of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("referral.failed"))
+		return
+	}
+
+	link := fmt.Sprintf("https://t.me/%s?start=%s%s", b.me.Username, referralPrefix, referralCode.Code)
+	b.reply(ctx, update, loc.T("referral.title", link, getReferralBonus(loc), stats.Invited, stats.Rewarded))
+}
+
+// getReferralBonus describes the bonus both accounts of a referral get, e.g. "10 extra downloads and 3 days of Pro"
+func getReferralBonus(loc *i18n.Localizer) string {
+	cfg := config.GetConfig().TelegramBot.Referrals
+
+	var bonuses []string
+	if cfg.BonusDownloads > 0 {
+		bonuses = append(bonuses, loc.N("referral.bonus_downloads", int64(cfg.BonusDownloads)))
+	}
+	if cfg.BonusDays > 0 {
+		bonuses = append(bonuses, loc.T("referral.bonus_days", loc.N("duration.days", int64(cfg.BonusDays)), getPlanTitle(cfg.BonusPlan)))
+	}
+
+	if len(bonuses) == 0 {
+		return loc.T("referral.bonus_none")
+	}
+	return strings.Join(bonuses, loc.T("referral.bonus_and"))
+}
+
+func generateReferralCode() (string, error) {
+	code := make([]byte, referralCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(referralCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = referralCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,152 @@
+package tgbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Subscriptions ended or reminded per query, the rest follow in the same run
+const subscriptionBatchSize = 100
+
+// runSubscriptionScheduler ends due subscriptions and sends reminders every check interval until the context is done.
+// Every instance of the bot runs it, subscriptions are claimed with row locks so each one is handled once.
+func (b *DefaultBot) runSubscriptionScheduler(ctx context.Context) {
+	cfg := config.GetConfig().TelegramBot.Subscriptions
+
+	ticker := time.NewTicker(time.Duration(cfg.CheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		b.endDueSubscriptions(ctx)
+		if cfg.ReminderDays > 0 {
+			b.remindSubscriptions(ctx, cfg.ReminderDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// endDueSubscriptions expires subscriptions past their end and cancels those past their cancel_at, trials included.
+// Their accounts are moved to the free plan.
+func (b *DefaultBot) endDueSubscriptions(ctx context.Context) {
+	for {
+		subscriptions, err := b.storage.EndDueSubscriptions(ctx, subscriptionBatchSize)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to end due subscriptions: %v", err)
+			return
+		}
+
+		for _, subscription := range subscriptions {
+			b.downgradeSubscription(ctx, subscription)
+		}
+
+		if len(subscriptions) < subscriptionBatchSize {
+			return
+		}
+	}
+}
+
+// downgradeSubscription starts the free plan for the account of an ended subscription and tells the user.
+// If it fails the account still gets the free plan on its next download.
+func (b *DefaultBot) downgradeSubscription(ctx context.Context, ended sqlc.SubscriptionSubscription) {
+	logger.Log.Sugar().Infof("Subscription %s of account %d on plan %s is %s", ended.ID, ended.AccountID, ended.PlanID, ended.Status)
+
+	if err := b.startFreeSubscription(ctx, ended.AccountID); err != nil {
+		logger.Log.Sugar().Errorf("Failed to downgrade account %d to the free plan: %v", ended.AccountID, err)
+	}
+
+	if ended.PlanID == model.PlanFree.String() {
+		return
+	}
+
+	account, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+		ID: pgtype.Int8{Int64: ended.AccountID, Valid: true},
+	})
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account %d to notify: %v", ended.AccountID, err)
+		return
+	}
+
+	loc := b.getAccountLocalizer(ctx, account)
+	if ended.Status == sqlc.SubscriptionStatusesCANCELED {
+		b.notify(ctx, account.TelegramID, loc.T("subscription.canceled_notice", getPlanTitle(ended.PlanID)))
+	} else {
+		b.notify(ctx, account.TelegramID, loc.T("subscription.expired_notice", getPlanTitle(ended.PlanID)))
+	}
+}
+
+// startFreeSubscription starts the free plan for an account, unless it got another plan in the meantime
+func (b *DefaultBot) startFreeSubscription(ctx context.Context, accountID int64) error {
+	txStorage, err := b.storage.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer txStorage.Rollback(ctx)
+
+	current, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return err
+	}
+	if len(current) > 0 {
+		return nil
+	}
+
+	if _, err = txStorage.CreateSubscription(ctx, sqlc.CreateSubscriptionParams{
+		AccountID: accountID,
+		PlanID:    model.PlanFree.String(),
+		Status:    sqlc.SubscriptionStatusesACTIVE,
+		StartDate: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}); err != nil {
+		return err
+	}
+
+	return txStorage.Commit(ctx)
+}
+
+// remindSubscriptions tells users that their paid plan or trial ends within days
+func (b *DefaultBot) remindSubscriptions(ctx context.Context, days int) {
+	for {
+		subscriptions, err := b.storage.ClaimSubscriptionReminders(ctx, sqlc.ClaimSubscriptionRemindersParams{
+			Days:  int32(days),
+			Limit: subscriptionBatchSize,
+		})
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to claim subscription reminders: %v", err)
+			return
+		}
+
+		for _, subscription := range subscriptions {
+			account, err := b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+				ID: pgtype.Int8{Int64: subscription.AccountID, Valid: true},
+			})
+			if err != nil {
+				logger.Log.Sugar().Errorf("Failed to get account %d to remind: %v", subscription.AccountID, err)
+				continue
+			}
+
+			loc := b.getAccountLocalizer(ctx, account)
+			end := subscription.EndDate.Time
+
+			key := "subscription.reminder"
+			if subscription.Status == sqlc.SubscriptionStatusesTRIALING {
+				key = "subscription.trial_reminder"
+			}
+			b.notify(ctx, account.TelegramID, loc.T(key, getPlanTitle(subscription.PlanID), end.Format(time.DateOnly), formatDuration(loc, time.Until(end))))
+		}
+
+		if len(subscriptions) < subscriptionBatchSize {
+			return
+		}
+	}
+}
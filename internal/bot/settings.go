@@ -0,0 +1,269 @@
+package tgbot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Callback data of settings buttons is "settings:<key>:<value>"
+const settingsCallbackPrefix = "settings:"
+
+const (
+	settingKeyQuality  = "quality"
+	settingKeyMode     = "mode"
+	settingKeyCaption  = "caption"
+	settingKeyLanguage = "language"
+)
+
+// settingValueDefault resets a setting, quality is then asked per video (or the global config for photos)
+// and language follows the Telegram client
+const settingValueDefault = "default"
+
+// settingOptions are the values of every setting, titled by the message "settings.<key>.<value>"
+var settingOptions = map[string][]string{
+	settingKeyQuality:  {settingValueDefault, "high", "low"}, // Default picks from the renditions of each video
+	settingKeyMode:     {string(DownloadModeMedia), string(DownloadModeAudio), string(DownloadModeDocument)},
+	settingKeyCaption:  {"on", "off"},
+	settingKeyLanguage: append([]string{settingValueDefault}, getLanguageCodes()...),
+}
+
+// settingFeatures are the plan features needed to choose a setting value, keyed by "<key>:<value>"
+var settingFeatures = map[string]model.Feature{
+	settingKeyQuality + ":high":                      model.FeatureHighQuality,
+	settingKeyMode + ":" + string(DownloadModeAudio): model.FeatureExtractAudio,
+}
+
+// getOrCreateSettings returns the settings of an account, creating the defaults on first use
+func (b *DefaultBot) getOrCreateSettings(ctx context.Context, accountID int64) (sqlc.AccountSetting, error) {
+	settings, err := b.storage.GetAccountSetting(ctx, sqlc.GetAccountSettingParams{
+		AccountID: pgtype.Int8{Int64: accountID, Valid: true},
+	})
+	if err == nil {
+		return settings, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return sqlc.AccountSetting{}, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	// Concurrent first messages of the account all get the settings created by the first one
+	defaults := getDefaultSettings()
+	settings, err = b.storage.CreateAccountSetting(ctx, sqlc.CreateAccountSettingParams{
+		AccountID:    accountID,
+		DownloadMode: defaults.DownloadMode,
+		Caption:      defaults.Caption,
+	})
+	if err != nil {
+		return sqlc.AccountSetting{}, fmt.Errorf("failed to create settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// getDefaultSettings returns the settings used when there is no account, e.g. for channel posts
+func getDefaultSettings() sqlc.AccountSetting {
+	return sqlc.AccountSetting{
+		DownloadMode: string(DownloadModeMedia),
+		Caption:      true,
+	}
+}
+
+func (b *DefaultBot) handleSettings(ctx context.Context, account sqlc.AccountTelegram, loc *i18n.Localizer, update *models.Update, _ string) {
+	settings, err := b.getOrCreateSettings(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
+		b.reply(ctx, update, loc.T("settings.failed"))
+		return
+	}
+
+	if _, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        getSettingsText(loc, settings),
+		ReplyMarkup: getSettingsKeyboard(loc, settings),
+		ReplyParameters: &models.ReplyParameters{
+			MessageID: update.Message.ID,
+		},
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to send settings: %v", err)
+	}
+}
+
+// handleSettingsCallback applies the setting of a pressed button and refreshes the keyboard
+func (b *DefaultBot) handleSettingsCallback(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+
+	answer := func(text string) {
+		if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to answer callback query: %v", err)
+		}
+	}
+
+	account, err := b.getOrCreateAccount(ctx, &query.From)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get account for settings callback: %v", err)
+		answer(i18n.New(query.From.LanguageCode).T("callback.save_failed"))
+		return
+	}
+
+	settings, err := b.getOrCreateSettings(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", account.ID, err)
+		answer(i18n.New(account.LanguageCode).T("callback.save_failed"))
+		return
+	}
+
+	key, value, ok := strings.Cut(strings.TrimPrefix(query.Data, settingsCallbackPrefix), ":")
+	if !ok || !slices.Contains(settingOptions[key], value) {
+		answer(getLocalizer(account, settings).T("callback.unknown_setting"))
+		return
+	}
+
+	if feature, ok := settingFeatures[key+":"+value]; ok {
+		features, err := b.GetPlanFeatures(ctx, account.ID)
+		if err != nil {
+			logger.Log.Sugar().Errorf("Failed to get plan features of account %d: %v", account.ID, err)
+			answer(getLocalizer(account, settings).T("callback.save_failed"))
+			return
+		}
+		if err = features.Require(feature); err != nil {
+			answer(getAllowErrorText(getLocalizer(account, settings), err, false))
+			return
+		}
+	}
+
+	settings, err = b.storage.UpdateAccountSetting(ctx, getSettingUpdateParams(settings.ID, key, value))
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to update setting %s of account %d: %v", key, account.ID, err)
+		answer(getLocalizer(account, settings).T("callback.save_failed"))
+		return
+	}
+
+	// A changed language applies to this answer already
+	loc := getLocalizer(account, settings)
+	answer(loc.T("callback.saved"))
+
+	// The message can be too old to edit, the setting is saved anyway
+	if query.Message.Message == nil {
+		return
+	}
+
+	if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      query.Message.Message.Chat.ID,
+		MessageID:   query.Message.Message.ID,
+		Text:        getSettingsText(loc, settings),
+		ReplyMarkup: getSettingsKeyboard(loc, settings),
+	}); err != nil {
+		logger.Log.Sugar().Errorf("Failed to edit settings message: %v", err)
+	}
+}
+
+func getSettingUpdateParams(id int64, key, value string) sqlc.UpdateAccountSettingParams {
+	params := sqlc.UpdateAccountSettingParams{ID: id}
+
+	switch key {
+	case settingKeyQuality:
+		if value == settingValueDefault {
+			params.NullQuality = true
+		} else {
+			params.Quality = pgtype.Text{String: value, Valid: true}
+		}
+	case settingKeyMode:
+		params.DownloadMode = pgtype.Text{String: value, Valid: true}
+	case settingKeyCaption:
+		params.Caption = pgtype.Bool{Bool: value == "on", Valid: true}
+	case settingKeyLanguage:
+		if value == settingValueDefault {
+			params.NullLanguageCode = true
+		} else {
+			params.LanguageCode = pgtype.Text{String: value, Valid: true}
+		}
+	}
+
+	return params
+}
+
+// getSettingValue returns the option value a setting currently has
+func getSettingValue(settings sqlc.AccountSetting, key string) string {
+	switch key {
+	case settingKeyQuality:
+		if settings.Quality.Valid {
+			return settings.Quality.String
+		}
+	case settingKeyMode:
+		return settings.DownloadMode
+	case settingKeyCaption:
+		if settings.Caption {
+			return "on"
+		}
+		return "off"
+	case settingKeyLanguage:
+		if settings.LanguageCode.Valid {
+			return settings.LanguageCode.String
+		}
+	}
+
+	return settingValueDefault
+}
+
+func getSettingsText(loc *i18n.Localizer, settings sqlc.AccountSetting) string {
+	return loc.T("settings.text",
+		getOptionTitle(loc, "settings."+settingKeyQuality, getSettingValue(settings, settingKeyQuality)),
+		getOptionTitle(loc, "settings."+settingKeyMode, getSettingValue(settings, settingKeyMode)),
+		getOptionTitle(loc, "settings."+settingKeyCaption, getSettingValue(settings, settingKeyCaption)),
+		getOptionTitle(loc, "settings."+settingKeyLanguage, getSettingValue(settings, settingKeyLanguage)),
+	)
+}
+
+// getSettingsKeyboard returns one row of options per setting, the current value is marked
+func getSettingsKeyboard(loc *i18n.Localizer, settings sqlc.AccountSetting) *models.InlineKeyboardMarkup {
+	var rows [][]models.InlineKeyboardButton
+	for _, key := range []string{settingKeyQuality, settingKeyMode, settingKeyCaption, settingKeyLanguage} {
+		current := getSettingValue(settings, key)
+
+		var row []models.InlineKeyboardButton
+		for _, value := range settingOptions[key] {
+			title := getOptionTitle(loc, "settings."+key, value)
+			if value == current {
+				title = "✅ " + title
+			}
+
+			row = append(row, models.InlineKeyboardButton{
+				Text:         title,
+				CallbackData: settingsCallbackPrefix + key + ":" + value,
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// getOptionTitle returns the title of an option, the message "<prefix>.<value>"
+func getOptionTitle(loc *i18n.Localizer, prefix, value string) string {
+	return loc.T(prefix + "." + value)
+}
+
+// getLanguageCodes returns the codes of the languages users can choose
+func getLanguageCodes() []string {
+	var codes []string
+	for _, language := range i18n.Languages() {
+		codes = append(codes, string(language))
+	}
+	return codes
+}
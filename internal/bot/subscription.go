@@ -2,62 +2,78 @@ package tgbot
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/codeonbeans/botfetchr/config"
 	"github.com/codeonbeans/botfetchr/generated/sqlc"
 	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+	"github.com/codeonbeans/botfetchr/internal/utils/download"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 var (
-	ErrSubscriptionExpired  = errors.New("subscription has expired")
-	ErrFeatureLimitExceeded = errors.New("feature limit exceeded")
+	ErrFeatureLimitExceeded = model.NewError(model.ErrorKindLimitExceeded, errors.New("feature limit exceeded"))
+	ErrFeatureNotAvailable  = errors.New("feature is not available")
+
+	errPlanNeverEnds = errors.New("active plan never ends")
+	errPlanPaid      = errors.New("active plan is paid for another plan")
 )
 
-func (b *DefaultBot) IsAccountAllow(ctx context.Context, accountID int64, feature model.Feature, pendingUsage int64) error {
-	b.subscriptionMux.Lock() // Ensure that only one goroutine can access the subscription logic at a time
-	defer b.subscriptionMux.Unlock()
+// IsAccountAllow checks that the plan of an account has the feature and quota left for the pending usage,
+// and records the usage in the ledger. Usage is summed from the events of the current period in the meter of the feature.
+func (b *DefaultBot) IsAccountAllow(ctx context.Context, accountID int64, feature model.Feature, requestID int64, pending model.Usage) error {
+	return reserveAccountUsage(ctx, b.storage, accountID, feature, requestID, pending)
+}
 
-	txStorage, err := b.storage.BeginTx(ctx)
+// reserveAccountUsage is IsAccountAllow on a storage, so several connection pools can race it like replicas do.
+//
+// The account row is locked for the transaction, so concurrent reservations of the account wait for each other
+// in the database, also across replicas, while other accounts are not blocked.
+func reserveAccountUsage(ctx context.Context, store *storage.Storage, accountID int64, feature model.Feature, requestID int64, pending model.Usage) error {
+	txStorage, err := store.BeginTx(ctx)
 	if err != nil {
 		return err
 	}
+	defer txStorage.Rollback(ctx)
+
+	// Step 1: Lock the account, reservations of any feature may create its free subscription below
+	if _, err = txStorage.LockAccountTelegram(ctx, accountID); err != nil {
+		return fmt.Errorf("failed to lock account: %w", err)
+	}
 
-	// Step 1: Check if the user has an active subscription
-	subscriptions, err := txStorage.ListSubscriptions(ctx, sqlc.ListSubscriptionsParams{
-		AccountID: pgtype.Int8{Int64: accountID, Valid: true},
-		Status:    sqlc.NullSubscriptionStatuses{SubscriptionStatuses: sqlc.SubscriptionStatusesACTIVE, Valid: true},
-		Limit:     1,
+	// The usage of the feature is created on first use
+	usage, err := txStorage.LockAccountUsage(ctx, sqlc.LockAccountUsageParams{
+		AccountID: accountID,
+		Feature:   feature.String(),
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to lock usage: %w", err)
 	}
 
-	if len(subscriptions) == 0 {
-		subscription, err := txStorage.CreateSubscription(ctx, sqlc.CreateSubscriptionParams{
+	// Step 2: Check if the user has an active or trialing subscription, the account lock keeps a second free one from being created
+	subscription, err := txStorage.GetCurrentSubscription(ctx, accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		subscription, err = txStorage.CreateSubscription(ctx, sqlc.CreateSubscriptionParams{
 			AccountID: accountID,
 			PlanID:    model.PlanFree.String(),
 			StartDate: pgtype.Timestamptz{Time: time.Now(), Valid: true},
 			Status:    sqlc.SubscriptionStatusesACTIVE,
 		})
-		if err != nil {
-			return err
-		}
-
-		subscriptions = append(subscriptions, subscription)
 	}
-
-	subscription := subscriptions[0]
-	if subscription.EndDate.Valid && subscription.EndDate.Time.Before(time.Now()) {
-		// Subscription has expired
-		return ErrSubscriptionExpired
+	if err != nil {
+		return err
 	}
 
-	// Step 2: Check if user has access to the feature and not exceeded the limit
+	// A subscription that ended is on the free plan until the scheduler expires it
+	planID := getCurrentPlanID(subscription)
+
+	// Step 3: Check if user has access to the feature and not exceeded the limit
 	planFeatures, err := txStorage.ListPlanFeatures(ctx, sqlc.ListPlanFeaturesParams{
-		PlanID:  pgtype.Text{String: subscription.PlanID, Valid: true},
+		PlanID:  pgtype.Text{String: planID, Valid: true},
 		Feature: pgtype.Text{String: feature.String(), Valid: true},
 		Limit:   1,
 	})
@@ -65,55 +81,320 @@ func (b *DefaultBot) IsAccountAllow(ctx context.Context, accountID int64, featur
 		return err
 	}
 	if len(planFeatures) == 0 {
-		return fmt.Errorf("feature %s is not available in plan %s", feature.String(), subscription.PlanID)
+		return fmt.Errorf("%w: %s in plan %s", ErrFeatureNotAvailable, feature.String(), planID)
 	}
 	planFeature := planFeatures[0]
 
-	usages, err := txStorage.ListAccountUsages(ctx, sqlc.ListAccountUsagesParams{
-		AccountID: pgtype.Int8{Int64: accountID, Valid: true},
-		Feature:   pgtype.Text{String: feature.String(), Valid: true},
-		Limit:     1,
-	})
+	periodUsage, err := getPeriodUsage(ctx, txStorage.Queries, usage)
 	if err != nil {
 		return err
 	}
-	if len(usages) == 0 {
-		usage, err := txStorage.CreateAccountUsage(ctx, sqlc.CreateAccountUsageParams{
-			AccountID: accountID,
-			Feature:   feature.String(),
-			Usage:     0,
-		})
-		if err != nil {
-			return err
-		}
-
-		usages = append(usages, usage)
-	}
-	usage := usages[0]
+	used := getMeteredUsage(planFeature.Meter, periodUsage)
 
-	// reset usage if it meet the reset condition (days_to_reset)
+	// start a new period if it meet the reset condition (days_to_reset), events before it don't count anymore.
+	// The period starts at the database time of the transaction, so the event recorded below is part of it.
 	if planFeature.DaysToReset > 0 && usage.ResetAt.Time.AddDate(0, 0, int(planFeature.DaysToReset)).Before(time.Now()) {
-		usage, err = txStorage.UpdateAccountUsage(ctx, sqlc.UpdateAccountUsageParams{
-			ID:      usage.ID,
-			Usage:   pgtype.Int8{Int64: 0, Valid: true},
-			ResetAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		usage, err = txStorage.StartAccountUsagePeriod(ctx, sqlc.StartAccountUsagePeriodParams{
+			ID:    usage.ID,
+			Bonus: getBonusLeft(usage.Bonus, used, planFeature.Limit),
 		})
 		if err != nil {
 			return err
 		}
+		used = 0
 	}
 
-	if usage.Usage+pendingUsage > planFeature.Limit && planFeature.Limit > 0 {
+	// Bonus usage, e.g. from referrals, is allowed on top of the limit.
+	// Items and bytes are only known once the media is delivered, a download is allowed while any quota is left.
+	allowance := planFeature.Limit + usage.Bonus
+	if planFeature.Limit > 0 && (used >= allowance || used+getMeteredUsage(planFeature.Meter, pending) > allowance) {
 		return ErrFeatureLimitExceeded
 	}
 
-	// Step 3: Update usage
-	if _, err = txStorage.UpdateAccountUsage(ctx, sqlc.UpdateAccountUsageParams{
-		ID:    usage.ID,
-		Usage: pgtype.Int8{Int64: usage.Usage + pendingUsage, Valid: true},
-	}); err != nil {
+	// Step 4: Record usage, the lock is released on commit
+	if _, err = txStorage.CreateUsageEvent(ctx, newUsageEventParams(accountID, feature, requestID, pending)); err != nil {
 		return err
 	}
 
 	return txStorage.Commit(ctx)
 }
+
+// RecordAccountUsage adds usage to the ledger without checking the limit, e.g. the items and bytes of delivered media
+func (b *DefaultBot) RecordAccountUsage(ctx context.Context, accountID int64, feature model.Feature, requestID int64, usage model.Usage) error {
+	_, err := b.storage.CreateUsageEvent(ctx, newUsageEventParams(accountID, feature, requestID, usage))
+	return err
+}
+
+// ReleaseAccountUsage gives back usage reserved with IsAccountAllow for a download that was not delivered.
+// The reservation stays in the ledger, it is offset by an event with negative amounts.
+func (b *DefaultBot) ReleaseAccountUsage(ctx context.Context, accountID int64, feature model.Feature, requestID int64, usage model.Usage) error {
+	return b.RecordAccountUsage(ctx, accountID, feature, requestID, model.Usage{
+		Requests: -usage.Requests,
+		Items:    -usage.Items,
+		Bytes:    -usage.Bytes,
+	})
+}
+
+func newUsageEventParams(accountID int64, feature model.Feature, requestID int64, usage model.Usage) sqlc.CreateUsageEventParams {
+	return sqlc.CreateUsageEventParams{
+		AccountID: accountID,
+		Feature:   feature.String(),
+		Amount:    usage.Requests,
+		Items:     usage.Items,
+		Bytes:     usage.Bytes,
+		RequestID: pgtype.Int8{Int64: requestID, Valid: requestID != 0},
+	}
+}
+
+// getPeriodUsage sums the usage events of a feature since the start of the current period of its usage row
+func getPeriodUsage(ctx context.Context, queries *sqlc.Queries, usage sqlc.AccountUsage) (model.Usage, error) {
+	sum, err := queries.SumUsageEvents(ctx, sqlc.SumUsageEventsParams{
+		AccountID: usage.AccountID,
+		Feature:   usage.Feature,
+		Since:     usage.ResetAt,
+	})
+	if err != nil {
+		return model.Usage{}, err
+	}
+
+	return model.Usage{Requests: sum.Amount, Items: sum.Items, Bytes: sum.Bytes}, nil
+}
+
+// formatMeteredUsage formats an amount in the unit of a meter, e.g. "1.5 GiB" for bytes
+func formatMeteredUsage(meter sqlc.SubscriptionMeters, amount int64) string {
+	if meter == sqlc.SubscriptionMetersBYTES {
+		return download.ByteCountBinary(amount)
+	}
+	return fmt.Sprint(amount)
+}
+
+// getMeteredUsage returns the part of a usage counted against the limit of a plan feature with the meter
+func getMeteredUsage(meter sqlc.SubscriptionMeters, usage model.Usage) int64 {
+	switch meter {
+	case sqlc.SubscriptionMetersITEMS:
+		return usage.Items
+	case sqlc.SubscriptionMetersBYTES:
+		return usage.Bytes
+	default:
+		return usage.Requests
+	}
+}
+
+// GetActiveSubscription returns the active or trialing subscription of an account.
+// Accounts without one are on the free plan, which is returned without being persisted.
+func (b *DefaultBot) GetActiveSubscription(ctx context.Context, accountID int64) (sqlc.SubscriptionSubscription, error) {
+	subscription, err := b.storage.GetCurrentSubscription(ctx, accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sqlc.SubscriptionSubscription{
+			AccountID: accountID,
+			PlanID:    model.PlanFree.String(),
+			Status:    sqlc.SubscriptionStatusesACTIVE,
+		}, nil
+	}
+
+	return subscription, err
+}
+
+// PlanFeatures are the features of a plan by name
+type PlanFeatures map[string]sqlc.SubscriptionPlanFeature
+
+// Has reports whether the plan includes a feature
+func (f PlanFeatures) Has(feature model.Feature) bool {
+	_, ok := f[feature.String()]
+	return ok
+}
+
+// Require returns a FeatureLockedError if the plan does not include a feature
+func (f PlanFeatures) Require(feature model.Feature) error {
+	if !f.Has(feature) {
+		return &FeatureLockedError{Feature: feature}
+	}
+	return nil
+}
+
+// FeatureLockedError is returned for a gated feature the plan of an account does not include, paid plans unlock it
+type FeatureLockedError struct {
+	Feature model.Feature
+}
+
+func (e *FeatureLockedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrFeatureNotAvailable, e.Feature)
+}
+
+func (e *FeatureLockedError) Unwrap() error {
+	return ErrFeatureNotAvailable
+}
+
+// GetPlanFeatures returns the features of the current plan of an account, those of the free plan without an account
+func (b *DefaultBot) GetPlanFeatures(ctx context.Context, accountID int64) (PlanFeatures, error) {
+	subscription, err := b.GetActiveSubscription(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	planFeatures, err := b.storage.ListPlanFeatures(ctx, sqlc.ListPlanFeaturesParams{
+		PlanID: pgtype.Text{String: getCurrentPlanID(subscription), Valid: true},
+		Limit:  100,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	features := make(PlanFeatures, len(planFeatures))
+	for _, planFeature := range planFeatures {
+		features[planFeature.Feature] = planFeature
+	}
+	return features, nil
+}
+
+// getCurrentPlanID returns the plan of a subscription, or the free plan once it ended or was canceled
+func getCurrentPlanID(subscription sqlc.SubscriptionSubscription) string {
+	now := time.Now()
+	if (subscription.EndDate.Valid && !subscription.EndDate.Time.After(now)) ||
+		(subscription.CancelAt.Valid && !subscription.CancelAt.Time.After(now)) {
+		return model.PlanFree.String()
+	}
+	return subscription.PlanID
+}
+
+// getBonusLeft returns the bonus left at the end of a period, usage beyond the limit of the plan used it up
+func getBonusLeft(bonus, used, limit int64) int64 {
+	if limit <= 0 {
+		return bonus
+	}
+	return max(bonus-max(used-limit, 0), 0)
+}
+
+// lockSubscriptions locks an account and its current subscriptions until the end of the transaction.
+// Locking subscriptions alone locks nothing for an account without any, the account lock keeps concurrent
+// transactions from both creating one. Transactions that create or replace subscriptions take it first.
+func lockSubscriptions(ctx context.Context, txStorage *storage.TxStorage, accountID int64) ([]sqlc.SubscriptionSubscription, error) {
+	if _, err := txStorage.LockAccountTelegram(ctx, accountID); err != nil {
+		return nil, fmt.Errorf("failed to lock account: %w", err)
+	}
+
+	subscriptions, err := txStorage.LockCurrentSubscriptions(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// startSubscription cancels the active subscriptions of an account and starts one on the plan, without end if end is not valid.
+// The subscriptions are locked with lockSubscriptions before.
+func startSubscription(ctx context.Context, txStorage *storage.TxStorage, accountID int64, planID string, end pgtype.Timestamptz) (sqlc.SubscriptionSubscription, error) {
+	if _, err := txStorage.CancelActiveSubscriptions(ctx, accountID); err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to cancel subscriptions: %w", err)
+	}
+
+	subscription, err := txStorage.CreateSubscription(ctx, sqlc.CreateSubscriptionParams{
+		AccountID: accountID,
+		PlanID:    planID,
+		Status:    sqlc.SubscriptionStatusesACTIVE,
+		StartDate: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndDate:   end,
+	})
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// extendSubscription gives an account a plan until getEnd returns, locking the account and its current subscriptions
+// so concurrent payments, redemptions and reservations of the account wait for each other.
+// Getting the current plan again extends it from its end, keeping the time left, or from now if it ended.
+// A trial becomes active and a scheduled cancellation is dropped. Any other plan replaces the current one.
+// The time the plan was extended from is returned, the start of the subscription if a new one was started.
+// errPlanNeverEnds is returned if the current plan is a paid plan without end.
+func extendSubscription(ctx context.Context, txStorage *storage.TxStorage, accountID int64, planID string, getEnd func(from time.Time) pgtype.Timestamptz) (sqlc.SubscriptionSubscription, pgtype.Timestamptz, error) {
+	subscriptions, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, pgtype.Timestamptz{}, err
+	}
+
+	if len(subscriptions) > 0 && isNeverEnding(subscriptions[0]) {
+		return sqlc.SubscriptionSubscription{}, pgtype.Timestamptz{}, errPlanNeverEnds
+	}
+	if len(subscriptions) == 0 || subscriptions[0].PlanID != planID {
+		subscription, err := startSubscription(ctx, txStorage, accountID, planID, getEnd(time.Now()))
+		return subscription, subscription.StartDate, err
+	}
+
+	current := subscriptions[0]
+
+	from := time.Now()
+	if current.EndDate.Time.After(from) {
+		from = current.EndDate.Time
+	}
+
+	end := getEnd(from)
+	subscription, err := txStorage.UpdateSubscription(ctx, sqlc.UpdateSubscriptionParams{
+		ID:           current.ID,
+		Status:       sqlc.NullSubscriptionStatuses{SubscriptionStatuses: sqlc.SubscriptionStatusesACTIVE, Valid: true},
+		EndDate:      end,
+		NullEndDate:  !end.Valid,
+		NullCancelAt: true,
+	})
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, pgtype.Timestamptz{}, fmt.Errorf("failed to extend subscription: %w", err)
+	}
+
+	return subscription, pgtype.Timestamptz{Time: from, Valid: true}, nil
+}
+
+// grantSubscription is extendSubscription for plans that are given away, e.g. by promo codes.
+// A paid plan with time left is never replaced by another one, errPlanPaid is returned instead.
+func grantSubscription(ctx context.Context, txStorage *storage.TxStorage, accountID int64, planID string, getEnd func(from time.Time) pgtype.Timestamptz) (sqlc.SubscriptionSubscription, error) {
+	subscriptions, err := lockSubscriptions(ctx, txStorage, accountID)
+	if err != nil {
+		return sqlc.SubscriptionSubscription{}, err
+	}
+
+	if len(subscriptions) > 0 && subscriptions[0].PlanID != planID && isPaid(subscriptions[0]) {
+		if isNeverEnding(subscriptions[0]) {
+			return sqlc.SubscriptionSubscription{}, errPlanNeverEnds
+		}
+		return sqlc.SubscriptionSubscription{}, errPlanPaid
+	}
+
+	subscription, _, err := extendSubscription(ctx, txStorage, accountID, planID, getEnd)
+	return subscription, err
+}
+
+// isPaid reports whether a subscription is an active paid plan that has not lapsed, trials are not paid for
+func isPaid(subscription sqlc.SubscriptionSubscription) bool {
+	return subscription.Status == sqlc.SubscriptionStatusesACTIVE && getCurrentPlanID(subscription) != model.PlanFree.String()
+}
+
+// IsChatAllow counts downloads in a group or channel against the quota of the chat, separate from personal limits
+func (b *DefaultBot) IsChatAllow(ctx context.Context, chat sqlc.AccountChat, pendingUsage int64) error {
+	groupConfig := config.GetConfig().TelegramBot.Group
+
+	// Usage of a period that started before resetBefore is reset, never if the period has no length
+	resetBefore := pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+	if groupConfig.DaysToReset > 0 {
+		resetBefore = pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, -groupConfig.DaysToReset), Valid: true}
+	}
+
+	_, err := b.storage.IncreaseAccountChatUsage(ctx, sqlc.IncreaseAccountChatUsageParams{
+		ID:          chat.ID,
+		Amount:      pendingUsage,
+		Limit:       groupConfig.Limit,
+		ResetBefore: resetBefore,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrFeatureLimitExceeded
+	}
+
+	return err
+}
+
+// ReleaseChatUsage gives back usage reserved with IsChatAllow for a download that was not delivered
+func (b *DefaultBot) ReleaseChatUsage(ctx context.Context, chat sqlc.AccountChat, usage int64) error {
+	_, err := b.storage.ReleaseAccountChatUsage(ctx, sqlc.ReleaseAccountChatUsageParams{
+		Amount: usage,
+		ID:     chat.ID,
+	})
+	return err
+}
@@ -0,0 +1,260 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Bot replicas share the database only, each one is a connection pool of its own
+const testReplicas = 3
+
+func newTestStores(t *testing.T) []*storage.Storage {
+	t.Helper()
+
+	stores := make([]*storage.Storage, testReplicas)
+	for i := range stores {
+		stores[i] = newTestStorage(t)
+	}
+	return stores
+}
+
+// newTestPlanFeature gives a plan downloads up to limit (0 is unlimited), reset every daysToReset days or never with 0
+func newTestPlanFeature(t *testing.T, store *storage.Storage, planID string, limit int64, daysToReset int32) {
+	t.Helper()
+
+	if _, err := store.CreatePlanFeature(context.Background(), sqlc.CreatePlanFeatureParams{
+		PlanID:      planID,
+		Feature:     model.FeatureGetMedia.String(),
+		Limit:       limit,
+		DaysToReset: daysToReset,
+		Meter:       sqlc.SubscriptionMetersREQUESTS,
+	}); err != nil {
+		t.Fatalf("failed to create plan feature: %v", err)
+	}
+}
+
+// raceReservations starts all reservations at once, spread over the stores, and returns how many were allowed
+func raceReservations(t *testing.T, stores []*storage.Storage, accountID int64, features []model.Feature, requests int) int64 {
+	t.Helper()
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			feature := features[i%len(features)]
+			err := reserveAccountUsage(context.Background(), stores[i%len(stores)], accountID, feature, 0, model.Usage{Requests: 1})
+			switch {
+			case err == nil:
+				allowed.Add(1)
+			case errors.Is(err, ErrFeatureLimitExceeded), errors.Is(err, ErrFeatureNotAvailable):
+			default:
+				t.Errorf("reservation %d of %s failed: %v", i, feature, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	return allowed.Load()
+}
+
+func TestReserveAccountUsageLimitHolds(t *testing.T) {
+	const limit, requests = 5, 50
+
+	stores := newTestStores(t)
+	account := newTestAccount(t, stores[0])
+	planID := newTestPlan(t, stores[0])
+	newTestPlanFeature(t, stores[0], planID, limit, 0)
+	newTestSubscription(t, stores[0], account.ID, planID)
+
+	if allowed := raceReservations(t, stores, account.ID, []model.Feature{model.FeatureGetMedia}, requests); allowed != limit {
+		t.Errorf("%d of %d reservations were allowed, want %d", allowed, requests, limit)
+	}
+
+	usage, err := stores[0].SumUsageEvents(context.Background(), sqlc.SumUsageEventsParams{
+		AccountID: account.ID,
+		Feature:   model.FeatureGetMedia.String(),
+		Since:     pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to sum usage events: %v", err)
+	}
+	if usage.Amount != limit {
+		t.Errorf("ledger sums up to %d requests, want %d", usage.Amount, limit)
+	}
+}
+
+func TestReserveAccountUsageCreatesOneFreeSubscription(t *testing.T) {
+	const requests = 20
+
+	stores := newTestStores(t)
+	account := newTestAccount(t, stores[0])
+
+	// Reservations of different features race to create the free subscription of a new account
+	raceReservations(t, stores, account.ID, []model.Feature{model.FeatureGetMedia, model.FeatureInlineMode}, requests)
+
+	count, err := stores[0].CountSubscriptions(context.Background(), sqlc.CountSubscriptionsParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to count subscriptions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("account has %d subscriptions, want 1", count)
+	}
+}
+
+func TestIsAccountAllowCountsFirstRequestOfPeriod(t *testing.T) {
+	const limit = 2
+
+	store := newTestStorage(t)
+	b := &DefaultBot{storage: store}
+	account := newTestAccount(t, store)
+	planID := newTestPlan(t, store)
+	newTestPlanFeature(t, store, planID, limit, 1)
+	newTestSubscription(t, store, account.ID, planID)
+
+	ctx := context.Background()
+	reserve := func() error {
+		return b.IsAccountAllow(ctx, account.ID, model.FeatureGetMedia, 0, model.Usage{Requests: 1})
+	}
+
+	for i := 0; i < limit; i++ {
+		if err := reserve(); err != nil {
+			t.Fatalf("reservation %d failed: %v", i, err)
+		}
+	}
+
+	// Move the period back so the next reservation starts a new one
+	usages, err := store.ListAccountUsages(ctx, sqlc.ListAccountUsagesParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+		Feature:   pgtype.Text{String: model.FeatureGetMedia.String(), Valid: true},
+		Limit:     1,
+	})
+	if err != nil || len(usages) == 0 {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+	if _, err = store.UpdateAccountUsage(ctx, sqlc.UpdateAccountUsageParams{
+		ID:      usages[0].ID,
+		ResetAt: pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, -2), Valid: true},
+	}); err != nil {
+		t.Fatalf("failed to move period back: %v", err)
+	}
+
+	var allowed int
+	for i := 0; i < limit+1; i++ {
+		switch err := reserve(); {
+		case err == nil:
+			allowed++
+		case !errors.Is(err, ErrFeatureLimitExceeded):
+			t.Fatalf("reservation %d of the new period failed: %v", i, err)
+		}
+	}
+	if allowed != limit {
+		t.Errorf("%d reservations of the new period were allowed, want %d", allowed, limit)
+	}
+
+	usage, err := store.GetAccountUsage(ctx, usages[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+	sum, err := store.SumUsageEvents(ctx, sqlc.SumUsageEventsParams{
+		AccountID: account.ID,
+		Feature:   model.FeatureGetMedia.String(),
+		Since:     usage.ResetAt,
+	})
+	if err != nil {
+		t.Fatalf("failed to sum usage events: %v", err)
+	}
+	if sum.Amount != limit {
+		t.Errorf("period sums up to %d requests, want %d", sum.Amount, limit)
+	}
+}
+
+func TestExtendSubscriptionRacingReservationsKeepsOneActive(t *testing.T) {
+	const reservations, extensions = 20, 5
+
+	stores := newTestStores(t)
+	account := newTestAccount(t, stores[0])
+	planID := newTestPlan(t, stores[0])
+	newTestPlanFeature(t, stores[0], planID, 0, 0)
+
+	// Payments extend the plan of a new account while its first downloads create the free plan
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < reservations+extensions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			store := stores[i%len(stores)]
+			if i >= reservations {
+				if err := extendTestSubscription(store, account.ID, planID); err != nil {
+					t.Errorf("extension %d failed: %v", i-reservations, err)
+				}
+				return
+			}
+
+			err := reserveAccountUsage(context.Background(), store, account.ID, model.FeatureGetMedia, 0, model.Usage{Requests: 1})
+			if err != nil && !errors.Is(err, ErrFeatureLimitExceeded) && !errors.Is(err, ErrFeatureNotAvailable) {
+				t.Errorf("reservation %d failed: %v", i, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	ctx := context.Background()
+	count, err := stores[0].CountSubscriptions(ctx, sqlc.CountSubscriptionsParams{
+		AccountID: pgtype.Int8{Int64: account.ID, Valid: true},
+		Status:    sqlc.NullSubscriptionStatuses{SubscriptionStatuses: sqlc.SubscriptionStatusesACTIVE, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to count subscriptions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("account has %d active subscriptions, want 1", count)
+	}
+
+	subscription, err := stores[0].GetCurrentSubscription(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	if subscription.PlanID != planID {
+		t.Errorf("account is on plan %s, want %s", subscription.PlanID, planID)
+	}
+}
+
+// extendTestSubscription adds a day of a plan like a payment does
+func extendTestSubscription(store *storage.Storage, accountID int64, planID string) error {
+	ctx := context.Background()
+	txStorage, err := store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer txStorage.Rollback(ctx)
+
+	if _, _, err = extendSubscription(ctx, txStorage, accountID, planID, func(from time.Time) pgtype.Timestamptz {
+		return pgtype.Timestamptz{Time: from.AddDate(0, 0, 1), Valid: true}
+	}); err != nil {
+		return err
+	}
+
+	return txStorage.Commit(ctx)
+}
@@ -7,3 +7,37 @@ const (
 	SaverTypeInstagram SaverType = "instagram"
 	SaverTypeVK        SaverType = "vk"
 )
+
+type DownloadMode string
+
+const (
+	DownloadModeMedia    DownloadMode = "media"
+	DownloadModeAudio    DownloadMode = "audio"
+	DownloadModeDocument DownloadMode = "document" // Send uncompressed originals as files
+)
+
+// TriggerMode decides which messages in a group or channel the bot downloads links from
+type TriggerMode string
+
+const (
+	TriggerModeAuto    TriggerMode = "auto"    // Every message with a link
+	TriggerModeMention TriggerMode = "mention" // Messages mentioning the bot or replying to it
+	TriggerModeCommand TriggerMode = "command" // Only /dl
+)
+
+// Title returns the human readable name of the platform a saver downloads from
+func (s SaverType) Title() string {
+	switch s {
+	case SaverTypeInstagram:
+		return "Instagram"
+	case SaverTypeVK:
+		return "VK"
+	default:
+		return string(s)
+	}
+}
+
+// Description returns the message key of the kind of links a saver accepts, shown to users in /start
+func (s SaverType) Description() string {
+	return "platform." + string(s)
+}
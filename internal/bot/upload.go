@@ -0,0 +1,155 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/codeonbeans/botfetchr/config"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const defaultAPIServerURL = "https://api.telegram.org"
+
+// apiResponse is the envelope of every Bot API response
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Description string          `json:"description,omitempty"`
+	ErrorCode   int             `json:"error_code,omitempty"`
+}
+
+// attachment is a file uploaded alongside a request and referenced as attach://<Name>
+type attachment struct {
+	Name string
+	Path string
+}
+
+// sendMediaGroupWithAttachments sends a media group whose items reference extra uploaded files (e.g. thumbnails).
+//
+// go-telegram/bot only uploads the main file of each InputMedia, so attach://<name> references used by thumbnails
+// would point to nothing. The multipart body is built here instead and streamed to avoid buffering whole videos.
+func (b *DefaultBot) sendMediaGroupWithAttachments(ctx context.Context, params *bot.SendMediaGroupParams, attachments []attachment) ([]*models.Message, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeMediaGroupForm(form, params, attachments))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getAPIMethodURL("sendMediaGroup"), pr)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("failed to create sendMediaGroup request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("failed to send sendMediaGroup request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err = json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode sendMediaGroup response: %w", err)
+	}
+
+	if !apiResp.OK {
+		return nil, fmt.Errorf("sendMediaGroup failed: %d %s", apiResp.ErrorCode, apiResp.Description)
+	}
+
+	var messages []*models.Message
+	if err = json.Unmarshal(apiResp.Result, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode sendMediaGroup result: %w", err)
+	}
+
+	return messages, nil
+}
+
+func writeMediaGroupForm(form *multipart.Writer, params *bot.SendMediaGroupParams, attachments []attachment) error {
+	if err := form.WriteField("chat_id", fmt.Sprint(params.ChatID)); err != nil {
+		return err
+	}
+
+	if params.ReplyParameters != nil {
+		replyParameters, err := json.Marshal(params.ReplyParameters)
+		if err != nil {
+			return err
+		}
+		if err = form.WriteField("reply_parameters", string(replyParameters)); err != nil {
+			return err
+		}
+	}
+
+	var items []string
+	for _, media := range params.Media {
+		item, err := media.MarshalInputMedia()
+		if err != nil {
+			return fmt.Errorf("failed to marshal input media: %w", err)
+		}
+		items = append(items, string(item))
+
+		if !strings.HasPrefix(media.GetMedia(), "attach://") {
+			continue
+		}
+
+		name := strings.TrimPrefix(media.GetMedia(), "attach://")
+		w, err := form.CreateFormFile(name, name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, media.Attachment()); err != nil {
+			return fmt.Errorf("failed to write attachment %s: %w", name, err)
+		}
+	}
+
+	if err := form.WriteField("media", "["+strings.Join(items, ",")+"]"); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		if err := writeFileField(form, a); err != nil {
+			return err
+		}
+	}
+
+	return form.Close()
+}
+
+func writeFileField(form *multipart.Writer, a attachment) error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open attachment %s: %w", a.Path, err)
+	}
+	defer f.Close()
+
+	w, err := form.CreateFormFile(a.Name, a.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write attachment %s: %w", a.Name, err)
+	}
+
+	return nil
+}
+
+// getAPIMethodURL returns the url of a Bot API method on the configured server
+func getAPIMethodURL(method string) string {
+	serverURL := defaultAPIServerURL
+	if config.GetConfig().TelegramBot.APIServer.Enabled {
+		serverURL = strings.TrimSuffix(config.GetConfig().TelegramBot.APIServer.Url, "/")
+	}
+
+	return fmt.Sprintf("%s/bot%s/%s", serverURL, config.GetConfig().TelegramBot.Token, method)
+}
@@ -0,0 +1,323 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/generated/sqlc"
+	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
+	"github.com/codeonbeans/botfetchr/internal/i18n"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"github.com/codeonbeans/botfetchr/internal/utils/download"
+
+	"github.com/eko/gocache/lib/v4/store"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Callback data of variant buttons is "variant:<choice id>:<variant index or audio>"
+const variantCallbackPrefix = "variant:"
+
+const (
+	variantChoiceAudio = "audio"
+	// Direct urls of CDNs expire, so choices are not kept for long
+	variantChoiceTTL = 10 * time.Minute
+	// Number of variant buttons per keyboard row
+	variantButtonsPerRow = 3
+)
+
+// pendingVariantChoice is everything needed to continue a download once the user picks a variant
+type pendingVariantChoice struct {
+	AccountID     int64
+	TelegramID    int64
+	ChatID        int64
+	OriginalMsgID int
+	StatusMsgID   int
+	RequestID     int64
+	QuotaChatID   int64 // Row of the group or channel the download counts against, 0 for the personal quota
+	URLIndex      int
+	URL           string
+	Mode          DownloadMode
+	Language      i18n.Language
+	UserAgent     string
+	Metadata      mediasaverbase.Metadata
+	Variants      []mediasaverbase.Variant
+}
+
+// shouldAskVariant reports whether the user picks the rendition of the video instead of the configured quality
+func (mp *MediaProcessor) shouldAskVariant(saver MediaSaver, directUrls []string) bool {
+	// Audio is the same in every rendition, and an explicit quality in settings means the user already chose.
+	// Channel posts have no user who could choose, plans without high quality get the lowest rendition.
+	if mp.processCtx.mode == DownloadModeAudio || mp.processCtx.settings.Quality.Valid || mp.processCtx.account.ID == 0 ||
+		!mp.processCtx.features.Has(model.FeatureHighQuality) {
+		return false
+	}
+
+	return len(directUrls) == 1 && len(saver.GetVariants()) > 1
+}
+
+// askVariant stores the variants of the video and shows them as buttons under the status message
+func (mp *MediaProcessor) askVariant(saver MediaSaver) error {
+	choice := pendingVariantChoice{
+		AccountID:     mp.processCtx.account.ID,
+		TelegramID:    mp.processCtx.account.TelegramID,
+		ChatID:        mp.processCtx.chatID,
+		OriginalMsgID: mp.processCtx.originalMsgID,
+		StatusMsgID:   mp.processCtx.statusMsg.ID,
+		RequestID:     mp.processCtx.requestID,
+		QuotaChatID:   getQuotaChatID(mp.processCtx.chat),
+		URLIndex:      mp.processCtx.urlIndex,
+		URL:           mp.processCtx.url,
+		Mode:          mp.processCtx.mode,
+		Language:      mp.processCtx.loc.Language(),
+		UserAgent:     saver.GetUA(),
+		Metadata:      mp.metadata,
+		Variants:      saver.GetVariants(),
+	}
+
+	choiceID := strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := mp.bot.cacheManager.Set(mp.processCtx.ctx, getVariantChoiceKey(choiceID), choice, store.WithExpiration(variantChoiceTTL)); err != nil {
+		return fmt.Errorf("failed to store variant choice: %w", err)
+	}
+
+	mp.updateChan <- MediaResult{
+		State:    mp.processCtx.loc.T("status.choose_quality"),
+		Keyboard: getVariantKeyboard(mp.processCtx.loc, choiceID, choice.Variants, mp.processCtx.features.Has(model.FeatureExtractAudio)),
+	}
+
+	return nil
+}
+
+// handleVariantCallback continues the download of a video with the variant picked by the user
+func (b *DefaultBot) handleVariantCallback(ctx context.Context, _ *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	loc := i18n.New(query.From.LanguageCode)
+
+	answer := func(text string, showAlert bool) {
+		if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       showAlert,
+		}); err != nil {
+			logger.Log.Sugar().Errorf("Failed to answer callback query: %v", err)
+		}
+	}
+
+	choiceID, value, ok := strings.Cut(strings.TrimPrefix(query.Data, variantCallbackPrefix), ":")
+	if !ok {
+		answer(loc.T("variant.unknown"), false)
+		return
+	}
+
+	var choice pendingVariantChoice
+	if _, err := b.cacheManager.Get(ctx, getVariantChoiceKey(choiceID), &choice); err != nil {
+		answer(loc.T("variant.expired"), true)
+		return
+	}
+	// Answers and the download continue in the language of the link sender
+	loc = i18n.New(string(choice.Language))
+
+	// In groups everyone sees the buttons, only the sender of the link can pick
+	if query.From.ID != choice.TelegramID {
+		answer(loc.T("variant.not_sender"), false)
+		return
+	}
+
+	mode := choice.Mode
+	var variant mediasaverbase.Variant
+	if value == variantChoiceAudio {
+		// Audio is the same in every rendition, the smallest one downloads fastest
+		mode = DownloadModeAudio
+		variant = choice.Variants[0]
+	} else {
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 || index >= len(choice.Variants) {
+			answer(loc.T("variant.unknown"), false)
+			return
+		}
+		variant = choice.Variants[index]
+	}
+
+	// A choice is used once, of quick presses only the one that claims it starts a download
+	claimKey := getVariantClaimKey(choiceID)
+	claimed, err := b.redisClient.SetNX(ctx, claimKey, query.ID, variantChoiceTTL).Result()
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to claim variant choice %s: %v", choiceID, err)
+		answer(loc.T("variant.start_failed"), true)
+		return
+	}
+	if !claimed {
+		answer(loc.T("variant.already_chosen"), false)
+		return
+	}
+
+	// The choice can be pressed again if the download could not start
+	unclaim := func() {
+		if err := b.redisClient.Del(context.Background(), claimKey).Err(); err != nil {
+			logger.Log.Sugar().Errorf("Failed to unclaim variant choice %s: %v", choiceID, err)
+		}
+	}
+	startFailed := func() {
+		unclaim()
+		answer(loc.T("variant.start_failed"), true)
+	}
+
+	settings, err := b.getOrCreateSettings(ctx, choice.AccountID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get settings of account %d: %v", choice.AccountID, err)
+		startFailed()
+		return
+	}
+
+	// The quota was released while the user was choosing, it is reserved again when the download continues
+	account, chat, err := b.getVariantQuota(ctx, choice)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get quota of variant choice %s: %v", choiceID, err)
+		startFailed()
+		return
+	}
+
+	features, err := b.GetPlanFeatures(ctx, account.ID)
+	if err != nil {
+		logger.Log.Sugar().Errorf("Failed to get plan features of account %d: %v", account.ID, err)
+		startFailed()
+		return
+	}
+
+	// The download continues in a saga of its own, it counts against the rate limits again
+	finishJob, err := b.startJob(ctx, account.ID, chat, features[model.FeatureRateLimitBurst.String()].Limit)
+	if err != nil {
+		logger.Log.Sugar().Infof("Rate limited variant choice %s: %v", choiceID, err)
+		unclaim()
+		answer(getRateLimitText(loc, err), true)
+		return
+	}
+
+	if err := b.cacheManager.Delete(ctx, getVariantChoiceKey(choiceID)); err != nil {
+		logger.Log.Sugar().Errorf("Failed to delete variant choice %s: %v", choiceID, err)
+	}
+
+	answer(variant.Label, false)
+
+	processCtx := &ProcessingContext{
+		ctx:           ctx,
+		chatID:        choice.ChatID,
+		originalMsgID: choice.OriginalMsgID,
+		urlIndex:      choice.URLIndex,
+		url:           choice.URL,
+		mode:          mode,
+		account:       account,
+		chat:          chat,
+		settings:      settings,
+		features:      features,
+		loc:           loc,
+		statusMsg:     &models.Message{ID: choice.StatusMsgID},
+		requestID:     choice.RequestID,
+		directURL:     variant.URL,
+		userAgent:     choice.UserAgent,
+		metadata:      choice.Metadata,
+		finishJob:     finishJob,
+	}
+
+	go b.runMediaProcessor(processCtx)
+}
+
+// getVariantQuota returns the account and chat whose quota the download of a variant choice counts against
+func (b *DefaultBot) getVariantQuota(ctx context.Context, choice pendingVariantChoice) (sqlc.AccountTelegram, *sqlc.AccountChat, error) {
+	var account sqlc.AccountTelegram
+	if choice.AccountID != 0 {
+		var err error
+		account, err = b.storage.GetAccountTelegram(ctx, sqlc.GetAccountTelegramParams{
+			ID: pgtype.Int8{Int64: choice.AccountID, Valid: true},
+		})
+		if err != nil {
+			return sqlc.AccountTelegram{}, nil, fmt.Errorf("failed to get account: %w", err)
+		}
+	}
+
+	if choice.QuotaChatID == 0 {
+		return account, nil, nil
+	}
+
+	chat, err := b.storage.GetAccountChat(ctx, sqlc.GetAccountChatParams{
+		ID: pgtype.Int8{Int64: choice.QuotaChatID, Valid: true},
+	})
+	if err != nil {
+		return sqlc.AccountTelegram{}, nil, fmt.Errorf("failed to get chat: %w", err)
+	}
+	return account, &chat, nil
+}
+
+func getQuotaChatID(chat *sqlc.AccountChat) int64 {
+	if chat == nil {
+		return 0
+	}
+	return chat.ID
+}
+
+// getVariantKeyboard returns a button per variant with its size if known, and an audio only button if audio is allowed
+func getVariantKeyboard(loc *i18n.Localizer, choiceID string, variants []mediasaverbase.Variant, audio bool) *models.InlineKeyboardMarkup {
+	sizes := getVariantSizes(variants)
+
+	var rows [][]models.InlineKeyboardButton
+	var row []models.InlineKeyboardButton
+	for i, variant := range variants {
+		text := variant.Label
+		if sizes[i] > 0 {
+			text += " · " + download.ByteCountBinary(sizes[i])
+		}
+
+		row = append(row, models.InlineKeyboardButton{
+			Text:         text,
+			CallbackData: fmt.Sprintf("%s%s:%d", variantCallbackPrefix, choiceID, i),
+		})
+		if len(row) == variantButtonsPerRow {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if audio {
+		rows = append(rows, []models.InlineKeyboardButton{{
+			Text:         loc.T("variant.audio_only"),
+			CallbackData: variantCallbackPrefix + choiceID + ":" + variantChoiceAudio,
+		}})
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// getVariantSizes requests the size of every variant concurrently, unknown sizes are 0
+func getVariantSizes(variants []mediasaverbase.Variant) []int64 {
+	sizes := make([]int64, len(variants))
+
+	var wg sync.WaitGroup
+	for i, variant := range variants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sizes[i], _ = download.GetFileSize(variant.URL)
+		}()
+	}
+	wg.Wait()
+
+	return sizes
+}
+
+func getVariantChoiceKey(choiceID string) string {
+	return "variant_choice:" + choiceID
+}
+
+func getVariantClaimKey(choiceID string) string {
+	return "variant_choice:" + choiceID + ":claimed"
+}
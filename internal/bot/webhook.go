@@ -0,0 +1,222 @@
+package tgbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codeonbeans/botfetchr/config"
+	paymentbase "github.com/codeonbeans/botfetchr/internal/client/payment/base"
+	"github.com/codeonbeans/botfetchr/internal/logger"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// Updates are small, anything larger is not from Telegram
+	maxWebhookBodySize = 1 << 20
+	// Time given to running requests and to deleting the webhook on shutdown
+	webhookShutdownTimeout = 10 * time.Second
+)
+
+// startWebhook registers the webhook and serves updates until the context is done
+func (b *DefaultBot) startWebhook(ctx context.Context) error {
+	cfg := config.GetConfig().TelegramBot.Webhook
+
+	webhookURL, err := url.Parse(cfg.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse webhook url: %w", err)
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST "+path, b.webhookHandler(ctx, cfg.SecretToken))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	b.registerPaymentRoutes(ctx, mux)
+
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Log.Sugar().Infof("Listening for webhook updates on %s%s", cfg.ListenAddr, path)
+
+		var err error
+		if cfg.CertFile != "" {
+			err = server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+		} else {
+			// TLS is terminated in front of the bot, e.g. by an ingress
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+		close(serveErr)
+	}()
+
+	// The server listens before Telegram is told to send updates to it
+	if err = b.setWebhook(ctx, cfg); err != nil {
+		server.Close()
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+		return fmt.Errorf("webhook server failed: %w", err)
+	}
+
+	logger.Log.Sugar().Info("Stopping webhook server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Sugar().Errorf("Failed to stop webhook server: %v", err)
+	}
+
+	if cfg.DeleteOnShutdown {
+		if _, err = b.DeleteWebhook(shutdownCtx, &bot.DeleteWebhookParams{}); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		logger.Log.Sugar().Info("Deleted webhook")
+	}
+
+	return nil
+}
+
+func (b *DefaultBot) setWebhook(ctx context.Context, cfg config.TelegramBotWebhook) error {
+	params := &bot.SetWebhookParams{
+		URL:         cfg.Url,
+		SecretToken: cfg.SecretToken,
+	}
+
+	// Telegram only trusts self-signed certificates it was given
+	if cfg.CertFile != "" {
+		cert, err := os.Open(cfg.CertFile)
+		if err != nil {
+			return fmt.Errorf("failed to open webhook certificate: %w", err)
+		}
+		defer cert.Close()
+
+		params.Certificate = &models.InputFileUpload{Filename: filepath.Base(cfg.CertFile), Data: cert}
+	}
+
+	if _, err := b.SetWebhook(ctx, params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	logger.Log.Sugar().Infof("Set webhook to %s", cfg.Url)
+	return nil
+}
+
+// webhookHandler dispatches the updates posted by Telegram, requests without the secret token are rejected.
+// Updates are handled with ctx, the request context ends as soon as Telegram gets its response.
+func (b *DefaultBot) webhookHandler(ctx context.Context, secretToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(secretToken)) != 1 {
+			logger.Log.Sugar().Warnf("Rejected webhook request from %s with invalid secret token", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update models.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBodySize)).Decode(&update); err != nil {
+			logger.Log.Sugar().Errorf("Failed to decode webhook update: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Handlers run in their own goroutines, Telegram gets its response right away
+		b.ProcessUpdate(ctx, &update)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// servePayments serves the payment routes until the context is done, the webhook server serves them in webhook mode
+func (b *DefaultBot) servePayments(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	b.registerPaymentRoutes(ctx, mux)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Sugar().Errorf("Failed to stop payment server: %v", err)
+		}
+	}()
+
+	logger.Log.Sugar().Infof("Listening for payment webhooks on %s", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Log.Sugar().Errorf("Payment server failed: %v", err)
+	}
+}
+
+// registerPaymentRoutes serves the webhooks of the payment providers on POST /payments/<provider>,
+// and the pages of providers that have their own below it, e.g. the checkout pages of the fake provider
+func (b *DefaultBot) registerPaymentRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.Handle("POST /payments/{provider}", b.paymentWebhookHandler(ctx))
+
+	for name, provider := range b.paymentProviders {
+		if pages, ok := provider.(interface{ Handler() http.Handler }); ok {
+			prefix := "/payments/" + name
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, pages.Handler()))
+		}
+	}
+}
+
+// paymentWebhookHandler applies the events posted by payment providers.
+// The event is applied before the response, providers retry webhooks that fail.
+func (b *DefaultBot) paymentWebhookHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := b.paymentProviders[r.PathValue("provider")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		event, err := provider.VerifyWebhook(r)
+		switch {
+		case errors.Is(err, paymentbase.ErrWebhookNotSupported):
+			http.NotFound(w, r)
+			return
+		case errors.Is(err, paymentbase.ErrInvalidSignature):
+			logger.Log.Sugar().Warnf("Rejected %s payment webhook from %s with invalid signature", provider.Name(), r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case err != nil:
+			logger.Log.Sugar().Errorf("Failed to verify %s payment webhook: %v", provider.Name(), err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		b.handlePaymentEvent(ctx, provider, event)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -119,7 +119,12 @@ func (c *clientImpl) UseBrowser(fn func(ctx context.Context, browser *Browser) e
 		// avoid stuck result channel
 		defer func() {
 			if r := recover(); r != nil {
-				resultChan <- fmt.Errorf("panic recovered: %v", r)
+				// Rod Must* methods panic with errors, keep them unwrappable to tell timeouts apart
+				if err, ok := r.(error); ok {
+					resultChan <- fmt.Errorf("panic recovered: %w", err)
+				} else {
+					resultChan <- fmt.Errorf("panic recovered: %v", r)
+				}
 			}
 			close(resultChan)
 		}()
@@ -0,0 +1,222 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codeonbeans/botfetchr/internal/logger"
+)
+
+const (
+	DEFAULT_FFMPEG_PATH  = "ffmpeg"
+	DEFAULT_FFPROBE_PATH = "ffprobe"
+)
+
+// Tags are metadata tags written into the output container
+type Tags struct {
+	Title  string
+	Artist string
+}
+
+// VideoInfo is the result of probing the first video stream of a file
+type VideoInfo struct {
+	Width    int     // Display width, already swapped for rotated videos
+	Height   int     // Display height, already swapped for rotated videos
+	Duration float64 // Seconds
+	Rotation int     // Degrees, one of 0, 90, 180, 270
+}
+
+type probeOutput struct {
+	Streams []struct {
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// Client runs the ffmpeg and ffprobe binaries
+type Client struct {
+	ffmpegPath  string
+	ffprobePath string
+}
+
+func NewClient(ffmpegPath, ffprobePath string) *Client {
+	if ffmpegPath == "" {
+		ffmpegPath = DEFAULT_FFMPEG_PATH
+	}
+	if ffprobePath == "" {
+		ffprobePath = DEFAULT_FFPROBE_PATH
+	}
+
+	return &Client{
+		ffmpegPath:  ffmpegPath,
+		ffprobePath: ffprobePath,
+	}
+}
+
+// ExtractAudio writes the first audio track of input into output, the container is chosen by the output extension
+//
+// Supported extensions: .m4a (AAC), .mp3 (MP3), .ogg (Opus, suitable for Telegram voice messages)
+func (c *Client) ExtractAudio(ctx context.Context, input, output string, tags Tags) error {
+	ext := strings.ToLower(filepath.Ext(output))
+
+	var codecs [][]string
+	switch ext {
+	case ".m4a":
+		// Instagram and VK serve AAC audio, copy the stream first and only re-encode if that fails
+		codecs = [][]string{
+			{"-c:a", "copy"},
+			{"-c:a", "aac", "-b:a", "192k"},
+		}
+	case ".mp3":
+		codecs = [][]string{{"-c:a", "libmp3lame", "-q:a", "2", "-id3v2_version", "3"}}
+	case ".ogg":
+		codecs = [][]string{{"-c:a", "libopus", "-b:a", "64k"}}
+	default:
+		return fmt.Errorf("unsupported audio format: %s", ext)
+	}
+
+	var err error
+	for _, codec := range codecs {
+		args := []string{"-y", "-hide_banner", "-loglevel", "error", "-i", input, "-vn", "-map", "0:a:0"}
+		args = append(args, codec...)
+		args = append(args, tagArgs(tags)...)
+		args = append(args, output)
+
+		if err = c.run(ctx, c.ffmpegPath, args...); err == nil {
+			return nil
+		}
+
+		logger.Log.Sugar().Warnf("Failed to extract audio with %v: %v", codec, err)
+	}
+
+	return fmt.Errorf("failed to extract audio from %s: %w", input, err)
+}
+
+// ProbeVideo reads resolution, duration and rotation of the first video stream of input
+func (c *Client) ProbeVideo(ctx context.Context, input string) (VideoInfo, error) {
+	cmd := exec.CommandContext(ctx, c.ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,duration:stream_tags=rotate:stream_side_data=rotation:format=duration",
+		"-of", "json",
+		input,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return VideoInfo{}, fmt.Errorf("failed to probe %s: %w", input, err)
+	}
+
+	var probe probeOutput
+	if err = json.Unmarshal(out, &probe); err != nil {
+		return VideoInfo{}, fmt.Errorf("failed to parse probe output: %w", err)
+	}
+
+	if len(probe.Streams) == 0 {
+		return VideoInfo{}, fmt.Errorf("no video stream found in %s", input)
+	}
+	stream := probe.Streams[0]
+
+	info := VideoInfo{
+		Width:  stream.Width,
+		Height: stream.Height,
+	}
+
+	// Stream duration is missing for some containers (e.g. webm), fall back to the format duration
+	for _, duration := range []string{stream.Duration, probe.Format.Duration} {
+		if d, err := strconv.ParseFloat(duration, 64); err == nil && d > 0 {
+			info.Duration = d
+			break
+		}
+	}
+
+	// Older muxers write the rotate tag, newer ones the display matrix side data
+	var rotation float64
+	if rotate, ok := stream.Tags["rotate"]; ok {
+		rotation, _ = strconv.ParseFloat(rotate, 64)
+	}
+	for _, sideData := range stream.SideDataList {
+		if sideData.Rotation != 0 {
+			rotation = sideData.Rotation
+		}
+	}
+	info.Rotation = ((int(math.Round(rotation))%360 + 360) % 360 / 90) * 90
+
+	if info.Rotation == 90 || info.Rotation == 270 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	return info, nil
+}
+
+// Thumbnail writes a JPEG frame taken at the given second of input into output,
+// scaled to fit into maxSize x maxSize (Telegram requires thumbnails to be at most 320x320 and 200 kB)
+func (c *Client) Thumbnail(ctx context.Context, input, output string, at float64, maxSize int) error {
+	args := []string{
+		"-y", "-hide_banner", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", maxSize, maxSize),
+		"-q:v", "5",
+		output,
+	}
+
+	if err := c.run(ctx, c.ffmpegPath, args...); err != nil {
+		return fmt.Errorf("failed to create thumbnail for %s: %w", input, err)
+	}
+
+	return nil
+}
+
+// ConvertToJPEG converts a still image (e.g. HEIC, AVIF, WebP) into a JPEG file
+func (c *Client) ConvertToJPEG(ctx context.Context, input, output string) error {
+	args := []string{
+		"-y", "-hide_banner", "-loglevel", "error",
+		"-i", input,
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	}
+
+	if err := c.run(ctx, c.ffmpegPath, args...); err != nil {
+		return fmt.Errorf("failed to convert %s to jpeg: %w", input, err)
+	}
+
+	return nil
+}
+
+func (c *Client) run(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", filepath.Base(name), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func tagArgs(tags Tags) []string {
+	var args []string
+	if tags.Title != "" {
+		args = append(args, "-metadata", "title="+tags.Title)
+	}
+	if tags.Artist != "" {
+		args = append(args, "-metadata", "artist="+tags.Artist)
+	}
+	return args
+}
@@ -2,16 +2,33 @@ package mediasaverbase
 
 import (
 	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
+	"strings"
 	"time"
 )
 
 const DEFAULT_TIMEOUT = 30 * time.Second
 const DEFAULT_USER_AGENT = "Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.1; Trident/4.0; SLCC2; .NET CLR 2.0.50727; .NET CLR 3.5.30729; .NET CLR 3.0.30729; Media Center PC 6.0; Maxthon 2.0)"
 
+// Metadata describes the media behind an url, filled by GetVideoURLs when the page exposes it
+type Metadata struct {
+	Title  string
+	Author string
+}
+
+// Variant is one rendition of a video, e.g. 720p
+type Variant struct {
+	Label  string // Shown to users, e.g. "720p"
+	Height int
+	URL    string
+}
+
 type BaseClientImpl struct {
-	UA      string
-	Quality string        // Quality can be "low" or "high"
-	Timeout time.Duration // Timeout for each task
+	UA       string
+	Quality  string        // Quality can be "low" or "high"
+	Timeout  time.Duration // Timeout for each task
+	Metadata Metadata      // Metadata of the last processed url
+	Variants []Variant     // Renditions of the video of the last processed url, from lowest to highest quality
 }
 
 func NewBaseClient() *BaseClientImpl {
@@ -62,3 +79,31 @@ func (c *BaseClientImpl) GetUA() string {
 	// Return the user agent
 	return c.UA
 }
+
+func (c *BaseClientImpl) GetMetadata() Metadata {
+	// Return the metadata of the last processed url
+	return c.Metadata
+}
+
+func (c *BaseClientImpl) GetVariants() []Variant {
+	// Return the renditions of the last processed url, only filled for a single video with several renditions
+	return c.Variants
+}
+
+// PageErrorMarker is a text a platform shows instead of the media, telling why it is not available
+type PageErrorMarker struct {
+	Text string
+	Kind model.ErrorKind
+}
+
+// DetectPageError returns an error of the kind of the first marker found in the page, nil if there is none.
+// Only the last page seen before the media wait times out is checked, the markers can be part of the scripts
+// of every page and of pages that are still loading.
+func DetectPageError(html string, markers []PageErrorMarker) error {
+	for _, marker := range markers {
+		if strings.Contains(html, marker.Text) {
+			return model.Errorf(marker.Kind, "page shows %q", marker.Text)
+		}
+	}
+	return nil
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
 	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
 	"github.com/codeonbeans/botfetchr/internal/utils/common"
 	"github.com/codeonbeans/botfetchr/internal/utils/download"
+	"maps"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +23,25 @@ import (
 
 var shortCodeRegex = regexp.MustCompile(`/(p|tv|reel|reels(?:/videos)?)/([A-Za-z0-9-_]+)`)
 
+var (
+	videoVersionsRegex = regexp.MustCompile(`"video_versions"\s*:\s*\[(.*?)\]`)
+	videoVersionRegex  = regexp.MustCompile(`\{[^{}]*\}`)
+	heightRegex        = regexp.MustCompile(`"height"\s*:\s*(\d+)`)
+	urlRegex           = regexp.MustCompile(`"url"\s*:\s*"([^"]+)"`)
+)
+
+var (
+	ownerUsernameRegex = regexp.MustCompile(`"owner":\{[^{}]*?"username":"([^"]+)"`)
+	captionTextRegex   = regexp.MustCompile(`"caption":\{[^{}]*?"text":"((?:[^"\\]|\\.)*)"`)
+)
+
+// pageErrorMarkers are texts of the pages Instagram shows instead of a post
+var pageErrorMarkers = []mediasaverbase.PageErrorMarker{
+	{Text: "Sorry, this page isn", Kind: model.ErrorKindNotFound}, // isn't or isn&#039;t
+	{Text: "This account is private", Kind: model.ErrorKindPrivateContent},
+	{Text: "Please wait a few minutes before you try again", Kind: model.ErrorKindRateLimited},
+}
+
 type clientImpl struct {
 	*mediasaverbase.BaseClientImpl
 }
@@ -50,8 +73,23 @@ func (c *clientImpl) GetVideoURLs(ctx context.Context, browser *rod.Browser, ogU
 
 	page.MustReload()
 
+	// The last page seen is checked for error markers once the media wait times out,
+	// a page still loading can contain them too
+	var lastHTML string
 	for {
-		html := page.MustHTML()
+		html, err := page.HTML()
+		if err != nil {
+			if pageErr := mediasaverbase.DetectPageError(lastHTML, pageErrorMarkers); pageErr != nil {
+				return nil, fmt.Errorf("failed to get media of %s: %w", ogUrl, pageErr)
+			}
+			return nil, fmt.Errorf("failed to get page HTML: %w", err)
+		}
+		lastHTML = html
+
+		// Private posts and profiles redirect anonymous visitors to the login page
+		if info, err := page.Info(); err == nil && strings.Contains(info.URL, "/accounts/login") {
+			return nil, model.Errorf(model.ErrorKindPrivateContent, "%s redirects to login", ogUrl)
+		}
 
 		var urls []string
 
@@ -83,6 +121,10 @@ func (c *clientImpl) GetVideoURLs(ctx context.Context, browser *rod.Browser, ogU
 		}
 
 		if len(urls) > 0 {
+			c.Metadata = extractMetadata(html)
+			if isReel(ogUrl) {
+				c.Variants = extractVariants(html)
+			}
 			return urls, nil
 		}
 	}
@@ -107,10 +149,6 @@ func (c *clientImpl) IsValidURL(url string) bool {
 }
 
 func (c *clientImpl) extractVideoURLs(text string) []string {
-	// Regex pattern to match URLs within video_versions array
-	pattern := `"video_versions"\s*:\s*\[(.*?)\]`
-	videoVersionsRegex := regexp.MustCompile(pattern)
-
 	// Find all video_versions sections
 	videoVersionsMatches := videoVersionsRegex.FindAllStringSubmatch(text, -1)
 	if len(videoVersionsMatches) == 0 {
@@ -120,9 +158,6 @@ func (c *clientImpl) extractVideoURLs(text string) []string {
 	var urls []string
 
 	// Extract URLs from each video_versions section
-	urlPattern := `"url"\s*:\s*"([^"]+)"`
-	urlRegex := regexp.MustCompile(urlPattern)
-
 	for _, videoVersionsMatch := range videoVersionsMatches {
 		if len(videoVersionsMatch) < 2 {
 			continue
@@ -165,6 +200,66 @@ func extractImageURLs(text string) []string {
 	return urls
 }
 
+// extractVariants returns the renditions of the first video on the page, one per height
+func extractVariants(text string) []mediasaverbase.Variant {
+	videoVersionsMatch := videoVersionsRegex.FindStringSubmatch(text)
+	if len(videoVersionsMatch) < 2 {
+		return nil
+	}
+
+	byHeight := make(map[int]mediasaverbase.Variant)
+	for _, version := range videoVersionRegex.FindAllString(videoVersionsMatch[1], -1) {
+		heightMatch := heightRegex.FindStringSubmatch(version)
+		urlMatch := urlRegex.FindStringSubmatch(version)
+		if len(heightMatch) < 2 || len(urlMatch) < 2 {
+			continue
+		}
+
+		height, err := strconv.Atoi(heightMatch[1])
+		if err != nil {
+			continue
+		}
+		if _, ok := byHeight[height]; ok {
+			continue
+		}
+
+		url, err := common.UnmarshalURL(urlMatch[1])
+		if err != nil {
+			continue
+		}
+
+		byHeight[height] = mediasaverbase.Variant{
+			Label:  fmt.Sprintf("%dp", height),
+			Height: height,
+			URL:    url,
+		}
+	}
+
+	variants := slices.Collect(maps.Values(byHeight))
+	slices.SortFunc(variants, func(a, b mediasaverbase.Variant) int {
+		return a.Height - b.Height
+	})
+
+	return variants
+}
+
+func extractMetadata(text string) mediasaverbase.Metadata {
+	var metadata mediasaverbase.Metadata
+
+	if matches := ownerUsernameRegex.FindStringSubmatch(text); len(matches) > 1 {
+		metadata.Author = matches[1]
+	}
+
+	if matches := captionTextRegex.FindStringSubmatch(text); len(matches) > 1 {
+		if caption, err := common.UnmarshalURL(matches[1]); err == nil {
+			// Caption can be long, only the first line is used as title
+			metadata.Title = strings.TrimSpace(strings.SplitN(caption, "\n", 2)[0])
+		}
+	}
+
+	return metadata
+}
+
 func isPost(url string) bool {
 	// Match common Instagram post patterns
 	return strings.Contains(url, "/p/") || strings.Contains(url, "/tv/") || strings.Contains(url, "/post/")
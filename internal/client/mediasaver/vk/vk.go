@@ -5,10 +5,13 @@ import (
 	"fmt"
 	mediasaverbase "github.com/codeonbeans/botfetchr/internal/client/mediasaver/base"
 	"github.com/codeonbeans/botfetchr/internal/logger"
+	"github.com/codeonbeans/botfetchr/internal/model"
 	"github.com/codeonbeans/botfetchr/internal/utils/common"
 	"github.com/codeonbeans/botfetchr/internal/utils/download"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/go-rod/rod"
@@ -18,6 +21,26 @@ import (
 
 var shortCodeRegex = regexp.MustCompile(`https:\/\/(m\.)?vkvideo\.ru\/video-(\d+)_(\d+)`)
 
+var videoURLRegex = regexp.MustCompile(`"url(\d+)":"([^"]+)"`)
+
+var (
+	titleRegex  = regexp.MustCompile(`"md_title":"((?:[^"\\]|\\.)*)"`)
+	authorRegex = regexp.MustCompile(`"md_author":"((?:[^"\\]|\\.)*)"`)
+)
+
+// pageErrorMarkers are texts the VK player shows instead of a video, in Russian and English.
+// Region restrictions come first, VK also calls those videos unavailable.
+var pageErrorMarkers = []mediasaverbase.PageErrorMarker{
+	{Text: "недоступно в вашей стране", Kind: model.ErrorKindGeoBlocked},
+	{Text: "not available in your country", Kind: model.ErrorKindGeoBlocked},
+	{Text: "Доступ запрещён", Kind: model.ErrorKindPrivateContent},
+	{Text: "Access denied", Kind: model.ErrorKindPrivateContent},
+	{Text: "Видеозапись была удалена", Kind: model.ErrorKindNotFound},
+	{Text: "Video has been removed", Kind: model.ErrorKindNotFound},
+	{Text: "Видео недоступно", Kind: model.ErrorKindNotFound},
+	{Text: "Video is unavailable", Kind: model.ErrorKindNotFound},
+}
+
 type clientImpl struct {
 	*mediasaverbase.BaseClientImpl
 }
@@ -31,7 +54,7 @@ func NewClient() *clientImpl {
 func (c *clientImpl) GetVideoURLs(ctx context.Context, browser *rod.Browser, urlText string) (videoURLs []string, err error) {
 	ownerID, videoID, err := getOidAndId(urlText)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse VK video URL: %w", err)
+		return nil, model.Errorf(model.ErrorKindUnsupportedURL, "failed to parse VK video URL: %w", err)
 	}
 
 	embedUrl := fmt.Sprintf("https://vkvideo.ru/video_ext.php?oid=-%s&id=%s", ownerID, videoID)
@@ -55,8 +78,19 @@ func (c *clientImpl) GetVideoURLs(ctx context.Context, browser *rod.Browser, url
 
 	page.MustReload()
 
+	// The last page seen is checked for error markers once the media wait times out,
+	// a page still loading can contain them too
+	var lastHTML string
 	for {
-		html := page.MustHTML()
+		html, err := page.HTML()
+		if err != nil {
+			if pageErr := mediasaverbase.DetectPageError(lastHTML, pageErrorMarkers); pageErr != nil {
+				return nil, fmt.Errorf("failed to get video of %s: %w", urlText, pageErr)
+			}
+			return nil, fmt.Errorf("failed to get page HTML: %w", err)
+		}
+		lastHTML = html
+
 		urls := extractVideoURLs(html)
 		if len(urls) > 0 {
 			var marshaledURL string
@@ -71,6 +105,8 @@ func (c *clientImpl) GetVideoURLs(ctx context.Context, browser *rod.Browser, url
 				return nil, fmt.Errorf("failed to parse video URL: %w", err)
 			}
 
+			c.Metadata = extractMetadata(html)
+			c.Variants = extractVariants(html)
 			return []string{url}, nil
 		}
 
@@ -111,17 +147,64 @@ func getOidAndId(url string) (ownerID, videoID string, err error) {
 	return "", "", fmt.Errorf("invalid VK video URL format")
 }
 
-func extractVideoURLs(text string) []string {
-	urlRegex := regexp.MustCompile(`"url\d+":"([^"]+)"`)
+func extractMetadata(text string) mediasaverbase.Metadata {
+	var metadata mediasaverbase.Metadata
+
+	if matches := titleRegex.FindStringSubmatch(text); len(matches) > 1 {
+		if title, err := common.UnmarshalURL(matches[1]); err == nil {
+			metadata.Title = title
+		}
+	}
+
+	if matches := authorRegex.FindStringSubmatch(text); len(matches) > 1 {
+		if author, err := common.UnmarshalURL(matches[1]); err == nil {
+			metadata.Author = author
+		}
+	}
+
+	return metadata
+}
 
-	matches := urlRegex.FindAllStringSubmatch(text, -1)
+func extractVideoURLs(text string) []string {
+	matches := videoURLRegex.FindAllStringSubmatch(text, -1)
 
 	var urls []string
 	for _, match := range matches {
-		if len(match) > 1 {
-			urls = append(urls, match[1])
+		if len(match) > 2 {
+			urls = append(urls, match[2])
 		}
 	}
 
 	return urls
 }
+
+// extractVariants returns the renditions of the video, the player lists them as url240, url360, ...
+func extractVariants(text string) []mediasaverbase.Variant {
+	var variants []mediasaverbase.Variant
+	seen := make(map[int]bool)
+
+	for _, match := range videoURLRegex.FindAllStringSubmatch(text, -1) {
+		height, err := strconv.Atoi(match[1])
+		if err != nil || seen[height] {
+			continue
+		}
+
+		url, err := common.UnmarshalURL(match[2])
+		if err != nil {
+			continue
+		}
+
+		seen[height] = true
+		variants = append(variants, mediasaverbase.Variant{
+			Label:  fmt.Sprintf("%dp", height),
+			Height: height,
+			URL:    url,
+		})
+	}
+
+	slices.SortFunc(variants, func(a, b mediasaverbase.Variant) int {
+		return a.Height - b.Height
+	})
+
+	return variants
+}
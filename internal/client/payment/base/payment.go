@@ -0,0 +1,52 @@
+package paymentbase
+
+import "errors"
+
+var (
+	ErrInvalidSignature    = errors.New("invalid webhook signature")
+	ErrWebhookNotSupported = errors.New("provider does not send webhooks")
+	ErrChargeNotFound      = errors.New("charge not found")
+	ErrAlreadyRefunded     = errors.New("charge is already refunded")
+)
+
+// CheckoutRequest asks a provider to take a payment for an invoice
+type CheckoutRequest struct {
+	InvoiceID   string // Sent back in the events of the payment
+	ChatID      int64  // Private chat of the buyer
+	Title       string
+	Description string
+	Currency    string
+	Amount      int // In the smallest unit of the currency, e.g. cents or stars
+}
+
+// Checkout is where a payment is made
+type Checkout struct {
+	ID  string // Id of the checkout at the provider
+	URL string // Page the buyer pays at, empty if the provider sent an invoice to the chat itself
+}
+
+type EventType string
+
+const (
+	EventTypePaid     EventType = "paid"
+	EventTypeRefunded EventType = "refunded"
+)
+
+// Event is a change of a payment reported by a provider, e.g. in a webhook
+type Event struct {
+	Type             EventType `json:"type"`
+	InvoiceID        string    `json:"invoiceId"`
+	Currency         string    `json:"currency"`
+	Amount           int       `json:"amount"`
+	ChargeID         string    `json:"chargeId"`                   // Id of the payment at the provider, refunds are made with it
+	ProviderChargeID string    `json:"providerChargeId,omitempty"` // Id at the processor behind the provider, if any
+	UserID           int64     `json:"userId,omitempty"`           // Telegram user who paid, if the provider knows it
+}
+
+// RefundRequest returns a payment to the buyer
+type RefundRequest struct {
+	ChargeID string
+	UserID   int64 // Telegram user who paid
+	Currency string
+	Amount   int
+}
@@ -0,0 +1,257 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sync"
+
+	paymentbase "github.com/codeonbeans/botfetchr/internal/client/payment/base"
+
+	"github.com/google/uuid"
+)
+
+const (
+	Name            = "fake"
+	SignatureHeader = "X-Fake-Signature"
+	// Webhooks are small, anything larger is not from the fake provider
+	maxWebhookBodySize = 1 << 16
+)
+
+var checkoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Request.Title}}</title></head>
+<body>
+<h1>{{.Request.Title}}</h1>
+<p>{{.Request.Description}}</p>
+{{if .Paid}}<p>Paid, you can go back to Telegram.</p>{{else}}
+<form method="post"><button type="submit">Pay {{.Request.Amount}} {{.Request.Currency}}</button></form>{{end}}
+</body>
+</html>
+`))
+
+// Payment is the state of a checkout of the fake provider
+type Payment struct {
+	Checkout paymentbase.Checkout
+	Request  paymentbase.CheckoutRequest
+	ChargeID string // Set once paid
+	Paid     bool
+	Refunded bool
+}
+
+// Client is a payment provider that keeps its checkouts in memory and never moves money.
+// Payments are made with Pay, or on the checkout page served by Handler for staging,
+// and are reported in webhooks signed with the secret, like a card processor would.
+type Client struct {
+	secret     string
+	url        string // Public URL of the routes of the provider, webhooks are posted to it
+	currency   string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*Payment // By checkout id
+}
+
+func NewClient(secret, url, currency string) *Client {
+	return &Client{
+		secret:     secret,
+		url:        url,
+		currency:   currency,
+		httpClient: http.DefaultClient,
+		payments:   make(map[string]*Payment),
+	}
+}
+
+func (c *Client) Name() string {
+	return Name
+}
+
+func (c *Client) Currency() string {
+	return c.currency
+}
+
+func (c *Client) CreateCheckout(_ context.Context, req paymentbase.CheckoutRequest) (paymentbase.Checkout, error) {
+	id := uuid.NewString()
+	checkout := paymentbase.Checkout{ID: id, URL: c.url + "/checkouts/" + id}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payments[id] = &Payment{Checkout: checkout, Request: req}
+
+	return checkout, nil
+}
+
+// VerifyWebhook checks the signature of a webhook and returns its event
+func (c *Client) VerifyWebhook(r *http.Request) (paymentbase.Event, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		return paymentbase.Event{}, fmt.Errorf("failed to read webhook: %w", err)
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || !hmac.Equal(signature, c.sign(body)) {
+		return paymentbase.Event{}, paymentbase.ErrInvalidSignature
+	}
+
+	var event paymentbase.Event
+	if err = json.Unmarshal(body, &event); err != nil {
+		return paymentbase.Event{}, fmt.Errorf("failed to decode webhook: %w", err)
+	}
+
+	return event, nil
+}
+
+func (c *Client) Refund(_ context.Context, req paymentbase.RefundRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, payment := range c.payments {
+		if payment.Paid && payment.ChargeID == req.ChargeID {
+			if payment.Refunded {
+				return paymentbase.ErrAlreadyRefunded
+			}
+			payment.Refunded = true
+			return nil
+		}
+	}
+
+	return paymentbase.ErrChargeNotFound
+}
+
+// Get returns a copy of the payment of a checkout
+func (c *Client) Get(checkoutID string) (Payment, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payment, ok := c.payments[checkoutID]
+	if !ok {
+		return Payment{}, false
+	}
+	return *payment, true
+}
+
+// Pay completes the payment of a checkout as if the buyer paid, and returns the event a webhook reports
+func (c *Client) Pay(checkoutID string) (paymentbase.Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payment, ok := c.payments[checkoutID]
+	if !ok {
+		return paymentbase.Event{}, fmt.Errorf("checkout %s not found", checkoutID)
+	}
+	if !payment.Paid {
+		payment.Paid = true
+		payment.ChargeID = "fake_" + uuid.NewString()
+	}
+
+	return newEvent(paymentbase.EventTypePaid, payment), nil
+}
+
+// Chargeback refunds a payment as if the buyer disputed it, and returns the event a webhook reports
+func (c *Client) Chargeback(checkoutID string) (paymentbase.Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payment, ok := c.payments[checkoutID]
+	if !ok || !payment.Paid {
+		return paymentbase.Event{}, paymentbase.ErrChargeNotFound
+	}
+	payment.Refunded = true
+
+	return newEvent(paymentbase.EventTypeRefunded, payment), nil
+}
+
+// NewWebhookRequest returns the signed webhook request of an event, posted to the URL of the provider
+func (c *Client) NewWebhookRequest(ctx context.Context, event paymentbase.Event) (*http.Request, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, hex.EncodeToString(c.sign(body)))
+
+	return req, nil
+}
+
+// Deliver posts the webhook of an event
+func (c *Client) Deliver(ctx context.Context, event paymentbase.Event) error {
+	req, err := c.NewWebhookRequest(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook was answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handler serves the checkout pages, relative to the URL of the provider.
+// Paying on a page delivers the webhook right away.
+func (c *Client) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /checkouts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		payment, ok := c.Get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = checkoutPage.Execute(w, payment)
+	})
+
+	mux.HandleFunc("POST /checkouts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		event, err := c.Pay(r.PathValue("id"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err = c.Deliver(r.Context(), event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		// Relative to the page, the path was stripped of the URL of the provider
+		w.Header().Set("Location", r.PathValue("id"))
+		w.WriteHeader(http.StatusSeeOther)
+	})
+
+	return mux
+}
+
+func (c *Client) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(c.secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func newEvent(eventType paymentbase.EventType, payment *Payment) paymentbase.Event {
+	return paymentbase.Event{
+		Type:      eventType,
+		InvoiceID: payment.Request.InvoiceID,
+		Currency:  payment.Request.Currency,
+		Amount:    payment.Request.Amount,
+		ChargeID:  payment.ChargeID,
+	}
+}
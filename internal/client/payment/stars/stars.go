@@ -0,0 +1,95 @@
+package stars
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	paymentbase "github.com/codeonbeans/botfetchr/internal/client/payment/base"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	Name = "stars"
+	// Telegram Stars, payments in Stars need no provider token
+	Currency = "XTR"
+)
+
+// clientImpl takes payments in Telegram Stars. Telegram reports them as updates of the bot,
+// they are turned into events with NewPaidEvent and NewRefundedEvent.
+type clientImpl struct {
+	bot *bot.Bot
+}
+
+func NewClient(b *bot.Bot) *clientImpl {
+	return &clientImpl{bot: b}
+}
+
+func (c *clientImpl) Name() string {
+	return Name
+}
+
+func (c *clientImpl) Currency() string {
+	return Currency
+}
+
+// CreateCheckout sends an invoice to the chat, it is paid in the chat
+func (c *clientImpl) CreateCheckout(ctx context.Context, req paymentbase.CheckoutRequest) (paymentbase.Checkout, error) {
+	if _, err := c.bot.SendInvoice(ctx, &bot.SendInvoiceParams{
+		ChatID:      req.ChatID,
+		Title:       req.Title,
+		Description: req.Description,
+		Payload:     req.InvoiceID,
+		Currency:    Currency,
+		Prices: []models.LabeledPrice{
+			{Label: req.Title, Amount: req.Amount},
+		},
+	}); err != nil {
+		return paymentbase.Checkout{}, fmt.Errorf("failed to send invoice: %w", err)
+	}
+
+	return paymentbase.Checkout{ID: req.InvoiceID}, nil
+}
+
+func (c *clientImpl) VerifyWebhook(_ *http.Request) (paymentbase.Event, error) {
+	return paymentbase.Event{}, paymentbase.ErrWebhookNotSupported
+}
+
+func (c *clientImpl) Refund(ctx context.Context, req paymentbase.RefundRequest) error {
+	if _, err := c.bot.RefundStarPayment(ctx, &bot.RefundStarPaymentParams{
+		UserID:                  req.UserID,
+		TelegramPaymentChargeID: req.ChargeID,
+	}); err != nil {
+		return fmt.Errorf("failed to refund star payment: %w", err)
+	}
+
+	return nil
+}
+
+// NewPaidEvent returns the event of a successful_payment message of a user
+func NewPaidEvent(userID int64, payment *models.SuccessfulPayment) paymentbase.Event {
+	return paymentbase.Event{
+		Type:             paymentbase.EventTypePaid,
+		InvoiceID:        payment.InvoicePayload,
+		Currency:         payment.Currency,
+		Amount:           payment.TotalAmount,
+		ChargeID:         payment.TelegramPaymentChargeID,
+		ProviderChargeID: payment.ProviderPaymentChargeID,
+		UserID:           userID,
+	}
+}
+
+// NewRefundedEvent returns the event of a refunded_payment message of a user
+func NewRefundedEvent(userID int64, payment *models.RefundedPayment) paymentbase.Event {
+	return paymentbase.Event{
+		Type:             paymentbase.EventTypeRefunded,
+		InvoiceID:        payment.InvoicePayload,
+		Currency:         payment.Currency,
+		Amount:           payment.TotalAmount,
+		ChargeID:         payment.TelegramPaymentChargeID,
+		ProviderChargeID: payment.ProviderPaymentChargeID,
+		UserID:           userID,
+	}
+}
@@ -0,0 +1,246 @@
+package i18n
+
+var en = map[string]string{
+	// Command menu and /help
+	"command.start":      "Welcome and supported platforms",
+	"command.help":       "How to use the bot",
+	"command.dl":         "Download a link, or the links of the message replied to",
+	"command.audio":      "Download only the audio track",
+	"command.file":       "Download originals as files",
+	"command.usage":      "Show your usage and limits",
+	"command.plan":       "Show your current plan",
+	"command.history":    "Show your recent downloads",
+	"command.settings":   "Change your preferences",
+	"command.chat":       "Change the bot settings of a group (admins)",
+	"command.stats":      "Downloads and users of the last days",
+	"command.user":       "Look up a user",
+	"command.grant":      "Give a user a plan, 0 days never ends",
+	"command.ban":        "Ignore every message of a user",
+	"command.unban":      "Lift the ban of a user",
+	"command.resetusage": "Reset the usage limits of a user",
+	"command.broadcast":  "Send a text, or the message replied to, to every user",
+	"command.upgrade":    "Upgrade your plan with Telegram Stars",
+	"command.redeem":     "Redeem a promo code",
+	"command.referrals":  "Invite friends and get bonus downloads",
+	"command.promo":      "Create a promo code, or show one and its redemptions",
+	"command.args.link":  "[link]",
+	"command.args.days":  "[days]",
+	"command.args.user":  "<id|@username>",
+	"command.args.grant": "<id|@username> <plan> <days>",
+	"command.args.text":  "<text>",
+	"command.args.code":  "<code>",
+	"command.args.promo": "<code> [<plan> <days> [max uses] [valid for days]]",
+	"command.no_links":   "Send a link after the command, or reply with the command to a message with a link.",
+	"start.greeting":     "👋 Hi, %s!\n\nSend me a link and I will download the media for you.\n\nSupported platforms:\n",
+	"start.footer":       "\nSee /help for all commands.",
+	"help.intro":         "Send one or more links and I will reply with the media.\n\nCommands:\n",
+	"platform.instagram": "posts, reels and IGTV",
+	"platform.vk":        "videos and clips",
+
+	// /usage and /plan
+	"usage.failed":                 "Failed to load your usage, please try again later.",
+	"usage.title":                  "📊 Usage on plan %s\n\n",
+	"usage.resets_in":              ", resets in %s",
+	"usage.bonus":                  " (+%s bonus)",
+	"usage.no_features":            "Your plan has no features.\n",
+	"usage.included":               "\nIncluded: %s\n",
+	"usage.rate_limit":             "\nRate limit: %s per minute, %s at once\n",
+	"feature.downloads":            "Downloads",
+	"feature.high_quality":         "High quality",
+	"feature.extract_audio":        "Audio extraction",
+	"feature.batch_download":       "Several links per message",
+	"feature.large_files":          "Large files",
+	"feature.inline_mode":          "Inline mode",
+	"feature.priority_queue":       "Priority queue",
+	"plan.failed":                  "Failed to load your plan, please try again later.",
+	"plan.title":                   "💳 Plan: %s\nStatus: %s\n",
+	"plan.started":                 "Started: %s\n",
+	"plan.ends_never":              "Ends: never\n",
+	"plan.expired":                 "Expired: %s\n",
+	"plan.ends":                    "Ends: %s (in %s)\n",
+	"plan.cancels":                 "Cancels: %s\n",
+	"subscription.status.active":   "active",
+	"subscription.status.canceled": "canceled",
+	"subscription.status.expired":  "expired",
+	"subscription.status.trialing": "trial",
+	"subscription.reminder":        "⏰ Your %s plan ends on %s (in %s). Renew it with /upgrade to keep your limits.",
+	"subscription.trial_reminder":  "⏰ Your %s trial ends on %s (in %s). Upgrade with /upgrade to keep your limits.",
+	"subscription.expired_notice":  "Your %s plan has expired, you are on the Free plan now. Renew it with /upgrade.",
+	"subscription.canceled_notice": "Your %s plan was canceled, you are on the Free plan now, see /upgrade.",
+
+	// Durations in /usage and /plan
+	"duration.seconds.one":   "%d second",
+	"duration.seconds.other": "%d seconds",
+	"duration.minutes.one":   "%d minute",
+	"duration.minutes.other": "%d minutes",
+	"duration.hours.one":     "%d hour",
+	"duration.hours.other":   "%d hours",
+	"duration.days.one":      "%d day",
+	"duration.days.other":    "%d days",
+
+	// Errors of download permission checks and downloads, by model.ErrorKind
+	"error.limit_exceeded":        "You have reached the download limit of your plan, see /usage.",
+	"error.chat_limit_exceeded":   "This chat has reached its download limit, please try again later.",
+	"error.feature_not_available": "Downloads are not available on your plan, see /plan.",
+	"error.feature_locked":        "%s is available on paid plans, see /upgrade.",
+	"batch.locked":                "Only the first link is downloaded, several links per message are available on paid plans, see /upgrade.",
+	"rate_limit.retry_after":      "🐢 Slow down! %s skipped, send them again in %s.",
+	"rate_limit.running":          "🐢 Slow down! %s skipped, send them again once your downloads are done.",
+	"rate_limit.links.one":        "%d link",
+	"rate_limit.links.other":      "%d links",
+	"rate_limit.try_again_in":     "🐢 Slow down! Try again in %s.",
+	"rate_limit.try_again_later":  "🐢 Slow down! Try again once your downloads are done.",
+	"error.internal":              "Something went wrong, please try again later.",
+	"error.private_content":       "This content is private or requires login, only public posts can be downloaded.",
+	"error.not_found":             "Nothing found at this link, check that it is correct and the post was not deleted.",
+	"error.geo_blocked":           "This content is not available in the region of the bot.",
+	"error.rate_limited":          "Too many requests right now, please try again in a few minutes.",
+	"error.unsupported_url":       "This link is not supported, see /start for the supported platforms.",
+	"error.too_large":             "The file is too large for Telegram, try a lower quality in /settings.",
+	"error.timeout":               "The platform took too long to respond, please try again later.",
+
+	// /settings and /chat
+	"callback.unknown_setting":  "Unknown setting",
+	"callback.save_failed":      "Failed to save, please try again later",
+	"callback.saved":            "Saved",
+	"settings.failed":           "Failed to load your settings, please try again later.",
+	"settings.text":             "⚙️ Settings\n\nQuality: %s\nMode: %s\nCaption: %s\nLanguage: %s",
+	"settings.quality.default":  "Ask",
+	"settings.quality.high":     "High",
+	"settings.quality.low":      "Low",
+	"settings.mode.media":       "Media",
+	"settings.mode.audio":       "Audio",
+	"settings.mode.document":    "File",
+	"settings.caption.on":       "On",
+	"settings.caption.off":      "Off",
+	"settings.language.default": "Auto",
+	"settings.language.en":      "English",
+	"settings.language.ru":      "Русский",
+	"chat.private":              "Use /chat in a group to change how the bot behaves there.",
+	"chat.admins_only":          "Only admins of this chat can change its settings.",
+	"chat.failed":               "Failed to load the chat settings, please try again later.",
+	"chat.callback.admins_only": "Only admins can change chat settings",
+	"chat.text":                 "⚙️ Chat settings\n\nBot: %s\nDownload: %s\nUsage: %d/%s",
+	"chat.enabled.on":           "On",
+	"chat.enabled.off":          "Off",
+	"chat.trigger.auto":         "Every link",
+	"chat.trigger.mention":      "Mention",
+	"chat.trigger.command":      "/dl only",
+
+	// Status message of a download
+	"status.line":             "%d. %s\nState: %s",
+	"status.queued":           "⌛ queued...",
+	"status.getting_info":     "🔎 getting info...",
+	"status.downloading":      "⬇️ downloading media %d/%d...%s",
+	"status.extracting_audio": "🎵 extracting audio %d/%d...",
+	"status.sending":          "📲 sending media%s",
+	"status.success.one":      "✅ got %d file successfully%s",
+	"status.success.other":    "✅ got %d files successfully%s",
+	"status.failed":           "❌ %s",
+	"status.send_failed":      "❌ failed to send media: %s",
+	"status.choose_quality":   "🎚 choose quality:",
+	"status.too_large":        "%d. %s\nFile (%d) too large to send directly (%.2f MB). Direct URL: %s",
+
+	// /history
+	"history.failed":      "Failed to load your history, please try again later.",
+	"history.empty":       "You have no downloads yet, send me a link.",
+	"history.title":       "📜 Your downloads, page %d of %d\n\n",
+	"history.unavailable": "This download can't be sent again",
+	"history.sending":     "Sending...",
+
+	// /upgrade and payments
+	"upgrade.private":             "Use /upgrade in a private chat with me.",
+	"upgrade.failed":              "Couldn't load the prices, please try again later.",
+	"upgrade.title":               "💎 Your plan: %s\n\nChoose a plan to pay for with Telegram Stars, paying for your current plan extends it.",
+	"upgrade.button":              "%s, %s: %s",
+	"upgrade.no_prices":           "There is nothing to buy right now.",
+	"upgrade.never_ends":          "Your %s plan never ends, there is nothing to upgrade.",
+	"upgrade.interval.monthly":    "1 month",
+	"upgrade.interval.yearly":     "1 year",
+	"upgrade.interval.lifetime":   "forever",
+	"upgrade.invoice_title":       "%s plan",
+	"upgrade.invoice_description": "%s plan, %s. It starts right after the payment.",
+	"upgrade.invoice_failed":      "Couldn't create the invoice, please try again later",
+	"payment.invalid":             "This invoice is no longer valid, please use /upgrade again.",
+	"payment.success":             "🎉 Thank you! Your %s plan is active until %s, see /plan.",
+	"payment.success_forever":     "🎉 Thank you! Your %s plan is active forever, see /plan.",
+	"payment.refunded":            "Your payment couldn't be applied and was refunded, please try again later.",
+	"payment.failed":              "Your payment couldn't be applied, please contact the admins.",
+	"payment.refund_received":     "💸 Your payment was refunded and the plan time it paid for was taken back, see /plan.",
+	"upgrade.checkout":            "Pay for the %s plan (%s) on the checkout page:",
+	"upgrade.pay_button":          "Pay %s",
+
+	// Trials and promo codes
+	"trial.started":           "🎁 Welcome! You are trying the %s plan for free for %s, until %s. See /plan.",
+	"redeem.usage":            "Send the promo code after the command, e.g. /redeem SPRING",
+	"redeem.not_found":        "There is no promo code %s.",
+	"redeem.expired":          "The promo code %s has expired.",
+	"redeem.used_up":          "The promo code %s has been used up.",
+	"redeem.already_redeemed": "You already redeemed the promo code %s.",
+	"redeem.plan_paid":        "Your %s plan is paid until %s, redeem the promo code %s once it ends.",
+	"redeem.failed":           "Couldn't redeem the promo code, please try again later.",
+	"redeem.success":          "🎟 Promo code redeemed! Your %s plan is active until %s, see /plan.",
+	"redeem.success_forever":  "🎟 Promo code redeemed! Your %s plan is active forever, see /plan.",
+
+	// /referrals
+	"referral.failed":                "Couldn't load your invite link, please try again later.",
+	"referral.title":                 "🤝 Invite friends with your link:\n%s\n\nOnce a friend made their first download, you both get %s.\n\nInvited: %d, bonuses received: %d",
+	"referral.bonus_downloads.one":   "%d extra download",
+	"referral.bonus_downloads.other": "%d extra downloads",
+	"referral.bonus_days":            "%s of the %s plan",
+	"referral.bonus_and":             " and ",
+	"referral.bonus_none":            "our thanks",
+	"referral.joined":                "🤝 %s joined with your invite link. You both get %s after their first download.",
+	"referral.rewarded_referrer":     "🎉 %s made their first download, you got %s. See /usage.",
+	"referral.rewarded_referee":      "🎉 Thanks for joining with an invite link, you got %s. See /usage.",
+
+	// Admin commands
+	"admin.usage":                "Usage: /%s %s",
+	"admin.failed":               "Failed: %v",
+	"admin.user_not_found":       "No user %s, users are known once they wrote to the bot.",
+	"admin.stats":                "📈 Last %s\n\nUsers: %d (%d new)\nDownloads: %d, %d succeeded (%.1f%%), %d failed\nAverage time: %.1fs\nSent: %s\n",
+	"admin.stats.platforms":      "\nPlatforms (success rate):\n",
+	"admin.stats.unsupported":    "unsupported links",
+	"admin.stats.plans":          "\nActive subscriptions:\n",
+	"admin.user":                 "👤 %s\nID: %d\nTelegram ID: %d\nLanguage: %s\nJoined: %s\nBanned: %s\n\nPlan: %s (%s), ends: %s\nDownloads: %d\n\nUsage:\n",
+	"admin.user.usage":           "• %s: %d requests, %d files, %s\n",
+	"admin.no":                   "no",
+	"admin.never":                "never",
+	"admin.banned_since":         "since %s",
+	"admin.unknown_plan":         "Unknown plan %s, available: %s",
+	"admin.granted":              "Granted plan %s to %s until %s.",
+	"admin.granted_forever":      "Granted plan %s to %s without end date.",
+	"admin.grant_notice":         "🎁 You got the %s plan until %s, see /plan.",
+	"admin.grant_notice_forever": "🎁 You got the %s plan, see /plan.",
+	"admin.cant_ban_admin":       "Admins can't be banned.",
+	"admin.banned":               "Banned %s, the bot ignores their messages.",
+	"admin.unbanned":             "Unbanned %s.",
+	"admin.usage_reset":          "Reset %d usage counters of %s.",
+	"admin.broadcast_running":    "Another broadcast is still being sent.",
+	"admin.broadcast_started":    "📣 Sending the broadcast, I will tell you when it is done.",
+	"admin.broadcast_done":       "📣 Broadcast done: %d sent, %d failed (e.g. blocked the bot).",
+	"admin.promo_invalid_code":   "Promo codes have up to 32 letters, digits, - and _.",
+	"admin.promo_not_found":      "No promo code %s.",
+	"admin.promo_exists":         "The promo code %s already exists.",
+	"admin.promo":                "🎟 %s\nPlan: %s, %s\nRedeemed: %d of %s\nExpires: %s\n",
+	"admin.promo.forever":        "no end",
+	"admin.promo.unlimited":      "unlimited",
+	"admin.promo.redemptions":    "\nLatest redemptions:\n",
+	"admin.promo.redemption":     "%s, %s\n",
+
+	// Quality picker
+	"variant.unknown":        "Unknown choice",
+	"variant.expired":        "This choice has expired, please send the link again",
+	"variant.already_chosen": "This quality was already chosen",
+	"variant.not_sender":     "Only the sender of the link can choose",
+	"variant.start_failed":   "Failed to start the download, please try again later",
+	"variant.audio_only":     "🎵 Audio only",
+
+	// Inline mode
+	"inline.not_allowed":  "Download not allowed",
+	"inline.failed":       "Failed to download",
+	"inline.rate_limited": "Too many downloads",
+	"inline.loading":      "Still loading…",
+	"inline.loading_hint": "The media is being downloaded, type a space to check again.",
+	"inline.media":        "Media",
+}
@@ -0,0 +1,90 @@
+// Package i18n translates user facing messages of the bot, including plural forms
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Language string
+
+const (
+	LanguageEnglish Language = "en"
+	LanguageRussian Language = "ru"
+)
+
+// DefaultLanguage is used for unsupported languages and messages missing in a catalog
+const DefaultLanguage = LanguageEnglish
+
+// catalogs contains the messages of every supported language by key.
+// Plural messages have a key per plural form of the language, e.g. "status.success.one" and "status.success.other".
+var catalogs = map[Language]map[string]string{
+	LanguageEnglish: en,
+	LanguageRussian: ru,
+}
+
+// Languages returns the supported languages, the default one first
+func Languages() []Language {
+	return []Language{LanguageEnglish, LanguageRussian}
+}
+
+// Localizer translates messages into a single language
+type Localizer struct {
+	language Language
+}
+
+// New returns a localizer for a language code like "ru" or "en-US", unsupported languages get the default one
+func New(languageCode string) *Localizer {
+	base, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+
+	language := Language(base)
+	if _, ok := catalogs[language]; !ok {
+		language = DefaultLanguage
+	}
+
+	return &Localizer{language: language}
+}
+
+func (l *Localizer) Language() Language {
+	return l.language
+}
+
+// T returns the message of a key formatted with args
+func (l *Localizer) T(key string, args ...any) string {
+	message, ok := lookup(l.language, key)
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// N returns the plural form of a message for n, formatted with n followed by args
+func (l *Localizer) N(key string, n int64, args ...any) string {
+	args = append([]any{n}, args...)
+
+	// A language missing the message uses the plural rules of the default language
+	for _, language := range []Language{l.language, DefaultLanguage} {
+		if message, ok := lookup(language, key+"."+string(getPluralForm(language, n))); ok {
+			return fmt.Sprintf(message, args...)
+		}
+		if message, ok := lookup(language, key+"."+string(pluralFormOther)); ok {
+			return fmt.Sprintf(message, args...)
+		}
+	}
+
+	return key
+}
+
+// lookup returns the message of a key in a language, or in the default language if missing
+func lookup(language Language, key string) (string, bool) {
+	if message, ok := catalogs[language][key]; ok {
+		return message, true
+	}
+
+	message, ok := catalogs[DefaultLanguage][key]
+	return message, ok
+}
@@ -0,0 +1,37 @@
+package i18n
+
+// pluralForm is a CLDR plural category, see https://cldr.unicode.org/index/cldr-spec/plural-rules
+type pluralForm string
+
+const (
+	pluralFormOne   pluralForm = "one"
+	pluralFormFew   pluralForm = "few"
+	pluralFormMany  pluralForm = "many"
+	pluralFormOther pluralForm = "other"
+)
+
+// getPluralForm returns the plural form of an integer count in a language
+func getPluralForm(language Language, n int64) pluralForm {
+	if n < 0 {
+		n = -n
+	}
+
+	switch language {
+	case LanguageRussian:
+		// 1, 21, 31 файл; 2-4, 22-24 файла; 0, 5-20, 25-30 файлов
+		mod10, mod100 := n%10, n%100
+		switch {
+		case mod10 == 1 && mod100 != 11:
+			return pluralFormOne
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return pluralFormFew
+		default:
+			return pluralFormMany
+		}
+	default:
+		if n == 1 {
+			return pluralFormOne
+		}
+		return pluralFormOther
+	}
+}
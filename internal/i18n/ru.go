@@ -0,0 +1,253 @@
+package i18n
+
+var ru = map[string]string{
+	// Command menu and /help
+	"command.start":      "Приветствие и поддерживаемые платформы",
+	"command.help":       "Как пользоваться ботом",
+	"command.dl":         "Скачать ссылку или ссылки из сообщения, на которое вы отвечаете",
+	"command.audio":      "Скачать только звуковую дорожку",
+	"command.file":       "Скачать оригиналы файлами",
+	"command.usage":      "Показать использование и лимиты",
+	"command.plan":       "Показать текущий тариф",
+	"command.history":    "Показать последние загрузки",
+	"command.settings":   "Изменить настройки",
+	"command.chat":       "Изменить настройки бота в группе (для админов)",
+	"command.stats":      "Загрузки и пользователи за последние дни",
+	"command.user":       "Найти пользователя",
+	"command.grant":      "Выдать тариф, 0 дней — бессрочно",
+	"command.ban":        "Игнорировать сообщения пользователя",
+	"command.unban":      "Снять бан с пользователя",
+	"command.resetusage": "Сбросить лимиты пользователя",
+	"command.broadcast":  "Отправить текст или сообщение из ответа всем пользователям",
+	"command.upgrade":    "Улучшить тариф за Telegram Stars",
+	"command.redeem":     "Активировать промокод",
+	"command.referrals":  "Пригласить друзей и получить бонусные загрузки",
+	"command.promo":      "Создать промокод или показать его активации",
+	"command.args.link":  "[ссылка]",
+	"command.args.days":  "[дни]",
+	"command.args.user":  "<id|@username>",
+	"command.args.grant": "<id|@username> <тариф> <дни>",
+	"command.args.text":  "<текст>",
+	"command.args.code":  "<код>",
+	"command.args.promo": "<код> [<тариф> <дни> [макс. активаций] [действует дней]]",
+	"command.no_links":   "Отправьте ссылку после команды или ответьте командой на сообщение со ссылкой.",
+	"start.greeting":     "👋 Привет, %s!\n\nОтправьте мне ссылку, и я скачаю медиа для вас.\n\nПоддерживаемые платформы:\n",
+	"start.footer":       "\nВсе команды: /help",
+	"help.intro":         "Отправьте одну или несколько ссылок, и я пришлю медиа в ответ.\n\nКоманды:\n",
+	"platform.instagram": "посты, рилсы и IGTV",
+	"platform.vk":        "видео и клипы",
+
+	// /usage and /plan
+	"usage.failed":                 "Не удалось загрузить использование, попробуйте позже.",
+	"usage.title":                  "📊 Использование на тарифе %s\n\n",
+	"usage.resets_in":              ", сброс через %s",
+	"usage.bonus":                  " (+%s бонус)",
+	"usage.no_features":            "В вашем тарифе нет функций.\n",
+	"usage.included":               "\nВключено: %s\n",
+	"usage.rate_limit":             "\nОграничение: %s в минуту, %s одновременно\n",
+	"feature.downloads":            "Загрузки",
+	"feature.high_quality":         "Высокое качество",
+	"feature.extract_audio":        "Извлечение аудио",
+	"feature.batch_download":       "Несколько ссылок в сообщении",
+	"feature.large_files":          "Большие файлы",
+	"feature.inline_mode":          "Инлайн-режим",
+	"feature.priority_queue":       "Приоритетная очередь",
+	"plan.failed":                  "Не удалось загрузить тариф, попробуйте позже.",
+	"plan.title":                   "💳 Тариф: %s\nСтатус: %s\n",
+	"plan.started":                 "Начало: %s\n",
+	"plan.ends_never":              "Окончание: никогда\n",
+	"plan.expired":                 "Истёк: %s\n",
+	"plan.ends":                    "Окончание: %s (через %s)\n",
+	"plan.cancels":                 "Отмена: %s\n",
+	"subscription.status.active":   "активна",
+	"subscription.status.canceled": "отменена",
+	"subscription.status.expired":  "истекла",
+	"subscription.status.trialing": "пробный период",
+	"subscription.reminder":        "⏰ Ваш тариф %s заканчивается %s (через %s). Продлите его через /upgrade, чтобы сохранить лимиты.",
+	"subscription.trial_reminder":  "⏰ Пробный период тарифа %s заканчивается %s (через %s). Оформите тариф через /upgrade, чтобы сохранить лимиты.",
+	"subscription.expired_notice":  "Срок тарифа %s истёк, теперь у вас тариф Free. Продлить: /upgrade.",
+	"subscription.canceled_notice": "Тариф %s отменён, теперь у вас тариф Free, см. /upgrade.",
+
+	// Durations in /usage and /plan
+	"duration.seconds.one":  "%d секунду",
+	"duration.seconds.few":  "%d секунды",
+	"duration.seconds.many": "%d секунд",
+	"duration.minutes.one":  "%d минуту",
+	"duration.minutes.few":  "%d минуты",
+	"duration.minutes.many": "%d минут",
+	"duration.hours.one":    "%d час",
+	"duration.hours.few":    "%d часа",
+	"duration.hours.many":   "%d часов",
+	"duration.days.one":     "%d день",
+	"duration.days.few":     "%d дня",
+	"duration.days.many":    "%d дней",
+
+	// Errors of download permission checks and downloads, by model.ErrorKind
+	"error.limit_exceeded":        "Вы достигли лимита загрузок вашего тарифа, см. /usage.",
+	"error.chat_limit_exceeded":   "Этот чат достиг лимита загрузок, попробуйте позже.",
+	"error.feature_not_available": "Загрузки недоступны на вашем тарифе, см. /plan.",
+	"error.feature_locked":        "Функция «%s» доступна на платных тарифах, см. /upgrade.",
+	"batch.locked":                "Загружается только первая ссылка, несколько ссылок в сообщении доступны на платных тарифах, см. /upgrade.",
+	"rate_limit.retry_after":      "🐢 Помедленнее! Пропущено ссылок: %s, отправьте их снова через %s.",
+	"rate_limit.running":          "🐢 Помедленнее! Пропущено ссылок: %s, отправьте их снова, когда текущие загрузки завершатся.",
+	"rate_limit.links.one":        "%d",
+	"rate_limit.links.few":        "%d",
+	"rate_limit.links.many":       "%d",
+	"rate_limit.try_again_in":     "🐢 Помедленнее! Попробуйте снова через %s.",
+	"rate_limit.try_again_later":  "🐢 Помедленнее! Попробуйте снова, когда текущие загрузки завершатся.",
+	"error.internal":              "Что-то пошло не так, попробуйте позже.",
+	"error.private_content":       "Это закрытый контент или нужен вход в аккаунт, скачать можно только публичные посты.",
+	"error.not_found":             "По ссылке ничего не найдено, проверьте её и что пост не удалён.",
+	"error.geo_blocked":           "Этот контент недоступен в регионе бота.",
+	"error.rate_limited":          "Слишком много запросов, попробуйте через несколько минут.",
+	"error.unsupported_url":       "Эта ссылка не поддерживается, поддерживаемые платформы: /start.",
+	"error.too_large":             "Файл слишком большой для Telegram, попробуйте качество пониже в /settings.",
+	"error.timeout":               "Платформа слишком долго не отвечает, попробуйте позже.",
+
+	// /settings and /chat
+	"callback.unknown_setting":  "Неизвестная настройка",
+	"callback.save_failed":      "Не удалось сохранить, попробуйте позже",
+	"callback.saved":            "Сохранено",
+	"settings.failed":           "Не удалось загрузить настройки, попробуйте позже.",
+	"settings.text":             "⚙️ Настройки\n\nКачество: %s\nРежим: %s\nПодпись: %s\nЯзык: %s",
+	"settings.quality.default":  "Спрашивать",
+	"settings.quality.high":     "Высокое",
+	"settings.quality.low":      "Низкое",
+	"settings.mode.media":       "Медиа",
+	"settings.mode.audio":       "Аудио",
+	"settings.mode.document":    "Файл",
+	"settings.caption.on":       "Вкл",
+	"settings.caption.off":      "Выкл",
+	"settings.language.default": "Авто",
+	"settings.language.en":      "English",
+	"settings.language.ru":      "Русский",
+	"chat.private":              "Используйте /chat в группе, чтобы настроить бота в ней.",
+	"chat.admins_only":          "Только админы этого чата могут менять его настройки.",
+	"chat.failed":               "Не удалось загрузить настройки чата, попробуйте позже.",
+	"chat.callback.admins_only": "Только админы могут менять настройки чата",
+	"chat.text":                 "⚙️ Настройки чата\n\nБот: %s\nЗагрузка: %s\nИспользование: %d/%s",
+	"chat.enabled.on":           "Вкл",
+	"chat.enabled.off":          "Выкл",
+	"chat.trigger.auto":         "Каждая ссылка",
+	"chat.trigger.mention":      "Упоминание",
+	"chat.trigger.command":      "Только /dl",
+
+	// Status message of a download
+	"status.line":             "%d. %s\nСостояние: %s",
+	"status.queued":           "⌛ в очереди...",
+	"status.getting_info":     "🔎 получаю информацию...",
+	"status.downloading":      "⬇️ скачиваю медиа %d/%d...%s",
+	"status.extracting_audio": "🎵 извлекаю аудио %d/%d...",
+	"status.sending":          "📲 отправляю медиа%s",
+	"status.success.one":      "✅ получен %d файл%s",
+	"status.success.few":      "✅ получено %d файла%s",
+	"status.success.many":     "✅ получено %d файлов%s",
+	"status.failed":           "❌ %s",
+	"status.send_failed":      "❌ не удалось отправить медиа: %s",
+	"status.choose_quality":   "🎚 выберите качество:",
+	"status.too_large":        "%d. %s\nФайл (%d) слишком большой для отправки (%.2f МБ). Прямая ссылка: %s",
+
+	// /history
+	"history.failed":      "Не удалось загрузить историю, попробуйте позже.",
+	"history.empty":       "У вас ещё нет загрузок, отправьте мне ссылку.",
+	"history.title":       "📜 Ваши загрузки, страница %d из %d\n\n",
+	"history.unavailable": "Эту загрузку нельзя отправить повторно",
+	"history.sending":     "Отправляю...",
+
+	// /upgrade and payments
+	"upgrade.private":             "Используйте /upgrade в личном чате со мной.",
+	"upgrade.failed":              "Не удалось загрузить цены, попробуйте позже.",
+	"upgrade.title":               "💎 Ваш тариф: %s\n\nВыберите тариф для оплаты в Telegram Stars, оплата текущего тарифа продлевает его.",
+	"upgrade.button":              "%s, %s: %s",
+	"upgrade.no_prices":           "Сейчас нечего купить.",
+	"upgrade.never_ends":          "Ваш тариф %s бессрочный, улучшать нечего.",
+	"upgrade.interval.monthly":    "1 месяц",
+	"upgrade.interval.yearly":     "1 год",
+	"upgrade.interval.lifetime":   "навсегда",
+	"upgrade.invoice_title":       "Тариф %s",
+	"upgrade.invoice_description": "Тариф %s, %s. Начинает действовать сразу после оплаты.",
+	"upgrade.invoice_failed":      "Не удалось создать счёт, попробуйте позже",
+	"payment.invalid":             "Этот счёт больше недействителен, используйте /upgrade ещё раз.",
+	"payment.success":             "🎉 Спасибо! Тариф %s действует до %s, см. /plan.",
+	"payment.success_forever":     "🎉 Спасибо! Тариф %s действует бессрочно, см. /plan.",
+	"payment.refunded":            "Не удалось применить оплату, звёзды возвращены. Попробуйте позже.",
+	"payment.failed":              "Не удалось применить оплату, напишите админам.",
+	"payment.refund_received":     "💸 Ваш платёж возвращён, оплаченное им время тарифа списано, см. /plan.",
+	"upgrade.checkout":            "Оплатите тариф %s (%s) на странице оплаты:",
+	"upgrade.pay_button":          "Оплатить %s",
+
+	// Trials and promo codes
+	"trial.started":           "🎁 Добро пожаловать! Вы бесплатно пробуете тариф %s на %s, до %s. См. /plan.",
+	"redeem.usage":            "Отправьте промокод после команды, например /redeem SPRING",
+	"redeem.not_found":        "Промокода %s не существует.",
+	"redeem.expired":          "Срок действия промокода %s истёк.",
+	"redeem.used_up":          "Промокод %s больше нельзя активировать.",
+	"redeem.already_redeemed": "Вы уже активировали промокод %s.",
+	"redeem.plan_paid":        "Тариф %s оплачен до %s, активируйте промокод %s после его окончания.",
+	"redeem.failed":           "Не удалось активировать промокод, попробуйте позже.",
+	"redeem.success":          "🎟 Промокод активирован! Тариф %s действует до %s, см. /plan.",
+	"redeem.success_forever":  "🎟 Промокод активирован! Тариф %s действует бессрочно, см. /plan.",
+
+	// /referrals
+	"referral.failed":               "Не удалось загрузить вашу ссылку-приглашение, попробуйте позже.",
+	"referral.title":                "🤝 Приглашайте друзей по ссылке:\n%s\n\nКогда приглашённый пользователь сделает первую загрузку, вы оба получите %s.\n\nПриглашено: %d, получено бонусов: %d",
+	"referral.bonus_downloads.one":  "%d дополнительную загрузку",
+	"referral.bonus_downloads.few":  "%d дополнительные загрузки",
+	"referral.bonus_downloads.many": "%d дополнительных загрузок",
+	"referral.bonus_days":           "%s тарифа %s",
+	"referral.bonus_and":            " и ",
+	"referral.bonus_none":           "нашу благодарность",
+	"referral.joined":               "🤝 По вашей ссылке присоединился пользователь %s. Вы оба получите %s после его первой загрузки.",
+	"referral.rewarded_referrer":    "🎉 Пользователь %s сделал первую загрузку, вы получили %s. См. /usage.",
+	"referral.rewarded_referee":     "🎉 Спасибо, что присоединились по приглашению, вы получили %s. См. /usage.",
+
+	// Admin commands
+	"admin.usage":                "Использование: /%s %s",
+	"admin.failed":               "Ошибка: %v",
+	"admin.user_not_found":       "Пользователь %s не найден, бот знает только тех, кто ему писал.",
+	"admin.stats":                "📈 За %s\n\nПользователи: %d (%d новых)\nЗагрузки: %d, успешно %d (%.1f%%), с ошибкой %d\nСреднее время: %.1f с\nОтправлено: %s\n",
+	"admin.stats.platforms":      "\nПлатформы (доля успешных):\n",
+	"admin.stats.unsupported":    "неподдерживаемые ссылки",
+	"admin.stats.plans":          "\nАктивные подписки:\n",
+	"admin.user":                 "👤 %s\nID: %d\nTelegram ID: %d\nЯзык: %s\nС нами с: %s\nБан: %s\n\nТариф: %s (%s), до: %s\nЗагрузки: %d\n\nИспользование:\n",
+	"admin.user.usage":           "• %s: запросов %d, файлов %d, %s\n",
+	"admin.no":                   "нет",
+	"admin.never":                "бессрочно",
+	"admin.banned_since":         "с %s",
+	"admin.unknown_plan":         "Неизвестный тариф %s, доступны: %s",
+	"admin.granted":              "Тариф %s выдан пользователю %s до %s.",
+	"admin.granted_forever":      "Тариф %s выдан пользователю %s бессрочно.",
+	"admin.grant_notice":         "🎁 Вам выдан тариф %s до %s, см. /plan.",
+	"admin.grant_notice_forever": "🎁 Вам выдан тариф %s, см. /plan.",
+	"admin.cant_ban_admin":       "Админов нельзя забанить.",
+	"admin.banned":               "%s забанен, бот игнорирует его сообщения.",
+	"admin.unbanned":             "%s разбанен.",
+	"admin.usage_reset":          "Сброшено счётчиков: %d у %s.",
+	"admin.broadcast_running":    "Предыдущая рассылка ещё отправляется.",
+	"admin.broadcast_started":    "📣 Отправляю рассылку, сообщу, когда закончу.",
+	"admin.broadcast_done":       "📣 Рассылка завершена: отправлено %d, не доставлено %d (например, бот заблокирован).",
+	"admin.promo_invalid_code":   "Промокод — до 32 букв, цифр, - и _.",
+	"admin.promo_not_found":      "Промокода %s нет.",
+	"admin.promo_exists":         "Промокод %s уже существует.",
+	"admin.promo":                "🎟 %s\nТариф: %s, %s\nАктивирован: %d из %s\nДействует до: %s\n",
+	"admin.promo.forever":        "бессрочно",
+	"admin.promo.unlimited":      "без ограничений",
+	"admin.promo.redemptions":    "\nПоследние активации:\n",
+	"admin.promo.redemption":     "%s, %s\n",
+
+	// Quality picker
+	"variant.unknown":        "Неизвестный выбор",
+	"variant.expired":        "Выбор устарел, отправьте ссылку ещё раз",
+	"variant.already_chosen": "Качество уже выбрано",
+	"variant.not_sender":     "Выбрать может только отправитель ссылки",
+	"variant.start_failed":   "Не удалось начать загрузку, попробуйте позже",
+	"variant.audio_only":     "🎵 Только аудио",
+
+	// Inline mode
+	"inline.not_allowed":  "Загрузка запрещена",
+	"inline.failed":       "Не удалось скачать",
+	"inline.rate_limited": "Слишком много загрузок",
+	"inline.loading":      "Ещё загружается…",
+	"inline.loading_hint": "Медиа скачивается, введите пробел, чтобы проверить снова.",
+	"inline.media":        "Медиа",
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind classifies a failure for users, the wrapped error with the details only goes to the logs
+type ErrorKind string
+
+const (
+	ErrorKindUnknown        ErrorKind = ""
+	ErrorKindPrivateContent ErrorKind = "private_content" // Requires login or following the author
+	ErrorKindNotFound       ErrorKind = "not_found"       // Deleted or mistyped
+	ErrorKindGeoBlocked     ErrorKind = "geo_blocked"     // Not available in the country of the server
+	ErrorKindRateLimited    ErrorKind = "rate_limited"    // The platform or Telegram throttles requests
+	ErrorKindUnsupportedURL ErrorKind = "unsupported_url" // No saver accepts the url
+	ErrorKindTooLarge       ErrorKind = "too_large"       // The media can't be sent to Telegram
+	ErrorKindLimitExceeded  ErrorKind = "limit_exceeded"  // Usage limit of the plan or chat reached
+	ErrorKindTimeout        ErrorKind = "timeout"         // The platform did not respond in time
+)
+
+// Error is an error of a known kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func NewError(kind ErrorKind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Errorf formats an error of a kind, %w wraps like fmt.Errorf
+func Errorf(kind ErrorKind, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GetErrorKind returns the kind of the first typed error in the chain, canceled or expired contexts are timeouts
+func GetErrorKind(err error) ErrorKind {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Kind
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorKindTimeout
+	}
+
+	return ErrorKindUnknown
+}
+
+// IsRetryable reports whether trying again can succeed, e.g. private or deleted content stays so
+func IsRetryable(err error) bool {
+	switch GetErrorKind(err) {
+	case ErrorKindUnknown, ErrorKindTimeout, ErrorKindRateLimited:
+		return true
+	default:
+		return false
+	}
+}
@@ -5,4 +5,13 @@ type Feature int
 
 const (
 	FeatureGetMedia Feature = iota
+	// Gated features are plan features without a limit, a plan either has them or not
+	FeatureHighQuality   // Best rendition of videos and photos, low quality otherwise
+	FeatureExtractAudio  // Audio mode and the audio button of the quality picker
+	FeatureBatchDownload // More than one link per message
+	FeatureLargeFiles    // Files above the public Bot API limit through the self-hosted server
+	FeatureInlineMode    // Downloads in inline queries
+	FeaturePriorityQueue // Downloads skip the queue when every slot is busy
+	// The limit of the burst allowance is added to the downloads per minute of the rate limit
+	FeatureRateLimitBurst
 )
@@ -0,0 +1,8 @@
+package model
+
+// Usage is an amount of a feature used, plans meter a feature by one of its fields
+type Usage struct {
+	Requests int64
+	Items    int64 // Files delivered, e.g. the photos of a carousel
+	Bytes    int64
+}
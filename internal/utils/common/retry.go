@@ -7,8 +7,9 @@ import (
 
 // RetryConfig defines the configuration for the retry logic.
 type RetryConfig struct {
-	Attempts int
-	Delay    time.Duration
+	Attempts    int
+	Delay       time.Duration
+	ShouldRetry func(err error) bool // Optional, every error is retried if nil
 }
 
 // DoWithRetry retries the provided function according to the config.
@@ -18,7 +19,7 @@ func DoWithRetry(cfg RetryConfig, fn func() error) (err error) {
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					err = fmt.Errorf("panic recovered: %v", r)
+					err = recoveredError(r)
 				}
 			}()
 
@@ -29,6 +30,10 @@ func DoWithRetry(cfg RetryConfig, fn func() error) (err error) {
 			return nil
 		}
 
+		if cfg.ShouldRetry != nil && !cfg.ShouldRetry(err) {
+			return err
+		}
+
 		if i < cfg.Attempts-1 {
 			time.Sleep(cfg.Delay)
 		}
@@ -46,7 +51,7 @@ func DoWithRetryAndReturn[T any](cfg RetryConfig, fn func() (T, error)) (T, erro
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					err = fmt.Errorf("panic recovered: %v", r)
+					err = recoveredError(r)
 				}
 			}()
 
@@ -57,6 +62,10 @@ func DoWithRetryAndReturn[T any](cfg RetryConfig, fn func() (T, error)) (T, erro
 			return result, nil
 		}
 
+		if cfg.ShouldRetry != nil && !cfg.ShouldRetry(err) {
+			return result, err
+		}
+
 		if i < cfg.Attempts-1 {
 			time.Sleep(cfg.Delay)
 		}
@@ -64,3 +73,11 @@ func DoWithRetryAndReturn[T any](cfg RetryConfig, fn func() (T, error)) (T, erro
 
 	return result, err
 }
+
+// recoveredError returns a recovered panic as error, panics with an error value (e.g. of rod Must* methods) stay unwrappable
+func recoveredError(r any) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("panic recovered: %w", err)
+	}
+	return fmt.Errorf("panic recovered: %v", r)
+}
@@ -149,34 +149,7 @@ func ExtractFilenameFromContentType(contentType string) string {
 		return ""
 	}
 
-	// Map common media types to file extensions
-	extensions := map[string]string{
-		"video/mp4":                    ".mp4",
-		"video/webm":                   ".webm",
-		"video/ogg":                    ".ogv",
-		"video/avi":                    ".avi",
-		"video/quicktime":              ".mov",
-		"video/x-msvideo":              ".avi",
-		"video/x-ms-wmv":               ".wmv",
-		"video/x-flv":                  ".flv",
-		"video/3gpp":                   ".3gp",
-		"video/3gpp2":                  ".3g2",
-		"video/x-matroska":             ".mkv",
-		"image/jpeg":                   ".jpg",
-		"image/png":                    ".png",
-		"image/gif":                    ".gif",
-		"image/webp":                   ".webp",
-		"image/svg+xml":                ".svg",
-		"audio/mpeg":                   ".mp3",
-		"audio/ogg":                    ".ogg",
-		"audio/wav":                    ".wav",
-		"audio/webm":                   ".weba",
-		"application/pdf":              ".pdf",
-		"application/zip":              ".zip",
-		"application/x-rar-compressed": ".rar",
-	}
-
-	if ext, ok := extensions[mediaType]; ok {
+	if ext := ExtensionFromContentType(mediaType); ext != "" {
 		return fmt.Sprintf("file%s", ext)
 	}
 
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/codeonbeans/botfetchr/internal/model"
 )
 
 // GetFileSize retrieves the file size in bytes from a given URL.
@@ -62,8 +64,8 @@ func GetFileSize(url string) (int64, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	if err = CheckStatus(url, resp); err != nil {
+		return 0, err
 	}
 
 	// Check Content-Length from GET response
@@ -83,7 +85,7 @@ func readContentSize(body io.ReadCloser) (int64, error) {
 	}
 
 	if bytesRead == maxSize {
-		return bytesRead, fmt.Errorf("file size exceeds 500MB limit (downloaded: %s)", ByteCountBinary(bytesRead))
+		return bytesRead, model.Errorf(model.ErrorKindTooLarge, "file size exceeds 500MB limit (downloaded: %s)", ByteCountBinary(bytesRead))
 	}
 
 	return bytesRead, nil
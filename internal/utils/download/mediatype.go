@@ -0,0 +1,187 @@
+package download
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is the number of leading bytes DetectContentType looks at
+const SniffLen = 512
+
+// Media types a download can be sent as
+const (
+	MediaTypePhoto     = "photo"
+	MediaTypeVideo     = "video"
+	MediaTypeAnimation = "animation"
+	MediaTypeDocument  = "document"
+)
+
+// Map common media types to file extensions
+var contentTypeExtensions = map[string]string{
+	"video/mp4":                    ".mp4",
+	"video/webm":                   ".webm",
+	"video/ogg":                    ".ogv",
+	"video/avi":                    ".avi",
+	"video/quicktime":              ".mov",
+	"video/x-msvideo":              ".avi",
+	"video/x-ms-wmv":               ".wmv",
+	"video/x-flv":                  ".flv",
+	"video/3gpp":                   ".3gp",
+	"video/3gpp2":                  ".3g2",
+	"video/x-matroska":             ".mkv",
+	"image/jpeg":                   ".jpg",
+	"image/png":                    ".png",
+	"image/gif":                    ".gif",
+	"image/webp":                   ".webp",
+	"image/heic":                   ".heic",
+	"image/heif":                   ".heif",
+	"image/avif":                   ".avif",
+	"image/svg+xml":                ".svg",
+	"audio/mpeg":                   ".mp3",
+	"audio/mp4":                    ".m4a",
+	"audio/ogg":                    ".ogg",
+	"audio/wav":                    ".wav",
+	"audio/webm":                   ".weba",
+	"application/pdf":              ".pdf",
+	"application/zip":              ".zip",
+	"application/x-rar-compressed": ".rar",
+}
+
+// Brands of the ISO base media file format (ftyp box) that are still images rather than videos
+var heifBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"hevc": "image/heic",
+	"hevx": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"avif": "image/avif",
+	"avis": "image/avif",
+}
+
+// Major brands of the ISO base media file format that are audio only (iTunes audio, audiobooks and Flash audio)
+var audioBrands = map[string]string{
+	"m4a ": "audio/mp4",
+	"m4b ": "audio/mp4",
+	"m4p ": "audio/mp4",
+	"f4a ": "audio/mp4",
+	"f4b ": "audio/mp4",
+}
+
+// ExtensionFromContentType returns the file extension (with dot) of a media type, or "" if unknown
+func ExtensionFromContentType(mediaType string) string {
+	return contentTypeExtensions[mediaType]
+}
+
+// DetectContentType determines the media type of a download from its first bytes,
+// falling back to the Content-Type header sent by the server if the content is not recognized
+func DetectContentType(head []byte, contentTypeHeader string) string {
+	if sniffed := sniffContentType(head); sniffed != "" {
+		return sniffed
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentTypeHeader); err == nil && !isGenericContentType(mediaType) {
+		return mediaType
+	}
+
+	return "application/octet-stream"
+}
+
+// DetectMediaType determines how a file should be sent to Telegram from its content type,
+// falling back to the filename extension if the content type is generic
+//
+// Returns one of MediaTypePhoto, MediaTypeVideo, MediaTypeAnimation or MediaTypeDocument
+func DetectMediaType(contentType string, head []byte, filename string) string {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/heic", "image/heif", "image/avif":
+		return MediaTypePhoto
+	case "image/webp":
+		// Telegram can't show animated WebP as photo or animation
+		if IsAnimatedWebP(head) {
+			return MediaTypeDocument
+		}
+		return MediaTypePhoto
+	case "image/gif":
+		return MediaTypeAnimation
+	case "video/mp4", "video/quicktime":
+		return MediaTypeVideo
+	}
+
+	if !isGenericContentType(contentType) {
+		return MediaTypeDocument
+	}
+
+	switch DetectFileType(filename) {
+	case "photo":
+		return MediaTypePhoto
+	case "video":
+		return MediaTypeVideo
+	default:
+		return MediaTypeDocument
+	}
+}
+
+// NeedsJPEGConversion reports whether a photo of this content type must be converted before Telegram accepts it
+func NeedsJPEGConversion(contentType string) bool {
+	switch contentType {
+	case "image/heic", "image/heif", "image/avif", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAnimatedWebP checks the animation flag of an extended (VP8X) WebP header
+func IsAnimatedWebP(head []byte) bool {
+	// RIFF....WEBPVP8X followed by chunk size (4 bytes) and flags, animation is bit 1
+	if len(head) < 21 || !bytes.Equal(head[12:16], []byte("VP8X")) {
+		return false
+	}
+	return head[20]&0x02 != 0
+}
+
+func sniffContentType(head []byte) string {
+	// ISO base media files (mp4, m4a, mov, heic, avif) start with an ftyp box: size (4 bytes), "ftyp", major brand
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		brand := strings.ToLower(string(head[8:12]))
+		if mediaType, ok := heifBrands[brand]; ok {
+			return mediaType
+		}
+
+		// Major brand can be generic (e.g. isom), check compatible brands as well
+		boxSize := int(binary.BigEndian.Uint32(head[0:4]))
+		for i := 16; i+4 <= min(boxSize, len(head)); i += 4 {
+			if mediaType, ok := heifBrands[strings.ToLower(string(head[i:i+4]))]; ok {
+				return mediaType
+			}
+		}
+
+		// Only the major brand tells audio apart, videos often list M4A among their compatible brands
+		if mediaType, ok := audioBrands[brand]; ok {
+			return mediaType
+		}
+		if brand == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil || isGenericContentType(mediaType) {
+		return ""
+	}
+
+	return mediaType
+}
+
+func isGenericContentType(mediaType string) bool {
+	return mediaType == "" ||
+		mediaType == "application/octet-stream" ||
+		mediaType == "binary/octet-stream" ||
+		mediaType == "text/plain"
+}
@@ -0,0 +1,44 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SpoolToFile copies the content of r into a new file inside dir and returns its absolute path and size.
+//
+// The file is created with a random prefix, so concurrent downloads with the same filename never collide.
+// The caller owns the returned file and must remove it once it is no longer needed.
+func SpoolToFile(dir, filename string, r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, "*_"+filepath.Base(filename))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, fmt.Errorf("failed to write spool file %s: %w", f.Name(), err)
+	}
+
+	// The api server reads the file by path, so it must be readable by other users as well
+	if err = f.Chmod(0644); err != nil {
+		os.Remove(f.Name())
+		return "", 0, fmt.Errorf("failed to chmod spool file %s: %w", f.Name(), err)
+	}
+
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, fmt.Errorf("failed to resolve spool file path: %w", err)
+	}
+
+	return path, size, nil
+}
@@ -0,0 +1,33 @@
+package download
+
+import (
+	"net/http"
+
+	"github.com/codeonbeans/botfetchr/internal/model"
+)
+
+// CheckStatus returns an error of the kind matching a failed response, nil for 200 OK
+func CheckStatus(url string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	return model.Errorf(getStatusErrorKind(resp.StatusCode), "failed to download %s: HTTP %s", url, resp.Status)
+}
+
+// getStatusErrorKind leaves 401 and 403 unknown so they are retried, direct media URLs are signed and expire
+// and say nothing about the post being private. Private content is detected on the page of the post.
+func getStatusErrorKind(statusCode int) model.ErrorKind {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return model.ErrorKindNotFound
+	case http.StatusTooManyRequests:
+		return model.ErrorKindRateLimited
+	case http.StatusUnavailableForLegalReasons:
+		return model.ErrorKindGeoBlocked
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return model.ErrorKindTimeout
+	default:
+		return model.ErrorKindUnknown
+	}
+}
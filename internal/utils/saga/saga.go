@@ -2,16 +2,21 @@ package saga
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/codeonbeans/botfetchr/internal/logger"
 )
 
+// ErrStop is wrapped by the error of a step that ends the Saga early without failing it, e.g. to wait for input.
+// Completed steps are not compensated.
+var ErrStop = errors.New("saga stopped")
+
 // SagaStep defines a single step in a Saga.
 type SagaStep struct {
 	Name       string
 	Action     func(ctx context.Context) error
-	Compensate func(ctx context.Context) error
+	Compensate func(ctx context.Context) error // Nil for steps with nothing to undo
 }
 
 // Saga defines the sequence of Saga steps.
@@ -42,10 +47,19 @@ func (s *Saga) Execute(ctx context.Context) error {
 
 	for _, step := range s.steps {
 		if err := step.Action(ctx); err != nil {
+			if errors.Is(err, ErrStop) {
+				logger.Log.Info(fmt.Sprintf("Saga stopped at step '%s': %v", step.Name, err))
+				return err
+			}
+
 			logger.Log.Error(fmt.Sprintf("Saga step '%s' failed: %v", step.Name, err))
 
 			// Rollback in reverse order
 			for i := len(completed) - 1; i >= 0; i-- {
+				if completed[i].Compensate == nil {
+					continue
+				}
+
 				logger.Log.Info(fmt.Sprintf("Compensating step '%s' due to failure in step '%s'", completed[i].Name, step.Name))
 				compErr := completed[i].Compensate(ctx)
 				if compErr != nil {
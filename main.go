@@ -7,6 +7,9 @@ import (
 	"github.com/codeonbeans/botfetchr/internal/client/pgxpool"
 	"github.com/codeonbeans/botfetchr/internal/logger"
 	"github.com/codeonbeans/botfetchr/internal/storage"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/eko/gocache/lib/v4/cache"
@@ -63,10 +66,16 @@ func main() {
 
 	store := storage.NewStorage(db)
 
-	b, err := tgbot.New(store, cacheManager)
+	b, err := tgbot.New(store, cacheManager, redisClient)
 	if err != nil {
 		panic(err)
 	}
 
-	b.Start(context.Background())
+	// Stop on SIGTERM from Kubernetes or Ctrl+C, running webhook requests are finished first
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err = b.Start(ctx); err != nil {
+		logger.Log.Sugar().Fatalf("Bot stopped: %v", err)
+	}
 }
@@ -0,0 +1,31 @@
+-- +goose Up
+-- CreateTable
+CREATE TABLE "account"."settings"
+(
+  "id"            BIGSERIAL   NOT NULL,
+  "account_id"    BIGINT      NOT NULL,
+  "quality"       VARCHAR(8),
+  "download_mode" VARCHAR(16) NOT NULL DEFAULT 'media',
+  "caption"       BOOLEAN     NOT NULL DEFAULT true,
+  "language_code" VARCHAR(5),
+  "updated_at"    TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "settings_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "settings_account_id_key" ON "account"."settings" ("account_id");
+
+-- AddForeignKey
+ALTER TABLE "account"."settings"
+  ADD CONSTRAINT "settings_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- +goose Down
+-- RemoveForeignKey
+ALTER TABLE "account"."settings" DROP CONSTRAINT "settings_account_id_fkey";
+
+-- DropIndex
+DROP INDEX "account"."settings_account_id_key";
+
+-- DropTable
+DROP TABLE "account"."settings";
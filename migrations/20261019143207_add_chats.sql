@@ -0,0 +1,32 @@
+-- +goose Up
+-- CreateTable
+CREATE TABLE "account"."chats"
+(
+  "id"           BIGSERIAL    NOT NULL,
+  "chat_id"      BIGINT       NOT NULL,
+  "type"         VARCHAR(16)  NOT NULL,
+  "title"        VARCHAR(255) NOT NULL DEFAULT '',
+  "enabled"      BOOLEAN      NOT NULL DEFAULT true,
+  "trigger_mode" VARCHAR(16)  NOT NULL DEFAULT 'auto',
+  "usage"        BIGINT       NOT NULL DEFAULT 0,
+  "reset_at"     TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "created_at"   TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "chats_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "chats_chat_id_key" ON "account"."chats" ("chat_id");
+
+-- CreateIndex
+CREATE INDEX "chats_type_idx" ON "account"."chats" ("type");
+
+-- +goose Down
+-- DropIndex
+DROP INDEX "account"."chats_type_idx";
+
+-- DropIndex
+DROP INDEX "account"."chats_chat_id_key";
+
+-- DropTable
+DROP TABLE "account"."chats";
@@ -0,0 +1,59 @@
+-- +goose Up
+-- CreateSchema
+CREATE SCHEMA IF NOT EXISTS "media";
+
+-- CreateEnum
+CREATE TYPE "media"."request_statuses" AS ENUM ('PROCESSING', 'SUCCEEDED', 'FAILED');
+
+-- CreateTable
+CREATE TABLE "media"."requests"
+(
+  "id"             BIGSERIAL    NOT NULL,
+  "account_id"     BIGINT,
+  "chat_id"        BIGINT       NOT NULL,
+  "url"            TEXT         NOT NULL,
+  "saver_type"     VARCHAR(16)  NOT NULL DEFAULT '',
+  "mode"           VARCHAR(16)  NOT NULL DEFAULT 'media',
+  "status"         "media"."request_statuses" NOT NULL DEFAULT 'PROCESSING',
+  "error_kind"     VARCHAR(32),
+  "title"          VARCHAR(255) NOT NULL DEFAULT '',
+  "author"         VARCHAR(255) NOT NULL DEFAULT '',
+  "size"           BIGINT       NOT NULL DEFAULT 0,
+  "media_count"    INTEGER      NOT NULL DEFAULT 0,
+  "media_duration" INTEGER      NOT NULL DEFAULT 0,
+  "duration_ms"    BIGINT       NOT NULL DEFAULT 0,
+  "files"          JSONB        NOT NULL DEFAULT '[]',
+  "created_at"     TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "finished_at"    TIMESTAMPTZ(3),
+
+  CONSTRAINT "requests_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "requests_account_id_created_at_idx" ON "media"."requests" ("account_id", "created_at" DESC);
+
+-- CreateIndex
+CREATE INDEX "requests_status_idx" ON "media"."requests" ("status");
+
+-- AddForeignKey
+ALTER TABLE "media"."requests"
+  ADD CONSTRAINT "requests_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- +goose Down
+-- DropForeignKey
+ALTER TABLE "media"."requests" DROP CONSTRAINT "requests_account_id_fkey";
+
+-- DropIndex
+DROP INDEX "media"."requests_status_idx";
+
+-- DropIndex
+DROP INDEX "media"."requests_account_id_created_at_idx";
+
+-- DropTable
+DROP TABLE "media"."requests";
+
+-- DropEnum
+DROP TYPE "media"."request_statuses";
+
+-- DropSchema
+DROP SCHEMA IF EXISTS "media";
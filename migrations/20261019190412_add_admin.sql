@@ -0,0 +1,21 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "account"."telegrams"
+  ADD COLUMN "banned_at" TIMESTAMPTZ(3);
+
+-- CreateIndex
+CREATE INDEX "telegrams_username_lower_idx" ON "account"."telegrams" (LOWER("username"));
+
+-- CreateIndex
+CREATE INDEX "requests_created_at_idx" ON "media"."requests" ("created_at");
+
+-- +goose Down
+-- DropIndex
+DROP INDEX "media"."requests_created_at_idx";
+
+-- DropIndex
+DROP INDEX "account"."telegrams_username_lower_idx";
+
+-- AlterTable
+ALTER TABLE "account"."telegrams"
+  DROP COLUMN "banned_at";
@@ -0,0 +1,7 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- AlterEnum
+ALTER TYPE "subscription"."plan_intervals" ADD VALUE IF NOT EXISTS 'LIFETIME';
+
+-- +goose Down
+-- Values can't be removed from an enum, LIFETIME stays unused
@@ -0,0 +1,66 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  ALTER COLUMN "subscription_id" DROP NOT NULL,
+  ADD COLUMN "account_id"                 BIGINT,
+  ADD COLUMN "plan_price_id"              BIGINT,
+  ADD COLUMN "currency"                   VARCHAR(3) NOT NULL DEFAULT 'XTR',
+  ADD COLUMN "telegram_payment_charge_id" TEXT,
+  ADD COLUMN "provider_payment_charge_id" TEXT,
+  ADD COLUMN "paid_at"                    TIMESTAMPTZ(3);
+
+-- CreateIndex
+CREATE INDEX "invoices_account_id_idx" ON "subscription"."invoices" ("account_id");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "invoices_telegram_payment_charge_id_key" ON "subscription"."invoices" ("telegram_payment_charge_id");
+
+-- AddForeignKey
+ALTER TABLE "subscription"."invoices"
+  ADD CONSTRAINT "invoices_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "subscription"."invoices"
+  ADD CONSTRAINT "invoices_plan_price_id_fkey" FOREIGN KEY ("plan_price_id") REFERENCES "subscription"."plan_prices" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- +goose StatementBegin
+-- Insert initial prices in Telegram Stars
+INSERT INTO "subscription"."plan_prices" (plan_id, price, interval)
+VALUES ('PlanPro', 100, 'MONTHLY'),
+       ('PlanPro', 1000, 'YEARLY'),
+       ('PlanLifetime', 2500, 'LIFETIME');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE
+FROM "subscription"."plan_prices"
+WHERE (plan_id, interval) IN (('PlanPro', 'MONTHLY'), ('PlanPro', 'YEARLY'), ('PlanLifetime', 'LIFETIME'));
+-- +goose StatementEnd
+
+-- RemoveForeignKey
+ALTER TABLE "subscription"."invoices" DROP CONSTRAINT "invoices_plan_price_id_fkey";
+
+-- RemoveForeignKey
+ALTER TABLE "subscription"."invoices" DROP CONSTRAINT "invoices_account_id_fkey";
+
+-- DropIndex
+DROP INDEX "subscription"."invoices_telegram_payment_charge_id_key";
+
+-- DropIndex
+DROP INDEX "subscription"."invoices_account_id_idx";
+
+-- Invoices of payments have no subscription until they are paid
+DELETE
+FROM "subscription"."invoices"
+WHERE subscription_id IS NULL;
+
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  DROP COLUMN "paid_at",
+  DROP COLUMN "provider_payment_charge_id",
+  DROP COLUMN "telegram_payment_charge_id",
+  DROP COLUMN "currency",
+  DROP COLUMN "plan_price_id",
+  DROP COLUMN "account_id",
+  ALTER COLUMN "subscription_id" SET NOT NULL;
@@ -0,0 +1,37 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  RENAME COLUMN "telegram_payment_charge_id" TO "charge_id";
+
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  ADD COLUMN "provider"    TEXT NOT NULL DEFAULT 'stars',
+  ADD COLUMN "checkout_id" TEXT,
+  ADD COLUMN "refunded_at" TIMESTAMPTZ(3),
+  ADD COLUMN "extended_from" TIMESTAMPTZ(3),
+  ADD COLUMN "extended_to"   TIMESTAMPTZ(3);
+
+-- DropIndex
+DROP INDEX "subscription"."invoices_telegram_payment_charge_id_key";
+
+-- CreateIndex
+CREATE UNIQUE INDEX "invoices_provider_charge_id_key" ON "subscription"."invoices" ("provider", "charge_id");
+
+-- +goose Down
+-- DropIndex
+DROP INDEX "subscription"."invoices_provider_charge_id_key";
+
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  DROP COLUMN "extended_to",
+  DROP COLUMN "extended_from",
+  DROP COLUMN "refunded_at",
+  DROP COLUMN "checkout_id",
+  DROP COLUMN "provider";
+
+-- AlterTable
+ALTER TABLE "subscription"."invoices"
+  RENAME COLUMN "charge_id" TO "telegram_payment_charge_id";
+
+-- CreateIndex
+CREATE UNIQUE INDEX "invoices_telegram_payment_charge_id_key" ON "subscription"."invoices" ("telegram_payment_charge_id");
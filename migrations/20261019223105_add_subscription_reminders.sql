@@ -0,0 +1,15 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "subscription"."subscriptions"
+  ADD COLUMN "reminded_at" TIMESTAMPTZ(3);
+
+-- CreateIndex
+CREATE INDEX "subscriptions_status_end_date_idx" ON "subscription"."subscriptions" ("status", "end_date");
+
+-- +goose Down
+-- DropIndex
+DROP INDEX "subscription"."subscriptions_status_end_date_idx";
+
+-- AlterTable
+ALTER TABLE "subscription"."subscriptions"
+  DROP COLUMN "reminded_at";
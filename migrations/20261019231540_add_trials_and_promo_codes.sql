@@ -0,0 +1,79 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "account"."telegrams"
+  ADD COLUMN "trial_used_at" TIMESTAMPTZ(3);
+
+-- CreateTable
+CREATE TABLE "subscription"."promo_codes"
+(
+  "id"              BIGSERIAL   NOT NULL,
+  "code"            VARCHAR(32) NOT NULL,
+  "plan_id"         TEXT        NOT NULL,
+  "days"            INTEGER     NOT NULL DEFAULT 0,
+  "max_redemptions" INTEGER     NOT NULL DEFAULT 0,
+  "redemptions"     INTEGER     NOT NULL DEFAULT 0,
+  "expires_at"      TIMESTAMPTZ(3),
+  "created_at"      TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "promo_codes_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "subscription"."promo_code_redemptions"
+(
+  "id"              BIGSERIAL NOT NULL,
+  "promo_code_id"   BIGINT    NOT NULL,
+  "account_id"      BIGINT    NOT NULL,
+  "subscription_id" TEXT,
+  "redeemed_at"     TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "promo_code_redemptions_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "promo_codes_code_key" ON "subscription"."promo_codes" ("code");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "promo_code_redemptions_promo_code_id_account_id_key" ON "subscription"."promo_code_redemptions" ("promo_code_id", "account_id");
+
+-- CreateIndex
+CREATE INDEX "promo_code_redemptions_account_id_idx" ON "subscription"."promo_code_redemptions" ("account_id");
+
+-- AddForeignKey
+ALTER TABLE "subscription"."promo_codes"
+  ADD CONSTRAINT "promo_codes_plan_id_fkey" FOREIGN KEY ("plan_id") REFERENCES "subscription"."plans" ("id") ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions"
+  ADD CONSTRAINT "promo_code_redemptions_promo_code_id_fkey" FOREIGN KEY ("promo_code_id") REFERENCES "subscription"."promo_codes" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions"
+  ADD CONSTRAINT "promo_code_redemptions_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions"
+  ADD CONSTRAINT "promo_code_redemptions_subscription_id_fkey" FOREIGN KEY ("subscription_id") REFERENCES "subscription"."subscriptions" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- +goose Down
+-- DropForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions" DROP CONSTRAINT "promo_code_redemptions_subscription_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions" DROP CONSTRAINT "promo_code_redemptions_account_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "subscription"."promo_code_redemptions" DROP CONSTRAINT "promo_code_redemptions_promo_code_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "subscription"."promo_codes" DROP CONSTRAINT "promo_codes_plan_id_fkey";
+
+-- DropTable
+DROP TABLE "subscription"."promo_code_redemptions";
+
+-- DropTable
+DROP TABLE "subscription"."promo_codes";
+
+-- AlterTable
+ALTER TABLE "account"."telegrams"
+  DROP COLUMN "trial_used_at";
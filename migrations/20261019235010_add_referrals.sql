@@ -0,0 +1,67 @@
+-- +goose Up
+-- AlterTable
+ALTER TABLE "account"."usage"
+  ADD COLUMN "bonus" BIGINT NOT NULL DEFAULT 0;
+
+-- CreateTable
+CREATE TABLE "account"."referral_codes"
+(
+  "account_id" BIGINT      NOT NULL,
+  "code"       VARCHAR(16) NOT NULL,
+  "created_at" TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "referral_codes_pkey" PRIMARY KEY ("account_id")
+);
+
+-- CreateTable
+CREATE TABLE "account"."referrals"
+(
+  "id"          BIGSERIAL NOT NULL,
+  "referrer_id" BIGINT    NOT NULL,
+  "referee_id"  BIGINT    NOT NULL,
+  "created_at"  TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "rewarded_at" TIMESTAMPTZ(3),
+
+  CONSTRAINT "referrals_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE UNIQUE INDEX "referral_codes_code_key" ON "account"."referral_codes" ("code");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "referrals_referee_id_key" ON "account"."referrals" ("referee_id");
+
+-- CreateIndex
+CREATE INDEX "referrals_referrer_id_idx" ON "account"."referrals" ("referrer_id");
+
+-- AddForeignKey
+ALTER TABLE "account"."referral_codes"
+  ADD CONSTRAINT "referral_codes_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "account"."referrals"
+  ADD CONSTRAINT "referrals_referrer_id_fkey" FOREIGN KEY ("referrer_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "account"."referrals"
+  ADD CONSTRAINT "referrals_referee_id_fkey" FOREIGN KEY ("referee_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- +goose Down
+-- DropForeignKey
+ALTER TABLE "account"."referrals" DROP CONSTRAINT "referrals_referee_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "account"."referrals" DROP CONSTRAINT "referrals_referrer_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "account"."referral_codes" DROP CONSTRAINT "referral_codes_account_id_fkey";
+
+-- DropTable
+DROP TABLE "account"."referrals";
+
+-- DropTable
+DROP TABLE "account"."referral_codes";
+
+-- AlterTable
+ALTER TABLE "account"."usage"
+  DROP COLUMN "bonus";
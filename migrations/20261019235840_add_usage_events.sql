@@ -0,0 +1,74 @@
+-- +goose Up
+-- CreateEnum
+CREATE TYPE "subscription"."meters" AS ENUM ('REQUESTS', 'ITEMS', 'BYTES');
+
+-- AlterTable
+ALTER TABLE "subscription"."plan_features"
+  ADD COLUMN "meter" "subscription"."meters" NOT NULL DEFAULT 'REQUESTS';
+
+-- CreateTable
+CREATE TABLE "account"."usage_events"
+(
+  "id"         BIGSERIAL NOT NULL,
+  "account_id" BIGINT    NOT NULL,
+  "feature"    TEXT      NOT NULL,
+  "amount"     BIGINT    NOT NULL DEFAULT 0,
+  "items"      BIGINT    NOT NULL DEFAULT 0,
+  "bytes"      BIGINT    NOT NULL DEFAULT 0,
+  "request_id" BIGINT,
+  "created_at" TIMESTAMPTZ(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT "usage_events_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateIndex
+CREATE INDEX "usage_events_account_id_feature_created_at_idx" ON "account"."usage_events" ("account_id", "feature", "created_at");
+
+-- CreateIndex
+CREATE INDEX "usage_events_request_id_idx" ON "account"."usage_events" ("request_id");
+
+-- AddForeignKey
+ALTER TABLE "account"."usage_events"
+  ADD CONSTRAINT "usage_events_account_id_fkey" FOREIGN KEY ("account_id") REFERENCES "account"."telegrams" ("id") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- AddForeignKey
+ALTER TABLE "account"."usage_events"
+  ADD CONSTRAINT "usage_events_request_id_fkey" FOREIGN KEY ("request_id") REFERENCES "media"."requests" ("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- Usage of the current periods is carried over as one event each
+INSERT INTO "account"."usage_events" (account_id, feature, amount, items, created_at)
+SELECT account_id, feature, usage, usage, reset_at
+FROM "account"."usage"
+WHERE usage <> 0;
+
+-- AlterTable
+ALTER TABLE "account"."usage"
+  DROP COLUMN "usage";
+
+-- +goose Down
+-- AlterTable
+ALTER TABLE "account"."usage"
+  ADD COLUMN "usage" BIGINT NOT NULL DEFAULT 0;
+
+UPDATE "account"."usage" u
+SET usage = GREATEST((SELECT COALESCE(SUM(e.amount), 0)
+                      FROM "account"."usage_events" e
+                      WHERE e.account_id = u.account_id
+                        AND e.feature = u.feature
+                        AND e.created_at >= u.reset_at), 0);
+
+-- DropForeignKey
+ALTER TABLE "account"."usage_events" DROP CONSTRAINT "usage_events_request_id_fkey";
+
+-- DropForeignKey
+ALTER TABLE "account"."usage_events" DROP CONSTRAINT "usage_events_account_id_fkey";
+
+-- DropTable
+DROP TABLE "account"."usage_events";
+
+-- AlterTable
+ALTER TABLE "subscription"."plan_features"
+  DROP COLUMN "meter";
+
+-- DropEnum
+DROP TYPE "subscription"."meters";
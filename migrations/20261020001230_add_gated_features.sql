@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Gated features have no limit, PlanFree has none of them and gets low quality only
+INSERT INTO "subscription"."plan_features" (plan_id, feature)
+SELECT plan.id, feature.name
+FROM (VALUES ('PlanPro'), ('PlanLifetime')) AS plan (id),
+     (VALUES ('FeatureHighQuality'),
+             ('FeatureExtractAudio'),
+             ('FeatureBatchDownload'),
+             ('FeatureLargeFiles'),
+             ('FeatureInlineMode'),
+             ('FeaturePriorityQueue')) AS feature (name)
+ON CONFLICT (plan_id, feature) DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE
+FROM "subscription"."plan_features"
+WHERE feature IN ('FeatureHighQuality',
+                  'FeatureExtractAudio',
+                  'FeatureBatchDownload',
+                  'FeatureLargeFiles',
+                  'FeatureInlineMode',
+                  'FeaturePriorityQueue');
+
+-- +goose StatementEnd
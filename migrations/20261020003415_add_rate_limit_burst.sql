@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Extra downloads per minute on top of the rate limit, PlanFree has no burst allowance
+INSERT INTO "subscription"."plan_features" (plan_id, feature, "limit")
+VALUES ('PlanPro', 'FeatureRateLimitBurst', 10),
+       ('PlanLifetime', 'FeatureRateLimitBurst', 20)
+ON CONFLICT (plan_id, feature) DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE
+FROM "subscription"."plan_features"
+WHERE feature = 'FeatureRateLimitBurst';
+
+-- +goose StatementEnd
@@ -0,0 +1,49 @@
+-- name: GetAccountChat :one
+SELECT *
+FROM "account"."chats"
+WHERE (
+        id = sqlc.narg('id') OR
+        chat_id = sqlc.narg('chat_id')
+        );
+
+-- name: CreateAccountChat :one
+-- Returns the existing chat if it was created concurrently, the no-op update makes it returned
+INSERT INTO "account"."chats" (chat_id, type, title, enabled, trigger_mode)
+VALUES (sqlc.arg('chat_id'),
+        sqlc.arg('type'),
+        sqlc.arg('title'),
+        sqlc.arg('enabled'),
+        sqlc.arg('trigger_mode'))
+ON CONFLICT (chat_id) DO UPDATE SET chat_id = EXCLUDED.chat_id RETURNING *;
+
+-- name: UpdateAccountChat :one
+UPDATE "account"."chats"
+SET type         = COALESCE(sqlc.narg('type'), type),
+    title        = COALESCE(sqlc.narg('title'), title),
+    enabled      = COALESCE(sqlc.narg('enabled'), enabled),
+    trigger_mode = COALESCE(sqlc.narg('trigger_mode'), trigger_mode),
+    usage        = COALESCE(sqlc.narg('usage'), usage),
+    reset_at     = COALESCE(sqlc.narg('reset_at'), reset_at)
+WHERE id = $1 RETURNING *;
+
+-- name: IncreaseAccountChatUsage :one
+-- Adds to the usage of a chat only if it stays within the limit (0 is unlimited),
+-- the usage of a period that started before reset_before counts as 0
+UPDATE "account"."chats"
+SET usage    = CASE WHEN reset_at <= sqlc.arg('reset_before') THEN 0 ELSE usage END + sqlc.arg('amount')::bigint,
+    reset_at = CASE WHEN reset_at <= sqlc.arg('reset_before') THEN CURRENT_TIMESTAMP ELSE reset_at END
+WHERE id = sqlc.arg('id')
+  AND (sqlc.arg('limit')::bigint = 0 OR
+       CASE WHEN reset_at <= sqlc.arg('reset_before') THEN 0 ELSE usage END + sqlc.arg('amount')::bigint <= sqlc.arg('limit')::bigint)
+RETURNING *;
+
+-- name: ReleaseAccountChatUsage :execrows
+-- Gives back usage that was reserved for a download which was not delivered
+UPDATE "account"."chats"
+SET usage = GREATEST(usage - sqlc.arg('amount')::bigint, 0)
+WHERE id = sqlc.arg('id');
+
+-- name: DeleteAccountChat :exec
+DELETE
+FROM "account"."chats"
+WHERE id = $1;
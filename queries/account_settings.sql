@@ -0,0 +1,35 @@
+-- name: GetAccountSetting :one
+SELECT *
+FROM "account"."settings"
+WHERE (
+        id = sqlc.narg('id') OR
+        account_id = sqlc.narg('account_id')
+        );
+
+-- name: CreateAccountSetting :one
+-- Returns the existing settings of the account if they were created concurrently, the no-op update makes them returned
+INSERT INTO "account"."settings" (account_id, quality, download_mode, caption, language_code)
+VALUES (sqlc.arg('account_id'),
+        sqlc.narg('quality'),
+        sqlc.arg('download_mode'),
+        sqlc.arg('caption'),
+        sqlc.narg('language_code'))
+ON CONFLICT (account_id) DO UPDATE SET account_id = EXCLUDED.account_id RETURNING *;
+
+-- name: UpdateAccountSetting :one
+UPDATE "account"."settings"
+SET quality       = CASE
+                      WHEN sqlc.arg('null_quality')::boolean THEN NULL
+                      ELSE COALESCE(sqlc.narg('quality'), quality) END,
+    download_mode = COALESCE(sqlc.narg('download_mode'), download_mode),
+    caption       = COALESCE(sqlc.narg('caption'), caption),
+    language_code = CASE
+                      WHEN sqlc.arg('null_language_code')::boolean THEN NULL
+                      ELSE COALESCE(sqlc.narg('language_code'), language_code) END,
+    updated_at    = CURRENT_TIMESTAMP
+WHERE id = $1 RETURNING *;
+
+-- name: DeleteAccountSetting :exec
+DELETE
+FROM "account"."settings"
+WHERE id = $1;
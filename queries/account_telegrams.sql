@@ -79,3 +79,38 @@ WHERE id = $1 RETURNING *;
 DELETE
 FROM "account"."telegrams"
 WHERE id = $1;
+
+-- name: GetAccountTelegramByUsername :one
+SELECT *
+FROM "account"."telegrams"
+WHERE LOWER(username) = LOWER(sqlc.arg('username')::text);
+
+-- name: SetAccountTelegramBanned :one
+UPDATE "account"."telegrams"
+SET banned_at = CASE
+                  WHEN sqlc.arg('banned')::boolean THEN COALESCE(banned_at, CURRENT_TIMESTAMP)
+                  ELSE NULL END
+WHERE id = sqlc.arg('id') RETURNING *;
+
+-- name: ListAccountTelegramRecipients :many
+-- Accounts a broadcast is sent to, paginated by id so accounts created meanwhile don't shift the pages
+SELECT id, telegram_id
+FROM "account"."telegrams"
+WHERE id > sqlc.arg('after_id')
+  AND is_bot = false
+  AND banned_at IS NULL
+ORDER BY id ASC LIMIT sqlc.arg('limit');
+
+-- name: ClaimAccountTrial :one
+-- Marks the trial of an account as used, no row is returned if it was used before
+UPDATE "account"."telegrams"
+SET trial_used_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND trial_used_at IS NULL RETURNING *;
+
+-- name: LockAccountTelegram :one
+-- Locks an account until the end of the transaction, changes that span several of its rows wait for each other
+SELECT id
+FROM "account"."telegrams"
+WHERE id = $1
+    FOR UPDATE;
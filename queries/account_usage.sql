@@ -22,18 +22,45 @@ ORDER BY id ASC LIMIT sqlc.arg('limit')
 OFFSET sqlc.arg('offset');
 
 -- name: CreateAccountUsage :one
-INSERT INTO "account"."usage" (account_id, feature, usage)
-VALUES ($1, $2, $3) RETURNING *;
+-- Usage rows hold the period and bonus of a feature, the usage itself is summed from the usage events
+INSERT INTO "account"."usage" (account_id, feature)
+VALUES ($1, $2) RETURNING *;
+
+-- name: LockAccountUsage :one
+-- Creates the usage row of a feature if it is missing and locks it until the end of the transaction,
+-- the no-op update takes the row lock also when the row exists
+INSERT INTO "account"."usage" (account_id, feature)
+VALUES (sqlc.arg('account_id'), sqlc.arg('feature'))
+ON CONFLICT (account_id, feature) DO UPDATE SET account_id = EXCLUDED.account_id RETURNING *;
 
 -- name: UpdateAccountUsage :one
 UPDATE "account"."usage"
 SET account_id = COALESCE(sqlc.narg('account_id'), account_id),
     feature    = COALESCE(sqlc.narg('feature'), feature),
-    usage      = COALESCE(sqlc.narg('usage'), usage),
-    reset_at   = COALESCE(sqlc.narg('reset_at'), reset_at)
+    reset_at   = COALESCE(sqlc.narg('reset_at'), reset_at),
+    bonus      = COALESCE(sqlc.narg('bonus'), bonus)
 WHERE id = $1 RETURNING *;
 
+-- name: StartAccountUsagePeriod :one
+-- Starts a new period at the start of the transaction, the time the usage events of the transaction are created at
+UPDATE "account"."usage"
+SET reset_at = CURRENT_TIMESTAMP,
+    bonus    = sqlc.arg('bonus')
+WHERE id = sqlc.arg('id') RETURNING *;
+
 -- name: DeleteAccountUsage :exec
 DELETE
 FROM "account"."usage"
 WHERE id = $1;
+
+-- name: ResetAccountUsages :execrows
+-- Starts new periods, events before them don't count anymore
+UPDATE "account"."usage"
+SET reset_at = CURRENT_TIMESTAMP
+WHERE account_id = $1;
+
+-- name: AddAccountUsageBonus :one
+-- Bonus usage is allowed on top of the limit of the plan and kept across resets until it is used up
+INSERT INTO "account"."usage" (account_id, feature, bonus)
+VALUES (sqlc.arg('account_id'), sqlc.arg('feature'), sqlc.arg('bonus'))
+ON CONFLICT (account_id, feature) DO UPDATE SET bonus = "usage".bonus + EXCLUDED.bonus RETURNING *;
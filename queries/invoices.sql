@@ -42,10 +42,45 @@ UPDATE "subscription"."invoices"
 SET subscription_id = COALESCE(sqlc.narg('subscription_id'), subscription_id),
     amount          = COALESCE(sqlc.narg('amount'), amount),
     issued_at       = COALESCE(sqlc.narg('issued_at'), issued_at),
-    paid            = COALESCE(sqlc.narg('paid'), paid)
+    paid            = COALESCE(sqlc.narg('paid'), paid),
+    extended_from   = COALESCE(sqlc.narg('extended_from'), extended_from),
+    extended_to     = COALESCE(sqlc.narg('extended_to'), extended_to)
 WHERE id = $1 RETURNING *;
 
 -- name: DeleteInvoice :exec
 DELETE
 FROM "subscription"."invoices"
 WHERE id = $1;
+
+-- name: CreatePlanInvoice :one
+-- Invoice of a plan purchase, the subscription is set once it is paid
+INSERT INTO "subscription"."invoices" (account_id, plan_price_id, amount, currency, provider)
+VALUES (sqlc.arg('account_id'),
+        sqlc.arg('plan_price_id'),
+        sqlc.arg('amount'),
+        sqlc.arg('currency'),
+        sqlc.arg('provider')) RETURNING *;
+
+-- name: SetInvoiceCheckoutID :exec
+UPDATE "subscription"."invoices"
+SET checkout_id = sqlc.arg('checkout_id')
+WHERE id = sqlc.arg('id');
+
+-- name: PayInvoice :one
+-- Marks an invoice as paid once, no row is returned if it already was
+UPDATE "subscription"."invoices"
+SET paid                       = true,
+    paid_at                    = CURRENT_TIMESTAMP,
+    charge_id                  = sqlc.arg('charge_id'),
+    provider_payment_charge_id = sqlc.narg('provider_payment_charge_id')
+WHERE id = sqlc.arg('id')
+  AND paid = false RETURNING *;
+
+-- name: RefundInvoice :one
+-- Marks the paid invoice of a charge as refunded once, no row is returned if it already was
+UPDATE "subscription"."invoices"
+SET refunded_at = CURRENT_TIMESTAMP
+WHERE provider = sqlc.arg('provider')
+  AND charge_id = sqlc.arg('charge_id')
+  AND paid = true
+  AND refunded_at IS NULL RETURNING *;
@@ -0,0 +1,68 @@
+-- name: GetMediaRequest :one
+SELECT *
+FROM "media"."requests"
+WHERE id = $1;
+
+-- name: CountMediaRequests :one
+SELECT COUNT(id)
+FROM "media"."requests"
+WHERE (
+        (account_id = sqlc.narg('account_id') OR sqlc.narg('account_id') IS NULL) AND
+        (status = sqlc.narg('status') OR sqlc.narg('status') IS NULL)
+        );
+
+-- name: ListMediaRequests :many
+SELECT *
+FROM "media"."requests"
+WHERE (
+        (account_id = sqlc.narg('account_id') OR sqlc.narg('account_id') IS NULL) AND
+        (status = sqlc.narg('status') OR sqlc.narg('status') IS NULL)
+        )
+ORDER BY created_at DESC, id DESC LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: CreateMediaRequest :one
+INSERT INTO "media"."requests" (account_id, chat_id, url, saver_type, mode)
+VALUES (sqlc.narg('account_id'),
+        sqlc.arg('chat_id'),
+        sqlc.arg('url'),
+        sqlc.arg('saver_type'),
+        sqlc.arg('mode')) RETURNING *;
+
+-- name: FinishMediaRequest :one
+-- Sets the outcome of a request, its duration is measured from its creation
+UPDATE "media"."requests"
+SET status         = sqlc.arg('status'),
+    error_kind     = sqlc.narg('error_kind'),
+    title          = sqlc.arg('title'),
+    author         = sqlc.arg('author'),
+    size           = sqlc.arg('size'),
+    media_count    = sqlc.arg('media_count'),
+    media_duration = sqlc.arg('media_duration'),
+    files          = sqlc.arg('files'),
+    duration_ms    = (EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - created_at)) * 1000)::bigint,
+    finished_at    = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id') RETURNING *;
+
+-- name: DeleteMediaRequest :exec
+DELETE
+FROM "media"."requests"
+WHERE id = $1;
+
+-- name: GetMediaRequestStats :one
+SELECT COUNT(id)                                                                 AS total,
+       COUNT(id) FILTER (WHERE status = 'SUCCEEDED')                             AS succeeded,
+       COUNT(id) FILTER (WHERE status = 'FAILED')                                AS failed,
+       COALESCE(AVG(duration_ms) FILTER (WHERE status = 'SUCCEEDED'), 0)::bigint AS avg_duration_ms,
+       COALESCE(SUM(size), 0)::bigint                                            AS size
+FROM "media"."requests"
+WHERE created_at >= sqlc.arg('created_at_from');
+
+-- name: ListMediaRequestPlatformStats :many
+SELECT saver_type,
+       COUNT(id)                                     AS total,
+       COUNT(id) FILTER (WHERE status = 'SUCCEEDED') AS succeeded
+FROM "media"."requests"
+WHERE created_at >= sqlc.arg('created_at_from')
+GROUP BY saver_type
+ORDER BY total DESC LIMIT sqlc.arg('limit');
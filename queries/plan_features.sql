@@ -22,15 +22,16 @@ ORDER BY id ASC LIMIT sqlc.arg('limit')
 OFFSET sqlc.arg('offset');
 
 -- name: CreatePlanFeature :one
-INSERT INTO "subscription"."plan_features" (plan_id, feature, "limit", days_to_reset)
-VALUES ($1, $2, $3, $4) RETURNING *;
+INSERT INTO "subscription"."plan_features" (plan_id, feature, "limit", days_to_reset, meter)
+VALUES ($1, $2, $3, $4, $5) RETURNING *;
 
 -- name: UpdatePlanFeature :one
 UPDATE "subscription"."plan_features"
 SET plan_id       = COALESCE(sqlc.narg('plan_id'), plan_id),
     feature       = COALESCE(sqlc.narg('feature'), feature),
     "limit"       = COALESCE(sqlc.narg('limit'), "limit"),
-    days_to_reset = COALESCE(sqlc.narg('days_to_reset'), days_to_reset)
+    days_to_reset = COALESCE(sqlc.narg('days_to_reset'), days_to_reset),
+    meter         = COALESCE(sqlc.narg('meter'), meter)
 WHERE id = $1 RETURNING *;
 
 -- name: DeletePlanFeature :exec
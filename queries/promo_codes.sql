@@ -0,0 +1,37 @@
+-- name: GetPromoCodeByCode :one
+SELECT *
+FROM "subscription"."promo_codes"
+WHERE code = $1;
+
+-- name: CreatePromoCode :one
+INSERT INTO "subscription"."promo_codes" (code, plan_id, days, max_redemptions, expires_at)
+VALUES (sqlc.arg('code'),
+        sqlc.arg('plan_id'),
+        sqlc.arg('days'),
+        sqlc.arg('max_redemptions'),
+        sqlc.narg('expires_at')) RETURNING *;
+
+-- name: ClaimPromoCode :one
+-- Counts a redemption of a code that has not expired or run out, no row is returned otherwise.
+-- The row stays locked until the end of the transaction, concurrent redemptions wait and see the new count.
+UPDATE "subscription"."promo_codes"
+SET redemptions = redemptions + 1
+WHERE code = $1
+  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+  AND (max_redemptions = 0 OR redemptions < max_redemptions) RETURNING *;
+
+-- name: CreatePromoCodeRedemption :one
+-- No row is returned if the account redeemed the code before
+INSERT INTO "subscription"."promo_code_redemptions" (promo_code_id, account_id, subscription_id)
+VALUES (sqlc.arg('promo_code_id'),
+        sqlc.arg('account_id'),
+        sqlc.narg('subscription_id'))
+ON CONFLICT (promo_code_id, account_id) DO NOTHING RETURNING *;
+
+-- name: ListPromoCodeRedemptions :many
+-- Latest redemptions of a code with the accounts that redeemed it
+SELECT sqlc.embed(telegram), redemption.redeemed_at
+FROM "subscription"."promo_code_redemptions" redemption
+       JOIN "account"."telegrams" telegram ON telegram.id = redemption.account_id
+WHERE redemption.promo_code_id = $1
+ORDER BY redemption.redeemed_at DESC LIMIT sqlc.arg('limit');
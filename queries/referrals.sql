@@ -0,0 +1,29 @@
+-- name: GetReferralCodeByCode :one
+SELECT *
+FROM "account"."referral_codes"
+WHERE code = $1;
+
+-- name: GetOrCreateReferralCode :one
+-- Returns the code of the account, the given code is only stored if the account has none yet
+INSERT INTO "account"."referral_codes" (account_id, code)
+VALUES (sqlc.arg('account_id'), sqlc.arg('code'))
+ON CONFLICT (account_id) DO UPDATE SET account_id = EXCLUDED.account_id RETURNING *;
+
+-- name: CreateReferral :one
+-- No row is returned if the referee was referred before
+INSERT INTO "account"."referrals" (referrer_id, referee_id)
+VALUES (sqlc.arg('referrer_id'), sqlc.arg('referee_id'))
+ON CONFLICT (referee_id) DO NOTHING RETURNING *;
+
+-- name: ClaimReferralReward :one
+-- Marks the referral of an account as rewarded, no row is returned if there is none or it was rewarded before
+UPDATE "account"."referrals"
+SET rewarded_at = CURRENT_TIMESTAMP
+WHERE referee_id = $1
+  AND rewarded_at IS NULL RETURNING *;
+
+-- name: GetReferralStats :one
+SELECT COUNT(*)           AS invited,
+       COUNT(rewarded_at) AS rewarded
+FROM "account"."referrals"
+WHERE referrer_id = $1;
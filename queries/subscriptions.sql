@@ -70,3 +70,65 @@ WHERE id = $1 RETURNING *;
 DELETE
 FROM "subscription"."subscriptions"
 WHERE id = $1;
+
+-- name: CancelActiveSubscriptions :execrows
+-- Ends the active and trialing subscriptions of an account, e.g. before another plan is granted
+UPDATE "subscription"."subscriptions"
+SET status    = 'CANCELED',
+    cancel_at = CURRENT_TIMESTAMP
+WHERE account_id = $1
+  AND status IN ('ACTIVE', 'TRIALING');
+
+-- name: CountActiveSubscriptionsByPlan :many
+SELECT plan_id, COUNT(id) AS count
+FROM "subscription"."subscriptions"
+WHERE status = 'ACTIVE'
+  AND (end_date IS NULL OR end_date > CURRENT_TIMESTAMP)
+GROUP BY plan_id
+ORDER BY count DESC;
+
+-- name: LockCurrentSubscriptions :many
+-- Active and trialing subscriptions of an account, locked until the end of the transaction
+SELECT subscription.*
+FROM "subscription"."subscriptions" subscription
+WHERE account_id = $1
+  AND status IN ('ACTIVE', 'TRIALING')
+ORDER BY start_date DESC
+    FOR UPDATE;
+
+-- name: GetCurrentSubscription :one
+-- Latest active or trialing subscription of an account, it may have ended if it was not expired yet
+SELECT subscription.*
+FROM "subscription"."subscriptions" subscription
+WHERE account_id = $1
+  AND status IN ('ACTIVE', 'TRIALING')
+ORDER BY start_date DESC LIMIT 1;
+
+-- name: EndDueSubscriptions :many
+-- Cancels subscriptions past their cancel_at and expires those past their end_date, at most limit at once.
+-- Rows locked by another instance are skipped, each subscription is ended once.
+UPDATE "subscription"."subscriptions"
+SET status = CASE
+               WHEN cancel_at <= CURRENT_TIMESTAMP THEN 'CANCELED'::"subscription"."statuses"
+               ELSE 'EXPIRED'::"subscription"."statuses" END
+WHERE id IN (SELECT id
+             FROM "subscription"."subscriptions"
+             WHERE status IN ('ACTIVE', 'TRIALING')
+               AND (end_date <= CURRENT_TIMESTAMP OR cancel_at <= CURRENT_TIMESTAMP)
+             ORDER BY end_date
+             LIMIT sqlc.arg('limit') FOR UPDATE SKIP LOCKED) RETURNING *;
+
+-- name: ClaimSubscriptionReminders :many
+-- Marks paid subscriptions ending within days as reminded and returns them, at most limit at once.
+-- A subscription extended after its reminder is reminded again before its new end.
+UPDATE "subscription"."subscriptions"
+SET reminded_at = CURRENT_TIMESTAMP
+WHERE id IN (SELECT id
+             FROM "subscription"."subscriptions"
+             WHERE status IN ('ACTIVE', 'TRIALING')
+               AND plan_id <> 'PlanFree'
+               AND end_date > CURRENT_TIMESTAMP
+               AND end_date <= CURRENT_TIMESTAMP + make_interval(days => sqlc.arg('days')::int)
+               AND (reminded_at IS NULL OR reminded_at < end_date - make_interval(days => sqlc.arg('days')::int))
+             ORDER BY end_date
+             LIMIT sqlc.arg('limit') FOR UPDATE SKIP LOCKED) RETURNING *;
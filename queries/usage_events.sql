@@ -0,0 +1,25 @@
+-- name: CreateUsageEvent :one
+-- Events are never updated or deleted, released usage is recorded as an event with negative amounts
+INSERT INTO "account"."usage_events" (account_id, feature, amount, items, bytes, request_id)
+VALUES (sqlc.arg('account_id'),
+        sqlc.arg('feature'),
+        sqlc.arg('amount'),
+        sqlc.arg('items'),
+        sqlc.arg('bytes'),
+        sqlc.narg('request_id')) RETURNING *;
+
+-- name: SumUsageEvents :one
+-- Usage of a feature in the period that started at since, by every meter
+SELECT COALESCE(SUM(amount), 0)::bigint AS amount,
+       COALESCE(SUM(items), 0)::bigint  AS items,
+       COALESCE(SUM(bytes), 0)::bigint  AS bytes
+FROM "account"."usage_events"
+WHERE account_id = sqlc.arg('account_id')
+  AND feature = sqlc.arg('feature')
+  AND created_at >= sqlc.arg('since');
+
+-- name: ListUsageEvents :many
+SELECT *
+FROM "account"."usage_events"
+WHERE account_id = sqlc.arg('account_id')
+ORDER BY id DESC LIMIT sqlc.arg('limit');